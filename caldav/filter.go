@@ -0,0 +1,193 @@
+package caldav
+
+import (
+	"strings"
+	"time"
+)
+
+// compFilter is a CALDAV:comp-filter of a calendar-query report. It matches
+// a component which has a child component of its name meeting its conditions
+// or, with is-not-defined, which has none.
+type compFilter struct {
+	Name         string        `xml:"name,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange    `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	PropFilters  []*propFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	CompFilters  []*compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+// propFilter is a CALDAV:prop-filter. It matches a component which has a
+// property of its name meeting its conditions or, with is-not-defined, which
+// has none. Parameter filters are not supported, and are ignored.
+type propFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+}
+
+// timeRange is a CALDAV:time-range. Either end may be absent, leaving the
+// range unbounded at that end.
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+
+	start, end time.Time
+}
+
+// textMatch is a CALDAV:text-match, which matches text containing its value.
+type textMatch struct {
+	Value     string `xml:",chardata"`
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+}
+
+// parse checks the time ranges of f and those nested in it, returning false
+// if any is malformed.
+func (f *compFilter) parse() bool {
+	if f.TimeRange != nil && !f.TimeRange.parse() {
+		return false
+	}
+	for _, pf := range f.PropFilters {
+		if pf.TimeRange != nil && !pf.TimeRange.parse() {
+			return false
+		}
+	}
+	for _, cf := range f.CompFilters {
+		if !cf.parse() {
+			return false
+		}
+	}
+	return true
+}
+
+func (tr *timeRange) parse() bool {
+	var err error
+	if tr.Start != "" {
+		if tr.start, err = time.Parse(utcLayout, tr.Start); err != nil {
+			return false
+		}
+	}
+	if tr.End != "" {
+		if tr.end, err = time.Parse(utcLayout, tr.End); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether parent matches f.
+func (f *compFilter) match(parent *component) bool {
+	var found bool
+	for _, c := range parent.components {
+		if !strings.EqualFold(c.name, f.Name) {
+			continue
+		}
+		found = true
+
+		if f.IsNotDefined == nil && f.matchComponent(c) {
+			return true
+		}
+	}
+
+	return f.IsNotDefined != nil && !found
+}
+
+// matchComponent reports whether c, a component of f's name, meets its
+// conditions.
+func (f *compFilter) matchComponent(c *component) bool {
+	if f.TimeRange != nil && !f.TimeRange.matchTodo(c) {
+		return false
+	}
+	for _, pf := range f.PropFilters {
+		if !pf.match(c) {
+			return false
+		}
+	}
+	for _, cf := range f.CompFilters {
+		if !cf.match(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pf *propFilter) match(c *component) bool {
+	var found bool
+	for _, p := range c.props {
+		if !strings.EqualFold(p.name, pf.Name) {
+			continue
+		}
+		found = true
+
+		if pf.IsNotDefined != nil {
+			continue
+		}
+		if pf.TimeRange != nil {
+			at, err := p.time()
+			if err != nil || !pf.TimeRange.contains(at) {
+				continue
+			}
+		}
+		if pf.TextMatch != nil && !pf.TextMatch.match(p.text()) {
+			continue
+		}
+		return true
+	}
+
+	return pf.IsNotDefined != nil && !found
+}
+
+func (tm *textMatch) match(text string) bool {
+	var contains bool
+	if tm.Collation == "i;octet" {
+		contains = strings.Contains(text, tm.Value)
+	} else {
+		contains = strings.Contains(strings.ToLower(text), strings.ToLower(tm.Value))
+	}
+	return contains != (tm.Negate == "yes")
+}
+
+// contains reports whether at falls in the range.
+func (tr *timeRange) contains(at time.Time) bool {
+	return (tr.start.IsZero() || !at.Before(tr.start)) && (tr.end.IsZero() || at.Before(tr.end))
+}
+
+// matchTodo reports whether the VTODO todo overlaps the range, following the
+// rules of RFC 4791 section 9.9 for VTODOs without a DTSTART, which those
+// served never have.
+func (tr *timeRange) matchTodo(todo *component) bool {
+	// A range without a start begins at the zero time, which is before any
+	// served, so only a missing end needs filling in.
+	start, end := tr.start, tr.end
+	if end.IsZero() {
+		end = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+	}
+
+	at := func(name string) (time.Time, bool) {
+		p := todo.prop(name)
+		if p == nil {
+			return time.Time{}, false
+		}
+		t, err := p.time()
+		return t, err == nil
+	}
+
+	due, hasDue := at("DUE")
+	completed, hasCompleted := at("COMPLETED")
+	created, hasCreated := at("CREATED")
+
+	switch {
+	case hasDue:
+		return !start.After(due) && end.After(due)
+	case hasCompleted && hasCreated:
+		return (!start.After(created) || !start.After(completed)) &&
+			(!end.Before(created) || !end.Before(completed))
+	case hasCompleted:
+		return !start.After(completed) && !end.Before(completed)
+	case hasCreated:
+		return end.After(created)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,621 @@
+// Package caldav serves tasks to calendar and reminder apps as a minimal
+// CalDAV server, described by RFC 4791.
+//
+// A single calendar holds every task as a VTODO. Its resources are named
+// after the IDs of their tasks, so tasks are created by PUT to the name of a
+// new task ID. Only the summary, due date and completion of a VTODO are
+// stored; anything else a client writes is discarded.
+//
+// Clients discover the calendar from the handler's prefix, which is both the
+// principal and its calendar home:
+//
+//	{prefix}/                the principal and its calendar home
+//	{prefix}/tasks/          the calendar of tasks
+//	{prefix}/tasks/{id}.ics  a task
+package caldav
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+const (
+	// DefaultPrefix is the path the handler is served under, unless another
+	// is set with WithPrefix.
+	DefaultPrefix = "/caldav"
+
+	// calendarName is the name of the calendar of tasks in the calendar home.
+	calendarName = "tasks"
+
+	calendarType = "text/calendar"
+
+	// maxObjectSize is the largest calendar object accepted, in bytes.
+	maxObjectSize = 1 << 20
+
+	// listPageSize is the number of tasks fetched at a time when the whole
+	// calendar is listed.
+	listPageSize = 500
+)
+
+func init() {
+	chi.RegisterMethod("PROPFIND")
+	chi.RegisterMethod("REPORT")
+}
+
+// Handler is an HTTP handler serving tasks over CalDAV.
+type Handler struct {
+	router chi.Router
+	logger *zap.Logger
+	repo   tasks.TaskRepository
+	prefix string
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithPrefix sets the path the handler is served under, which is needed to
+// link resources to one another.
+func WithPrefix(prefix string) Option {
+	return func(h *Handler) {
+		h.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// New creates a new Handler
+func New(logger *zap.Logger, tr tasks.TaskRepository, opts ...Option) *Handler {
+	h := &Handler{
+		router: chi.NewRouter(),
+		logger: logger,
+		repo:   tr,
+		prefix: DefaultPrefix,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.routes()
+
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
+func (h *Handler) routes() {
+	h.router.Use(middleware.RequestID)
+	h.router.Use(middleware.RealIP)
+	h.router.Use(middleware.Recoverer)
+
+	h.router.Route(h.prefix, func(r chi.Router) {
+		r.Use(davHeaders)
+
+		r.Options("/*", func(w http.ResponseWriter, r *http.Request) {})
+
+		r.MethodFunc("PROPFIND", "/", h.propfindHome())
+		// Collections are served with or without a trailing slash, as
+		// clients are not consistent about it.
+		for _, path := range []string{"/" + calendarName, "/" + calendarName + "/"} {
+			r.MethodFunc("PROPFIND", path, h.propfindCalendar())
+			r.MethodFunc("REPORT", path, h.report())
+		}
+
+		r.MethodFunc("PROPFIND", "/"+calendarName+"/{id}.ics", h.propfindObject())
+		r.Get("/"+calendarName+"/{id}.ics", h.objectGet())
+		r.Head("/"+calendarName+"/{id}.ics", h.objectGet())
+		r.Put("/"+calendarName+"/{id}.ics", h.objectPut())
+		r.Delete("/"+calendarName+"/{id}.ics", h.objectDelete())
+	})
+}
+
+// davHeaders advertises the WebDAV and CalDAV features served.
+func davHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) homePath() string {
+	return h.prefix + "/"
+}
+
+func (h *Handler) calendarPath() string {
+	return h.prefix + "/" + calendarName + "/"
+}
+
+func (h *Handler) objectPath(id string) string {
+	return h.calendarPath() + id + ".ics"
+}
+
+// home returns the resource of the principal and its calendar home.
+func (h *Handler) home() *resource {
+	return &resource{
+		href: h.homePath(),
+		props: []*prop{
+			{propResourceType, "<D:collection/><D:principal/>"},
+			{propDisplayName, "Tasks"},
+			{propCurrentUserPrincipal, href(h.homePath())},
+			{propPrincipalURL, href(h.homePath())},
+			{propHomeSet, href(h.homePath())},
+		},
+	}
+}
+
+// calendar returns the resource of the calendar holding objects.
+func (h *Handler) calendar(objects []*object) *resource {
+	// The CTag changes whenever any task in the calendar does, so that
+	// clients can tell whether anything has changed without listing it.
+	sum := sha256.New()
+	for _, o := range objects {
+		io.WriteString(sum, o.task.ID+o.etag)
+	}
+
+	return &resource{
+		href: h.calendarPath(),
+		props: []*prop{
+			{propResourceType, "<D:collection/><C:calendar/>"},
+			{propDisplayName, "Tasks"},
+			{propCurrentUserPrincipal, href(h.homePath())},
+			{propComponents, `<C:comp name="VTODO"/>`},
+			{propSupportedReports, "<D:supported-report><D:report><C:calendar-query/></D:report></D:supported-report>" +
+				"<D:supported-report><D:report><C:calendar-multiget/></D:report></D:supported-report>"},
+			{propPrivileges, "<D:privilege><D:read/></D:privilege><D:privilege><D:write/></D:privilege>"},
+			{propCTag, escape(`"` + base64.RawURLEncoding.EncodeToString(sum.Sum(nil)[:16]) + `"`)},
+		},
+	}
+}
+
+// object is a task as a calendar object.
+type object struct {
+	task *tasks.Task
+	cal  *component
+	data []byte
+	etag string
+}
+
+func newObject(t *tasks.Task) *object {
+	o := &object{task: t, cal: calendarObject(t)}
+
+	var b bytes.Buffer
+	o.cal.encode(&b)
+	o.data = b.Bytes()
+
+	sum := sha256.Sum256(o.data)
+	o.etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	return o
+}
+
+// resource returns the resource of o.
+func (h *Handler) resource(o *object) *resource {
+	return &resource{
+		href: h.objectPath(o.task.ID),
+		props: []*prop{
+			{propResourceType, ""},
+			{propETag, escape(o.etag)},
+			{propContentType, calendarType + "; charset=utf-8; component=VTODO"},
+			{propLastModified, o.task.UpdatedAt.UTC().Format(http.TimeFormat)},
+			{propCalendarData, escape(string(o.data))},
+		},
+	}
+}
+
+// objects returns every task in the calendar.
+func (h *Handler) objects() ([]*object, error) {
+	var (
+		objects []*object
+		opts    = tasks.ListOptions{Limit: listPageSize}
+	)
+	for {
+		page, err := h.repo.ListTasks(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+
+		for _, t := range page.Tasks {
+			objects = append(objects, newObject(t))
+		}
+
+		if page.Next == nil {
+			return objects, nil
+		}
+		opts.Cursor = page.Next
+	}
+}
+
+// readQuery reads the property query of a PROPFIND request. A request
+// without a body asks for all properties.
+func readQuery(r *http.Request) (*propQuery, error) {
+	q := &propQuery{}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxObjectSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return q, nil
+	}
+
+	var req struct {
+		XMLName xml.Name `xml:"DAV: propfind"`
+		propQuery
+	}
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return nil, errMalformedXML
+	}
+	return &req.propQuery, nil
+}
+
+// errMalformedXML is returned for request bodies which are not the XML
+// expected.
+var errMalformedXML = errors.New("malformed xml")
+
+// depth returns the Depth header of r. A missing or infinite depth is taken
+// to be 1, as nothing served is nested any deeper.
+func depth(r *http.Request) int {
+	if r.Header.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+func (h *Handler) propfindHome() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := readQuery(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		responses := []*response{q.answer(h.home())}
+		if depth(r) > 0 {
+			objects, err := h.objects()
+			if err != nil {
+				h.respondError(w, r, err)
+				return
+			}
+			responses = append(responses, q.answer(h.calendar(objects)))
+		}
+
+		writeMultistatus(w, responses)
+	}
+}
+
+func (h *Handler) propfindCalendar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := readQuery(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		objects, err := h.objects()
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		responses := []*response{q.answer(h.calendar(objects))}
+		if depth(r) > 0 {
+			for _, o := range objects {
+				responses = append(responses, q.answer(h.resource(o)))
+			}
+		}
+
+		writeMultistatus(w, responses)
+	}
+}
+
+func (h *Handler) propfindObject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := readQuery(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		t, err := h.retrieve(chi.URLParam(r, "id"))
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		writeMultistatus(w, []*response{q.answer(h.resource(newObject(t)))})
+	}
+}
+
+// reportRequest is the body of a calendar-query or calendar-multiget report.
+type reportRequest struct {
+	XMLName xml.Name
+	propQuery
+
+	// Filter is the filter of a calendar-query, which must be given.
+	Filter *compFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+
+	// Hrefs are the resources asked for by a calendar-multiget.
+	Hrefs []string `xml:"DAV: href"`
+}
+
+func (h *Handler) report() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxObjectSize))
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to read request body: %w", err))
+			return
+		}
+
+		var req reportRequest
+		if err := xml.Unmarshal(data, &req); err != nil {
+			h.respondError(w, r, errMalformedXML)
+			return
+		}
+
+		switch req.XMLName {
+		case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+			h.calendarQuery(w, r, &req)
+		case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+			h.calendarMultiget(w, r, &req)
+		default:
+			writeError(w, http.StatusForbidden, nsDAV, "supported-report", "only calendar-query and calendar-multiget reports are supported")
+		}
+	}
+}
+
+func (h *Handler) calendarQuery(w http.ResponseWriter, r *http.Request, req *reportRequest) {
+	if req.Filter == nil || !req.Filter.parse() {
+		writeError(w, http.StatusForbidden, nsCalDAV, "valid-filter", "")
+		return
+	}
+
+	objects, err := h.objects()
+	if err != nil {
+		h.respondError(w, r, err)
+		return
+	}
+
+	responses := make([]*response, 0, len(objects))
+	for _, o := range objects {
+		// The filter's outermost component is matched against the object
+		// itself, so the object is given a parent to be found in.
+		if req.Filter.match(&component{components: []*component{o.cal}}) {
+			responses = append(responses, req.answer(h.resource(o)))
+		}
+	}
+
+	writeMultistatus(w, responses)
+}
+
+func (h *Handler) calendarMultiget(w http.ResponseWriter, r *http.Request, req *reportRequest) {
+	responses := make([]*response, 0, len(req.Hrefs))
+	for _, ref := range req.Hrefs {
+		ref = strings.TrimSpace(ref)
+
+		id := strings.TrimSuffix(strings.TrimPrefix(ref, h.calendarPath()), ".ics")
+		if !strings.HasPrefix(ref, h.calendarPath()) || ref != h.objectPath(id) {
+			responses = append(responses, &response{href: ref, status: http.StatusNotFound})
+			continue
+		}
+		if id = canonicalID(id); !tasks.ValidTaskID(id) {
+			responses = append(responses, &response{href: ref, status: http.StatusNotFound})
+			continue
+		}
+
+		t, err := h.repo.RetrieveTask(id)
+		if errors.Is(err, tasks.ErrTaskNotFound) {
+			responses = append(responses, &response{href: ref, status: http.StatusNotFound})
+			continue
+		}
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to retrieve task: %w", err))
+			return
+		}
+
+		responses = append(responses, req.answer(h.resource(newObject(t))))
+	}
+
+	writeMultistatus(w, responses)
+}
+
+// canonicalID returns id in the canonical, lowercase form of a UUID if it is
+// one in another case, as reminder apps name the objects they create after
+// uppercase UUIDs. Any other id is returned as is.
+func canonicalID(id string) string {
+	if lower := strings.ToLower(id); len(id) == 36 && tasks.ValidTaskID(lower) {
+		return lower
+	}
+	return id
+}
+
+// retrieve retrieves the task with the given id, which is not found if it is
+// not a task ID.
+func (h *Handler) retrieve(id string) (*tasks.Task, error) {
+	id = canonicalID(id)
+	if !tasks.ValidTaskID(id) {
+		return nil, tasks.ErrTaskNotFound
+	}
+
+	t, err := h.repo.RetrieveTask(id)
+	if err != nil && !errors.Is(err, tasks.ErrTaskNotFound) {
+		return nil, fmt.Errorf("failed to retrieve task: %w", err)
+	}
+	return t, err
+}
+
+func (h *Handler) objectGet() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := h.retrieve(chi.URLParam(r, "id"))
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		o := newObject(t)
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Last-Modified", t.UpdatedAt.UTC().Format(http.TimeFormat))
+		if matchETag(r.Header.Get("If-None-Match"), o.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", calendarType+"; charset=utf-8")
+		w.Write(o.data)
+	}
+}
+
+// errETagMismatch is returned from patches whose If-Match precondition fails.
+var errETagMismatch = errors.New("etag does not match")
+
+func (h *Handler) objectPut() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := canonicalID(chi.URLParam(r, "id"))
+		if !tasks.ValidTaskID(id) {
+			http.Error(w, "tasks must be named after a task ID", http.StatusForbidden)
+			return
+		}
+
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != calendarType {
+				writeError(w, http.StatusUnsupportedMediaType, nsCalDAV, "supported-calendar-data", "content type must be "+calendarType)
+				return
+			}
+		}
+
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxObjectSize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, nsCalDAV, "max-resource-size", "")
+			return
+		}
+
+		cal, err := parseCalendar(data)
+		if err != nil {
+			writeError(w, http.StatusForbidden, nsCalDAV, "valid-calendar-data", err.Error())
+			return
+		}
+
+		t, err := decodeTask(cal)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+		if t.ID != "" && canonicalID(t.ID) != id {
+			writeError(w, http.StatusForbidden, nsCalDAV, "valid-calendar-object-resource", "UID must match the resource name")
+			return
+		}
+		t.ID = id
+
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+
+		// An existing task is patched, so that its If-Match precondition is
+		// checked against the same version of it that is changed.
+		if ifNoneMatch != "*" {
+			_, err := h.repo.PatchTask(id, func(e *tasks.Task) error {
+				if ifMatch != "" && !matchETag(ifMatch, newObject(e).etag) {
+					return errETagMismatch
+				}
+				e.Text, e.IsComplete, e.DueAt = t.Text, t.IsComplete, t.DueAt
+				return nil
+			})
+			switch {
+			case err == nil:
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case errors.Is(err, errETagMismatch):
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			case !errors.Is(err, tasks.ErrTaskNotFound):
+				h.respondError(w, r, fmt.Errorf("failed to patch task: %w", err))
+				return
+			}
+		} else if _, err := h.retrieve(id); err == nil {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		} else if !errors.Is(err, tasks.ErrTaskNotFound) {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if ifMatch != "" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		if _, err := h.repo.UpsertTask(t); err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to create task: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (h *Handler) objectDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := h.retrieve(chi.URLParam(r, "id"))
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchETag(ifMatch, newObject(t).etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		if _, err := h.repo.DeleteTask(t.ID); err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to delete task: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// matchETag reports whether the If-Match or If-None-Match header value list
+// holds etag, or is *. Weak tags match their strong equivalents.
+func matchETag(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondError responds to a request which failed because of err. Errors
+// which are not described by the handler are logged and hidden from the
+// client.
+func (h *Handler) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var objErr *objectError
+	switch {
+	case errors.As(err, &objErr):
+		writeError(w, http.StatusForbidden, nsCalDAV, objErr.precondition, objErr.message)
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		http.Error(w, "task not found", http.StatusNotFound)
+	case errors.Is(err, errMalformedXML):
+		http.Error(w, "malformed xml", http.StatusBadRequest)
+	default:
+		h.logger.Error("caldav request failed",
+			zap.String("request_id", middleware.GetReqID(r.Context())),
+			zap.Error(err),
+		)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
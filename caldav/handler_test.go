@@ -0,0 +1,209 @@
+package caldav
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/mock"
+)
+
+func serve(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func newTask(text string, complete bool, due *time.Time) *tasks.Task {
+	now := time.Date(2020, time.March, 1, 10, 0, 0, 0, time.UTC)
+	t := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now, Text: text, IsComplete: complete, DueAt: due}
+	if complete {
+		t.CompletedAt = &now
+	}
+	return t
+}
+
+func TestPropfind(t *testing.T) {
+	is := is.New(t)
+
+	task := newTask("call mom", false, nil)
+	h := New(zap.NewNop(), mock.New(task), WithPrefix("/dav/"))
+
+	rr := serve(h, "OPTIONS", "/dav/", "")
+	is.Equal(rr.Code, http.StatusOK)                                     // Status should equal 200
+	is.True(strings.Contains(rr.Header().Get("DAV"), "calendar-access")) // CalDAV is advertised
+
+	rr = serve(h, "PROPFIND", "/dav/", `<?xml version="1.0"?>
+<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+	<prop><current-user-principal/><C:calendar-home-set/><quota-used-bytes/></prop>
+</propfind>`, "Depth", "0")
+	body := rr.Body.String()
+	is.Equal(rr.Code, http.StatusMultiStatus)                                                                      // Status should equal 207
+	is.True(strings.Contains(body, "<D:current-user-principal><D:href>/dav/</D:href></D:current-user-principal>")) // Principal is found
+	is.True(strings.Contains(body, "<C:calendar-home-set><D:href>/dav/</D:href></C:calendar-home-set>"))           // Calendar home is found
+	is.True(strings.Contains(body, "<D:quota-used-bytes/></D:prop><D:status>HTTP/1.1 404 Not Found"))              // Unknown properties are not found
+	is.True(!strings.Contains(body, "/dav/tasks/"))                                                                // Depth 0 lists nothing else
+
+	rr = serve(h, "PROPFIND", "/dav/tasks/", "", "Depth", "1")
+	body = rr.Body.String()
+	is.Equal(rr.Code, http.StatusMultiStatus)                                      // Status should equal 207
+	is.True(strings.Contains(body, "<C:calendar/>"))                               // Calendar is a calendar
+	is.True(strings.Contains(body, `<C:comp name="VTODO"/>`))                      // Calendar holds VTODOs
+	is.True(strings.Contains(body, "<CS:getctag>"))                                // Calendar has a CTag
+	is.True(strings.Contains(body, "<D:href>/dav/tasks/"+task.ID+".ics</D:href>")) // Tasks are listed
+	is.True(strings.Contains(body, "<D:getetag>"))                                 // Tasks have ETags
+	is.True(!strings.Contains(body, "calendar-data"))                              // Calendar data is not sent unless asked for
+
+	rr = serve(h, "PROPFIND", "/dav/tasks/"+tasks.NewTaskID()+".ics", "", "Depth", "0")
+	is.Equal(rr.Code, http.StatusNotFound) // Missing tasks are not found
+
+	rr = serve(h, "PROPFIND", "/dav/", "<propfind")
+	is.Equal(rr.Code, http.StatusBadRequest) // Malformed XML is rejected
+}
+
+func TestReport(t *testing.T) {
+	is := is.New(t)
+
+	due := time.Date(2020, time.March, 10, 0, 0, 0, 0, time.UTC)
+	open := newTask("call mom", false, &due)
+	done := newTask("pay rent", true, nil)
+	h := New(zap.NewNop(), mock.New(open, done))
+
+	query := func(filter string) string {
+		rr := serve(h, "REPORT", "/caldav/tasks/", `<?xml version="1.0"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+	<D:prop><D:getetag/><C:calendar-data/></D:prop>
+	<C:filter><C:comp-filter name="VCALENDAR">`+filter+`</C:comp-filter></C:filter>
+</C:calendar-query>`, "Depth", "1")
+		is.Equal(rr.Code, http.StatusMultiStatus) // Status should equal 207
+		return rr.Body.String()
+	}
+
+	body := query(`<C:comp-filter name="VTODO"/>`)
+	is.True(strings.Contains(body, open.ID))                   // Open task is found
+	is.True(strings.Contains(body, done.ID))                   // Completed task is found
+	is.True(strings.Contains(body, "SUMMARY:call mom&#13;\n")) // Calendar data is sent with its line endings
+
+	body = query(`<C:comp-filter name="VTODO"><C:prop-filter name="COMPLETED"><C:is-not-defined/></C:prop-filter></C:comp-filter>`)
+	is.True(strings.Contains(body, open.ID))  // Tasks without COMPLETED match
+	is.True(!strings.Contains(body, done.ID)) // Completed tasks do not
+
+	body = query(`<C:comp-filter name="VTODO"><C:prop-filter name="SUMMARY"><C:text-match>RENT</C:text-match></C:prop-filter></C:comp-filter>`)
+	is.True(!strings.Contains(body, open.ID)) // Text must match
+	is.True(strings.Contains(body, done.ID))  // Text is matched ignoring case
+
+	body = query(`<C:comp-filter name="VTODO"><C:time-range start="20200309T000000Z" end="20200311T000000Z"/></C:comp-filter>`)
+	is.True(strings.Contains(body, open.ID))  // Tasks due in the range match
+	is.True(!strings.Contains(body, done.ID)) // Tasks created before the range do not
+
+	body = query(`<C:comp-filter name="VEVENT"/>`)
+	is.True(!strings.Contains(body, "<D:response>")) // There are no events
+
+	rr := serve(h, "REPORT", "/caldav/tasks/", `<?xml version="1.0"?>
+<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+	<D:prop><D:getetag/></D:prop>
+	<D:href>/caldav/tasks/`+done.ID+`.ics</D:href>
+	<D:href>/caldav/tasks/missing.ics</D:href>
+</C:calendar-multiget>`)
+	body = rr.Body.String()
+	is.Equal(rr.Code, http.StatusMultiStatus)                                                                                // Status should equal 207
+	is.True(strings.Contains(body, "<D:href>/caldav/tasks/"+done.ID+".ics</D:href><D:propstat>"))                            // Tasks asked for are sent
+	is.True(strings.Contains(body, "<D:href>/caldav/tasks/missing.ics</D:href><D:status>HTTP/1.1 404 Not Found</D:status>")) // Missing tasks are not found
+
+	rr = serve(h, "REPORT", "/caldav/tasks/", `<D:sync-collection xmlns:D="DAV:"/>`)
+	is.Equal(rr.Code, http.StatusForbidden)                         // Status should equal 403
+	is.True(strings.Contains(rr.Body.String(), "supported-report")) // Unsupported reports are refused
+}
+
+func TestObjects(t *testing.T) {
+	is := is.New(t)
+
+	repo := mock.New()
+	h := New(zap.NewNop(), repo)
+
+	id := tasks.NewTaskID()
+	path := "/caldav/tasks/" + id + ".ics"
+	ics := func(extra string) string {
+		return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VTODO\r\nUID:" + id + "\r\n" +
+			"SUMMARY:Buy milk\\, eggs\r\nDUE;TZID=Europe/Berlin:20200305T100000\r\n" + extra +
+			"END:VTODO\r\nEND:VCALENDAR\r\n"
+	}
+
+	rr := serve(h, http.MethodPut, path, ics(""), "Content-Type", "text/calendar", "If-None-Match", "*")
+	is.Equal(rr.Code, http.StatusCreated) // Status should equal 201
+
+	task, err := repo.RetrieveTask(id)
+	is.NoErr(err)                                                               // Task is created with the ID of its name
+	is.Equal(task.Text, "Buy milk, eggs")                                       // Summary is unescaped
+	is.Equal(*task.DueAt, time.Date(2020, time.March, 5, 9, 0, 0, 0, time.UTC)) // Due date is converted from its time zone
+	is.True(!task.IsComplete)                                                   // Task is not complete
+
+	rr = serve(h, http.MethodPut, path, ics(""), "If-None-Match", "*")
+	is.Equal(rr.Code, http.StatusPreconditionFailed) // Existing tasks are not created again
+
+	rr = serve(h, http.MethodGet, path, "")
+	is.Equal(rr.Code, http.StatusOK)                                          // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "text/calendar; charset=utf-8") // Tasks are iCalendar
+	is.True(strings.Contains(rr.Body.String(), "DUE:20200305T090000Z\r\n"))   // Due date is sent in UTC
+	etag := rr.Header().Get("ETag")
+
+	rr = serve(h, http.MethodGet, path, "", "If-None-Match", etag)
+	is.Equal(rr.Code, http.StatusNotModified) // Unchanged tasks are not sent again
+
+	rr = serve(h, http.MethodPut, path, ics("STATUS:COMPLETED\r\n"), "If-Match", `"stale"`)
+	is.Equal(rr.Code, http.StatusPreconditionFailed) // Stale ETags are refused
+
+	rr = serve(h, http.MethodPut, path, ics("STATUS:COMPLETED\r\n"), "If-Match", etag)
+	is.Equal(rr.Code, http.StatusNoContent) // Status should equal 204
+
+	task, err = repo.RetrieveTask(id)
+	is.NoErr(err)                    // Error from RetrieveTask
+	is.True(task.IsComplete)         // Completion is stored
+	is.True(task.CompletedAt != nil) // Completion time is recorded
+
+	rr = serve(h, http.MethodGet, path, "")
+	is.True(rr.Header().Get("ETag") != etag)                            // ETag changes with the task
+	is.True(strings.Contains(rr.Body.String(), "STATUS:COMPLETED\r\n")) // Completion is sent
+
+	rr = serve(h, http.MethodPut, path, strings.Replace(ics(""), "VTODO", "VEVENT", -1))
+	is.Equal(rr.Code, http.StatusForbidden)                                     // Status should equal 403
+	is.True(strings.Contains(rr.Body.String(), "supported-calendar-component")) // Events are refused
+
+	rr = serve(h, http.MethodPut, path, strings.Replace(ics(""), "SUMMARY:Buy milk\\, eggs\r\n", "", 1))
+	is.Equal(rr.Code, http.StatusForbidden) // Tasks must have a summary
+
+	rr = serve(h, http.MethodPut, "/caldav/tasks/my-reminder.ics", ics(""))
+	is.Equal(rr.Code, http.StatusForbidden) // Tasks must be named after task IDs
+
+	rr = serve(h, http.MethodDelete, path, "", "If-Match", etag)
+	is.Equal(rr.Code, http.StatusPreconditionFailed) // Stale ETags are refused
+
+	rr = serve(h, http.MethodDelete, path, "")
+	is.Equal(rr.Code, http.StatusNoContent) // Status should equal 204
+
+	_, err = repo.RetrieveTask(id)
+	is.Equal(err, tasks.ErrTaskNotFound) // Task is deleted
+
+	rr = serve(h, http.MethodPut, path, ics(""), "If-Match", etag)
+	is.Equal(rr.Code, http.StatusPreconditionFailed) // Missing tasks do not match
+
+	upper := "/caldav/tasks/" + strings.ToUpper(id) + ".ics"
+	rr = serve(h, http.MethodPut, upper, strings.Replace(ics(""), id, strings.ToUpper(id), 1), "Content-Type", "text/calendar")
+	is.Equal(rr.Code, http.StatusCreated) // Uppercase UUIDs name tasks
+
+	_, err = repo.RetrieveTask(id)
+	is.NoErr(err) // Task is created with the lowercase ID
+
+	rr = serve(h, http.MethodGet, upper, "")
+	is.Equal(rr.Code, http.StatusOK) // Task is found under the name it was created with
+}
@@ -0,0 +1,381 @@
+package caldav
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"example.com/tasks"
+)
+
+const (
+	// utcLayout and dateLayout are the layouts of iCalendar DATE-TIME values
+	// in UTC and of DATE values.
+	utcLayout  = "20060102T150405Z"
+	dateLayout = "20060102"
+
+	// localLayout is the layout of DATE-TIME values in local or floating
+	// time.
+	localLayout = "20060102T150405"
+
+	// maxLineLength is the longest content line written, in octets, before
+	// it is folded.
+	maxLineLength = 75
+
+	// prodID identifies the product which wrote a calendar object.
+	prodID = "-//example.com//tasks//EN"
+)
+
+// component is an iCalendar component, such as VCALENDAR or VTODO, with its
+// properties and the components nested in it.
+type component struct {
+	name       string
+	props      []*property
+	components []*component
+}
+
+// property is a property of a component. Its value is kept as written, with
+// text still escaped.
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// prop returns the first property of c with the given name, or nil.
+func (c *component) prop(name string) *property {
+	for _, p := range c.props {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// add adds a property to c.
+func (c *component) add(name, value string, params ...string) {
+	p := &property{name: name, value: value}
+	if len(params) > 0 {
+		p.params = make(map[string]string)
+		for i := 0; i+1 < len(params); i += 2 {
+			p.params[params[i]] = params[i+1]
+		}
+	}
+	c.props = append(c.props, p)
+}
+
+// encode writes c as iCalendar content lines, folded and ending in CRLF.
+func (c *component) encode(b *bytes.Buffer) {
+	writeLine(b, "BEGIN:"+c.name)
+	for _, p := range c.props {
+		line := p.name
+		for _, k := range sortedKeys(p.params) {
+			line += ";" + k + "=" + p.params[k]
+		}
+		writeLine(b, line+":"+p.value)
+	}
+	for _, sub := range c.components {
+		sub.encode(b)
+	}
+	writeLine(b, "END:"+c.name)
+}
+
+// writeLine writes a content line, folding it so that no line is longer than
+// maxLineLength octets without splitting a character.
+func writeLine(b *bytes.Buffer, line string) {
+	for len(line) > maxLineLength {
+		i := maxLineLength
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		b.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// errMalformed is returned by parseCalendar for data which is not iCalendar.
+var errMalformed = errors.New("malformed icalendar")
+
+// parseCalendar parses the iCalendar object in data, which must be a single
+// VCALENDAR component.
+func parseCalendar(data []byte) (*component, error) {
+	var (
+		stack []*component
+		root  *component
+	)
+
+	for _, line := range unfold(data) {
+		if line == "" {
+			continue
+		}
+
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.name {
+		case "BEGIN":
+			if root != nil {
+				return nil, fmt.Errorf("%w: content after END:%s", errMalformed, root.name)
+			}
+			c := &component{name: strings.ToUpper(p.value)}
+			if n := len(stack); n > 0 {
+				stack[n-1].components = append(stack[n-1].components, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			n := len(stack)
+			if n == 0 || stack[n-1].name != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("%w: unexpected END:%s", errMalformed, p.value)
+			}
+			if n == 1 {
+				root = stack[0]
+			}
+			stack = stack[:n-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: property %s outside a component", errMalformed, p.name)
+			}
+			c := stack[len(stack)-1]
+			c.props = append(c.props, p)
+		}
+	}
+
+	if root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("%w: unterminated component", errMalformed)
+	}
+	if root.name != "VCALENDAR" {
+		return nil, fmt.Errorf("%w: object must be a VCALENDAR", errMalformed)
+	}
+	return root, nil
+}
+
+// unfold splits data into its content lines, joining those which were folded.
+func unfold(data []byte) []string {
+	var lines []string
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), maxObjectSize)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if n := len(lines); n > 0 && line != "" && (line[0] == ' ' || line[0] == '\t') {
+			lines[n-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// parseLine parses a content line into a property. Parameter names are
+// upper cased and quoted parameter values unquoted.
+func parseLine(line string) (*property, error) {
+	p := &property{}
+
+	i := strings.IndexAny(line, ";:")
+	if i < 1 {
+		return nil, fmt.Errorf("%w: malformed line %q", errMalformed, line)
+	}
+	p.name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		line = line[i+1:]
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("%w: malformed parameter in %s", errMalformed, p.name)
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated quote in %s", errMalformed, p.name)
+			}
+			value, line = line[1:end+1], line[end+2:]
+			i = 0
+		} else {
+			i = strings.IndexAny(line, ";:")
+			if i < 0 {
+				return nil, fmt.Errorf("%w: property %s has no value", errMalformed, p.name)
+			}
+			value = line[:i]
+		}
+
+		if p.params == nil {
+			p.params = make(map[string]string)
+		}
+		p.params[name] = value
+
+		if i >= len(line) {
+			return nil, fmt.Errorf("%w: property %s has no value", errMalformed, p.name)
+		}
+	}
+
+	if line[i] != ':' {
+		return nil, fmt.Errorf("%w: property %s has no value", errMalformed, p.name)
+	}
+	p.value = line[i+1:]
+
+	return p, nil
+}
+
+var (
+	textEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+// text returns the value of a TEXT property, unescaped.
+func (p *property) text() string {
+	return textUnescaper.Replace(p.value)
+}
+
+// time returns the value of a DATE or DATE-TIME property. DATE values are
+// taken to be at midnight UTC, and DATE-TIME values in floating time to be
+// in UTC, as are those in a time zone which is not known.
+func (p *property) time() (time.Time, error) {
+	if p.params["VALUE"] == "DATE" || len(p.value) == len(dateLayout) {
+		return time.Parse(dateLayout, p.value)
+	}
+	if strings.HasSuffix(p.value, "Z") {
+		return time.Parse(utcLayout, p.value)
+	}
+
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+
+	t, err := time.ParseInLocation(localLayout, p.value, loc)
+	return t.UTC(), err
+}
+
+// calendarObject returns the VCALENDAR object holding a VTODO for t.
+func calendarObject(t *tasks.Task) *component {
+	todo := &component{name: "VTODO"}
+	todo.add("UID", t.ID)
+
+	// The stamp is the time the task was last changed, rather than the time
+	// the object was written, so that the same task is always written the
+	// same way and its ETag only changes when it does.
+	todo.add("DTSTAMP", t.UpdatedAt.UTC().Format(utcLayout))
+	todo.add("CREATED", t.CreatedAt.UTC().Format(utcLayout))
+	todo.add("LAST-MODIFIED", t.UpdatedAt.UTC().Format(utcLayout))
+	todo.add("SUMMARY", textEscaper.Replace(t.Text))
+
+	if t.DueAt != nil {
+		due := t.DueAt.UTC()
+		if due.Equal(due.Truncate(24 * time.Hour)) {
+			todo.add("DUE", due.Format(dateLayout), "VALUE", "DATE")
+		} else {
+			todo.add("DUE", due.Format(utcLayout))
+		}
+	}
+
+	if t.IsComplete {
+		todo.add("STATUS", "COMPLETED")
+		if t.CompletedAt != nil {
+			todo.add("COMPLETED", t.CompletedAt.UTC().Format(utcLayout))
+		}
+	} else {
+		todo.add("STATUS", "NEEDS-ACTION")
+	}
+
+	cal := &component{name: "VCALENDAR", components: []*component{todo}}
+	cal.add("VERSION", "2.0")
+	cal.add("PRODID", prodID)
+	return cal
+}
+
+// objectError describes why a calendar object cannot be stored as a task, as
+// the CalDAV precondition it fails.
+type objectError struct {
+	precondition string
+	message      string
+}
+
+func (e *objectError) Error() string {
+	return e.message
+}
+
+// decodeTask converts the VTODO in cal to a task. Only the summary, due date
+// and completion of the task are read; everything else about it is kept by
+// the repository.
+func decodeTask(cal *component) (*tasks.Task, error) {
+	var todo *component
+	for _, c := range cal.components {
+		switch c.name {
+		case "VTODO":
+			if todo != nil {
+				return nil, &objectError{"valid-calendar-object-resource", "object must hold a single VTODO"}
+			}
+			todo = c
+		case "VTIMEZONE":
+		default:
+			return nil, &objectError{"supported-calendar-component", "only VTODO components are supported"}
+		}
+	}
+	if todo == nil {
+		return nil, &objectError{"valid-calendar-object-resource", "object must hold a VTODO"}
+	}
+
+	t := &tasks.Task{}
+	if p := todo.prop("UID"); p != nil {
+		t.ID = p.value
+	}
+	if p := todo.prop("SUMMARY"); p != nil {
+		t.Text = p.text()
+	}
+	if err := validateText(t.Text); err != nil {
+		return nil, err
+	}
+
+	if p := todo.prop("DUE"); p != nil {
+		due, err := p.time()
+		if err != nil {
+			return nil, &objectError{"valid-calendar-data", "DUE must be a date or date-time"}
+		}
+		t.DueAt = &due
+	}
+
+	// Clients which only set one of STATUS and COMPLETED are understood, but
+	// STATUS wins if they disagree.
+	switch status := todo.prop("STATUS"); {
+	case status != nil:
+		t.IsComplete = strings.EqualFold(status.value, "COMPLETED")
+	default:
+		t.IsComplete = todo.prop("COMPLETED") != nil
+	}
+
+	return t, nil
+}
+
+// validateText checks the text of a task as the tasks API does, so that tasks
+// stored through CalDAV can be served by it.
+func validateText(text string) error {
+	if fe := tasks.ValidateText("SUMMARY", text, true); fe != nil {
+		return &objectError{"valid-calendar-object-resource", fe.Field + " " + fe.Message}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
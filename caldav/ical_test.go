@@ -0,0 +1,71 @@
+package caldav
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"example.com/tasks"
+)
+
+func TestCalendarObject(t *testing.T) {
+	is := is.New(t)
+
+	due := time.Date(2020, time.March, 5, 0, 0, 0, 0, time.UTC)
+	task := &tasks.Task{
+		ID:        tasks.NewTaskID(),
+		CreatedAt: time.Date(2020, time.March, 1, 10, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2020, time.March, 2, 10, 0, 0, 0, time.UTC),
+		Text:      "Write a summary; long enough, with commas and ünïcödé, that it must be folded",
+		DueAt:     &due,
+	}
+
+	var b bytes.Buffer
+	calendarObject(task).encode(&b)
+	data := b.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+		is.True(len(line) <= maxLineLength) // Lines are folded
+	}
+	is.True(strings.Contains(data, "DUE;VALUE=DATE:20200305\r\n")) // Due dates at midnight are dates
+	is.True(strings.Contains(data, "STATUS:NEEDS-ACTION\r\n"))     // Open tasks need action
+
+	cal, err := parseCalendar(b.Bytes())
+	is.NoErr(err) // Error from parseCalendar
+
+	decoded, err := decodeTask(cal)
+	is.NoErr(err)                     // Error from decodeTask
+	is.Equal(decoded.ID, task.ID)     // UID is read back
+	is.Equal(decoded.Text, task.Text) // Folded, escaped summary is read back
+	is.Equal(*decoded.DueAt, due)     // Due date is read back
+	is.True(!decoded.IsComplete)      // Completion is read back
+}
+
+func TestParseCalendar(t *testing.T) {
+	is := is.New(t)
+
+	cal, err := parseCalendar([]byte("BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY;LANGUAGE=en;X-NOTE=\"a:b;c\":done\nCOMPLETED:20200301T100000Z\nEND:VTODO\nEND:VCALENDAR\n"))
+	is.NoErr(err) // Bare line feeds and quoted parameters are understood
+
+	todo := cal.components[0]
+	is.Equal(todo.prop("SUMMARY").params["X-NOTE"], "a:b;c") // Quoted parameters are unquoted
+	is.Equal(todo.prop("SUMMARY").text(), "done")            // Value follows the parameters
+
+	task, err := decodeTask(cal)
+	is.NoErr(err)            // Error from decodeTask
+	is.True(task.IsComplete) // COMPLETED without STATUS completes the task
+
+	for _, data := range []string{
+		"",
+		"BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VTODO\r\nEND:VTODO\r\n",
+		"BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n",
+	} {
+		_, err := parseCalendar([]byte(data))
+		is.True(errors.Is(err, errMalformed)) // Malformed objects are refused
+	}
+}
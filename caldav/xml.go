@@ -0,0 +1,227 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The XML namespaces of WebDAV, CalDAV and the Calendar Server extensions.
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// prefixes are the namespace prefixes used in responses.
+var prefixes = map[string]string{
+	nsDAV:    "D",
+	nsCalDAV: "C",
+	nsCS:     "CS",
+}
+
+// Properties served by the handler.
+var (
+	propResourceType         = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName          = xml.Name{Space: nsDAV, Local: "displayname"}
+	propCurrentUserPrincipal = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL         = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propPrivileges           = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propSupportedReports     = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propETag                 = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType          = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propLastModified         = xml.Name{Space: nsDAV, Local: "getlastmodified"}
+	propHomeSet              = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propComponents           = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData         = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	propCTag                 = xml.Name{Space: nsCS, Local: "getctag"}
+)
+
+// prop is a property of a resource, with its value as XML.
+type prop struct {
+	name  xml.Name
+	value string
+}
+
+// resource is something served by the handler, with the properties which can
+// be found with PROPFIND and REPORT.
+type resource struct {
+	href  string
+	props []*prop
+}
+
+// find returns the property of r with the given name, or nil.
+func (r *resource) find(name xml.Name) *prop {
+	for _, p := range r.props {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// propNames are the names of the properties asked for by a request.
+type propNames []xml.Name
+
+// UnmarshalXML implements xml.Unmarshaler, collecting the names of the
+// elements in a DAV:prop element.
+func (pn *propNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*pn = append(*pn, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// propQuery is the part of PROPFIND and REPORT requests which asks for
+// properties. A query which asks for none asks for all of them.
+type propQuery struct {
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     propNames `xml:"DAV: prop"`
+}
+
+// response is the response of one resource in a multistatus.
+type response struct {
+	href string
+
+	// status is set for resources which are not found, which have no
+	// properties.
+	status int
+
+	found   []*prop
+	missing []xml.Name
+}
+
+// answer answers q for r.
+func (q *propQuery) answer(r *resource) *response {
+	res := &response{href: r.href}
+
+	switch {
+	case q.PropName != nil:
+		for _, p := range r.props {
+			res.found = append(res.found, &prop{name: p.name})
+		}
+	case len(q.Prop) == 0:
+		// Calendar data can be large, so it is only sent when asked for.
+		for _, p := range r.props {
+			if p.name != propCalendarData {
+				res.found = append(res.found, p)
+			}
+		}
+	default:
+		for _, name := range q.Prop {
+			if p := r.find(name); p != nil {
+				res.found = append(res.found, p)
+			} else {
+				res.missing = append(res.missing, name)
+			}
+		}
+	}
+
+	return res
+}
+
+// writeMultistatus writes a 207 Multi-Status response.
+func writeMultistatus(w http.ResponseWriter, responses []*response) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">`)
+
+	for _, res := range responses {
+		b.WriteString("<D:response><D:href>" + escape(res.href) + "</D:href>")
+		if res.status != 0 {
+			b.WriteString("<D:status>" + statusLine(res.status) + "</D:status>")
+		}
+		if len(res.found) > 0 {
+			writePropstat(&b, res.found, http.StatusOK)
+		}
+		if len(res.missing) > 0 {
+			props := make([]*prop, len(res.missing))
+			for i, name := range res.missing {
+				props[i] = &prop{name: name}
+			}
+			writePropstat(&b, props, http.StatusNotFound)
+		}
+		b.WriteString("</D:response>")
+	}
+
+	b.WriteString("</D:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write(b.Bytes())
+}
+
+func writePropstat(b *bytes.Buffer, props []*prop, status int) {
+	b.WriteString("<D:propstat><D:prop>")
+	for _, p := range props {
+		writeElement(b, p.name, p.value)
+	}
+	b.WriteString("</D:prop><D:status>" + statusLine(status) + "</D:status></D:propstat>")
+}
+
+// writeElement writes an element whose content is the XML inner, declaring
+// its namespace if it has no prefix of its own.
+func writeElement(b *bytes.Buffer, name xml.Name, inner string) {
+	tag, decl := name.Local, ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, decl = "X:"+name.Local, ` xmlns:X="`+escape(name.Space)+`"`
+	}
+
+	if inner == "" {
+		b.WriteString("<" + tag + decl + "/>")
+		return
+	}
+	b.WriteString("<" + tag + decl + ">" + inner + "</" + tag + ">")
+}
+
+// writeError writes a WebDAV error response, naming the precondition of the
+// given namespace which the request failed.
+func writeError(w http.ResponseWriter, code int, space, precondition, message string) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:error xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">`)
+	writeElement(&b, xml.Name{Space: space, Local: precondition}, "")
+	if message != "" {
+		b.WriteString("<D:responsedescription>" + escape(message) + "</D:responsedescription>")
+	}
+	b.WriteString("</D:error>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b.Bytes())
+}
+
+// href returns the XML of a DAV:href element.
+func href(path string) string {
+	return "<D:href>" + escape(path) + "</D:href>"
+}
+
+// escaper escapes text for XML. Carriage returns are escaped so that they
+// survive the normalisation of line endings by parsers, which would otherwise
+// break the CRLF line endings of calendar data.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\r", "&#13;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"example.com/tasks/sqlite"
+)
+
+func backup(logger *zap.Logger) {
+	dest := pflag.Arg(1)
+	if dest == "" {
+		logger.Error("backup requires a destination path")
+		os.Exit(2)
+	}
+
+	repo := initializeRepository(logger, viper.GetString("database"))
+
+	if err := repo.Backup(context.Background(), dest); err != nil {
+		logger.Error("failed to back up database", zap.String("destination", dest), zap.Error(err))
+		os.Exit(1)
+	}
+
+	logger.Info("backed up database", zap.String("destination", dest))
+}
+
+func restore(logger *zap.Logger) {
+	src := pflag.Arg(1)
+	if src == "" {
+		logger.Error("restore requires a source path")
+		os.Exit(2)
+	}
+
+	database := viper.GetString("database")
+	if database == ":memory:" {
+		logger.Error("restore requires a database file")
+		os.Exit(2)
+	}
+
+	if err := sqlite.Restore(src, database); err != nil {
+		logger.Error("failed to restore database",
+			zap.String("source", src),
+			zap.String("database", database),
+			zap.Error(err),
+		)
+		os.Exit(1)
+	}
+
+	logger.Info("restored database", zap.String("source", src), zap.String("database", database))
+}
+
+// scheduleBackups writes a backup every interval until ctx is done.
+func scheduleBackups(ctx context.Context, logger *zap.Logger, backups *sqlite.Backups, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			location, err := backups.Backup(ctx)
+			if err != nil {
+				logger.Error("failed to create scheduled backup", zap.Error(err))
+				continue
+			}
+			logger.Info("created scheduled backup", zap.String("location", location))
+		}
+	}
+}
@@ -1,25 +1,94 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
 	"example.com/tasks"
+	"example.com/tasks/caldav"
+	"example.com/tasks/events"
 	"example.com/tasks/sqlite"
 	"example.com/tasks/taskhttp"
+	"example.com/tasks/webhook"
 )
 
 func init() {
 	pflag.StringP("bind", "b", ":5000", "The interface and port on which to serve.")
 	pflag.StringP("database", "d", ":memory:", "The path to the sqlite3 database.")
+	pflag.String("id-format", "uuidv4", "The format of new task IDs: uuidv4, uuidv7 or ulid.")
+	pflag.String("journal-mode", "WAL", "The sqlite3 journal mode.")
+	pflag.Duration("busy-timeout", 5*time.Second, "How long to wait for a locked sqlite3 database before failing.")
+	pflag.Bool("foreign-keys", true, "Whether sqlite3 enforces foreign key constraints.")
+	pflag.String("synchronous", "NORMAL", "The sqlite3 synchronous mode.")
+	pflag.Int("max-readers", runtime.NumCPU(), "The maximum number of sqlite3 connections used for reads.")
+	pflag.StringP("keyring", "k", "", "The path to a keyring file used to encrypt task text at rest.")
+	pflag.Int("rotate-batch-size", 500, "The number of rows re-encrypted per transaction by rotate-keys.")
+	pflag.Bool("strict", false, "Reject request bodies with unknown fields rather than ignoring them.")
+	pflag.Duration("idempotency-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay.")
+	pflag.Int("event-history", events.DefaultHistory, "The number of recent events kept for clients resuming the event stream.")
+	pflag.Duration("event-heartbeat", 15*time.Second, "How often a heartbeat is sent on idle event streams.")
+	pflag.Int("webhook-attempts", webhook.DefaultMaxAttempts, "The number of attempts made to deliver each event to a webhook.")
+	pflag.Duration("webhook-backoff", webhook.DefaultBackoff, "The wait before retrying a webhook delivery, which doubles with each attempt.")
+	pflag.Int("webhook-max-failures", webhook.DefaultMaxFailures, "The number of failed deliveries in a row after which a webhook is disabled.")
+	pflag.String("root-sunset", taskhttp.DefaultRootSunset.Format("2006-01-02"), "The date announced for removing the deprecated unversioned routes.")
+	pflag.StringSlice("trusted-proxies", nil, "The addresses or CIDR ranges of reverse proxies whose X-Forwarded headers links follow.")
+	pflag.String("caldav-prefix", caldav.DefaultPrefix, "The path under which tasks are served over CalDAV. CalDAV is disabled when empty.")
+	pflag.String("admin-token", "", "The bearer token required by the admin endpoints. Admin endpoints are disabled when empty.")
+	pflag.String("backup-dir", "backups", "The directory into which backups are written by the scheduler and admin endpoint.")
+	pflag.Duration("backup-interval", 0, "How often to write a scheduled backup. Scheduled backups are disabled when zero.")
+	pflag.Int("backup-keep", 7, "The number of backups to keep in the backup directory. Zero keeps every backup.")
 
 	viper.BindPFlag("bind", pflag.Lookup("bind"))
 	viper.BindPFlag("database", pflag.Lookup("database"))
+	viper.BindPFlag("id-format", pflag.Lookup("id-format"))
+	viper.BindPFlag("journal-mode", pflag.Lookup("journal-mode"))
+	viper.BindPFlag("busy-timeout", pflag.Lookup("busy-timeout"))
+	viper.BindPFlag("foreign-keys", pflag.Lookup("foreign-keys"))
+	viper.BindPFlag("synchronous", pflag.Lookup("synchronous"))
+	viper.BindPFlag("max-readers", pflag.Lookup("max-readers"))
+	viper.BindPFlag("keyring", pflag.Lookup("keyring"))
+	viper.BindPFlag("rotate-batch-size", pflag.Lookup("rotate-batch-size"))
+	viper.BindPFlag("strict", pflag.Lookup("strict"))
+	viper.BindPFlag("idempotency-ttl", pflag.Lookup("idempotency-ttl"))
+	viper.BindPFlag("event-history", pflag.Lookup("event-history"))
+	viper.BindPFlag("event-heartbeat", pflag.Lookup("event-heartbeat"))
+	viper.BindPFlag("webhook-attempts", pflag.Lookup("webhook-attempts"))
+	viper.BindPFlag("webhook-backoff", pflag.Lookup("webhook-backoff"))
+	viper.BindPFlag("webhook-max-failures", pflag.Lookup("webhook-max-failures"))
+	viper.BindPFlag("root-sunset", pflag.Lookup("root-sunset"))
+	viper.BindPFlag("trusted-proxies", pflag.Lookup("trusted-proxies"))
+	viper.BindPFlag("caldav-prefix", pflag.Lookup("caldav-prefix"))
+	viper.BindPFlag("admin-token", pflag.Lookup("admin-token"))
+	viper.BindPFlag("backup-dir", pflag.Lookup("backup-dir"))
+	viper.BindPFlag("backup-interval", pflag.Lookup("backup-interval"))
+	viper.BindPFlag("backup-keep", pflag.Lookup("backup-keep"))
+
+	// Secrets are better kept out of the process list.
+	viper.BindEnv("admin-token", "TASKS_ADMIN_TOKEN")
+
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: tasks [flags] [command]\n\n")
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  serve               Serve the tasks API (default)\n")
+		fmt.Fprintf(os.Stderr, "  rotate-keys         Re-encrypt task text with the primary keyring key\n")
+		fmt.Fprintf(os.Stderr, "  backup DEST         Write a snapshot of the database to DEST\n")
+		fmt.Fprintf(os.Stderr, "  restore SRC         Replace the database with the backup at SRC\n")
+		fmt.Fprintf(os.Stderr, "  export-todo [DEST]  Write every task to DEST, or standard output, as todo.txt\n")
+		fmt.Fprintf(os.Stderr, "  import-todo SRC     Import the tasks in the todo.txt file SRC, or - for standard input\n\n")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		pflag.PrintDefaults()
+	}
 }
 
 func initializeLogger() *zap.Logger {
@@ -31,8 +100,32 @@ func initializeLogger() *zap.Logger {
 	return logger
 }
 
-func initializeRepository(logger *zap.Logger, database string) tasks.TaskRepository {
-	repo, err := sqlite.New(database)
+func initializeRepository(logger *zap.Logger, database string) *sqlite.Repository {
+	ids, ok := tasks.IDGenerators[viper.GetString("id-format")]
+	if !ok {
+		logger.Error("unknown id format", zap.String("id_format", viper.GetString("id-format")))
+		os.Exit(2)
+	}
+
+	opts := []sqlite.Option{
+		sqlite.WithIDGenerator(ids),
+		sqlite.WithJournalMode(viper.GetString("journal-mode")),
+		sqlite.WithBusyTimeout(viper.GetDuration("busy-timeout")),
+		sqlite.WithForeignKeys(viper.GetBool("foreign-keys")),
+		sqlite.WithSynchronous(viper.GetString("synchronous")),
+		sqlite.WithMaxReaders(viper.GetInt("max-readers")),
+	}
+
+	if path := viper.GetString("keyring"); path != "" {
+		keyring, err := sqlite.LoadKeyring(path)
+		if err != nil {
+			logger.Error("failed to load keyring", zap.String("keyring", path), zap.Error(err))
+			os.Exit(1)
+		}
+		opts = append(opts, sqlite.WithKeyring(keyring))
+	}
+
+	repo, err := sqlite.New(database, opts...)
 	if err != nil {
 		logger.Error("failed to initialize database", zap.Error(err))
 		os.Exit(1)
@@ -46,9 +139,72 @@ func main() {
 
 	logger := initializeLogger()
 	defer logger.Sync()
+
+	switch command := pflag.Arg(0); command {
+	case "", "serve":
+		serve(logger)
+	case "rotate-keys":
+		rotateKeys(logger)
+	case "backup":
+		backup(logger)
+	case "restore":
+		restore(logger)
+	case "export-todo":
+		exportTodo(logger)
+	case "import-todo":
+		importTodo(logger)
+	default:
+		logger.Error("unknown command", zap.String("command", command))
+		pflag.Usage()
+		os.Exit(2)
+	}
+}
+
+func serve(logger *zap.Logger) {
 	repo := initializeRepository(logger, viper.GetString("database"))
 
-	handler := taskhttp.New(logger.Named("tasks"), repo)
+	backups := sqlite.NewBackups(repo, viper.GetString("backup-dir"), viper.GetInt("backup-keep"))
+
+	if interval := viper.GetDuration("backup-interval"); interval > 0 {
+		go scheduleBackups(context.Background(), logger.Named("backups"), backups, interval)
+	}
+
+	broker := events.NewBroker(viper.GetInt("event-history"))
+
+	dispatcher := webhook.NewDispatcher(logger.Named("webhooks"), repo, broker,
+		webhook.WithRetries(viper.GetInt("webhook-attempts"), viper.GetDuration("webhook-backoff")),
+		webhook.WithMaxFailures(viper.GetInt("webhook-max-failures")),
+	)
+	go dispatcher.Run(context.Background())
+
+	published := events.Publish(repo, broker)
+
+	proxies, err := parseProxies(viper.GetStringSlice("trusted-proxies"))
+	if err != nil {
+		logger.Error("invalid trusted proxies", zap.Error(err))
+		os.Exit(2)
+	}
+
+	var handler http.Handler = taskhttp.New(logger.Named("tasks"), published,
+		taskhttp.WithAdminToken(viper.GetString("admin-token")),
+		taskhttp.WithBackups(backups),
+		taskhttp.WithStrictDecoding(viper.GetBool("strict")),
+		taskhttp.WithIdempotency(repo, viper.GetDuration("idempotency-ttl")),
+		taskhttp.WithWebhooks(repo),
+		taskhttp.WithEvents(broker, viper.GetDuration("event-heartbeat")),
+		taskhttp.WithRootSunset(viper.GetTime("root-sunset")),
+		taskhttp.WithTrustedProxies(proxies...),
+	)
+
+	// CalDAV is served alongside the API, sharing its repository so that
+	// changes made by calendar clients are published like any other.
+	if prefix := strings.TrimSuffix(viper.GetString("caldav-prefix"), "/"); prefix != "" {
+		mux := http.NewServeMux()
+		mux.Handle(prefix+"/", caldav.New(logger.Named("caldav"), published, caldav.WithPrefix(prefix)))
+		mux.Handle("/.well-known/caldav", http.RedirectHandler(prefix+"/", http.StatusMovedPermanently))
+		mux.Handle("/", handler)
+		handler = mux
+	}
 
 	logger.Info("I'm Listening", zap.String("bind", viper.GetString("bind")))
 	if err := http.ListenAndServe(viper.GetString("bind"), handler); err != nil {
@@ -59,3 +215,26 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// parseProxies parses the addresses of trusted proxies, each of which is an IP
+// address or a CIDR range.
+func parseProxies(addrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy address %q: %w", addr, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func rotateKeys(logger *zap.Logger) {
+	if viper.GetString("keyring") == "" {
+		logger.Error("rotate-keys requires a keyring")
+		os.Exit(2)
+	}
+
+	repo := initializeRepository(logger, viper.GetString("database"))
+
+	rotated, err := repo.RotateKeys(context.Background(), viper.GetInt("rotate-batch-size"))
+	if err != nil {
+		logger.Error("failed to rotate keys",
+			zap.Int("rotated", rotated),
+			zap.Error(err),
+		)
+		os.Exit(1)
+	}
+
+	logger.Info("rotated keys", zap.Int("rotated", rotated))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/todotxt"
+)
+
+// todoBatchSize is the number of tasks listed or imported at a time.
+const todoBatchSize = 500
+
+func exportTodo(logger *zap.Logger) {
+	out := os.Stdout
+	if path := pflag.Arg(1); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			logger.Error("failed to create todo.txt", zap.String("path", path), zap.Error(err))
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	repo := initializeRepository(logger, viper.GetString("database"))
+
+	w := todotxt.NewWriter(out)
+	opts := tasks.ListOptions{Limit: todoBatchSize}
+	exported := 0
+	for {
+		page, err := repo.ListTasks(opts)
+		if err != nil {
+			logger.Error("failed to list tasks", zap.Int("exported", exported), zap.Error(err))
+			os.Exit(1)
+		}
+
+		for _, t := range page.Tasks {
+			if err := w.Write(t); err != nil {
+				logger.Error("failed to write todo.txt", zap.Error(err))
+				os.Exit(1)
+			}
+		}
+		exported += len(page.Tasks)
+
+		if page.Next == nil {
+			break
+		}
+		opts.Cursor = page.Next
+	}
+
+	if err := w.Flush(); err != nil {
+		logger.Error("failed to write todo.txt", zap.Error(err))
+		os.Exit(1)
+	}
+
+	logger.Info("exported tasks", zap.Int("exported", exported))
+}
+
+func importTodo(logger *zap.Logger) {
+	path := pflag.Arg(1)
+	if path == "" {
+		logger.Error("import-todo requires a source path, or - for standard input")
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			logger.Error("failed to open todo.txt", zap.String("path", path), zap.Error(err))
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	repo := initializeRepository(logger, viper.GetString("database"))
+
+	var (
+		batch    []*tasks.Task
+		lines    []int
+		imported int
+		failed   int
+	)
+	flush := func() {
+		errs, err := repo.ImportTasks(batch, tasks.BatchBestEffort)
+		if err != nil {
+			logger.Error("failed to import tasks", zap.Int("imported", imported), zap.Error(err))
+			os.Exit(1)
+		}
+
+		for i, err := range errs {
+			if err != nil {
+				logger.Warn("failed to import task", zap.Int("line", lines[i]), zap.Error(err))
+				failed++
+			} else {
+				imported++
+			}
+		}
+		batch, lines = batch[:0], lines[:0]
+	}
+
+	r := todotxt.NewReader(in)
+	for {
+		t, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error("failed to read todo.txt", zap.Int("line", r.Line()+1), zap.Error(err))
+			os.Exit(1)
+		}
+
+		if strings.TrimSpace(t.Text) == "" {
+			logger.Warn("skipped task without text", zap.Int("line", r.Line()))
+			failed++
+			continue
+		}
+
+		batch = append(batch, t)
+		lines = append(lines, r.Line())
+		if len(batch) == todoBatchSize {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+
+	logger.Info("imported tasks", zap.Int("imported", imported), zap.Int("failed", failed))
+}
@@ -0,0 +1,114 @@
+package tasks
+
+import (
+	"errors"
+)
+
+// Kind classifies an error by what went wrong, so that it can be reported
+// to a caller without knowing the specific error.
+type Kind uint8
+
+// The kinds of error.
+const (
+	// KindInternal is something which went wrong that is not the caller's
+	// fault. Errors which are not an *Error are of this kind.
+	KindInternal Kind = iota
+
+	// KindMalformed is a request which could not be understood.
+	KindMalformed
+
+	// KindInvalid is a request which was understood, but whose fields broke
+	// the rules for them.
+	KindInvalid
+
+	// KindUnsupported is a request in a form which is not supported, such as
+	// an unknown media type.
+	KindUnsupported
+
+	// KindUnauthorized is a request without valid credentials.
+	KindUnauthorized
+
+	// KindNotFound is a request for something which does not exist.
+	KindNotFound
+
+	// KindConflict is a request which conflicts with the current state.
+	KindConflict
+
+	// KindNotAcceptable is a request for a representation which cannot be
+	// produced, such as an unknown media type in its Accept header.
+	KindNotAcceptable
+)
+
+var kindNames = map[Kind]string{
+	KindInternal:      "internal",
+	KindMalformed:     "malformed",
+	KindInvalid:       "invalid",
+	KindUnsupported:   "unsupported",
+	KindUnauthorized:  "unauthorized",
+	KindNotFound:      "not-found",
+	KindConflict:      "conflict",
+	KindNotAcceptable: "not-acceptable",
+}
+
+// String returns the name of the kind, e.g. "not-found".
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// FieldError describes why the value of one field was rejected.
+type FieldError struct {
+	// Field is the path to the field, e.g. "items[2].text".
+	Field string
+
+	// Code identifies the rule which was broken, e.g. "required".
+	Code string
+
+	Message string
+}
+
+// Error is an error of a particular Kind.
+type Error struct {
+	Kind Kind
+
+	// Message describes what went wrong, and is safe to show to callers.
+	Message string
+
+	// Fields lists each field which was rejected, if the error was caused by
+	// particular fields.
+	Fields []*FieldError
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+// NewError creates an error of kind with the given message.
+func NewError(kind Kind, msg string) *Error {
+	return &Error{Kind: kind, Message: msg}
+}
+
+// Invalid creates a KindInvalid error for the fields which broke the rules.
+func Invalid(fields ...*FieldError) *Error {
+	return &Error{Kind: KindInvalid, Message: "validation failed", Fields: fields}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the first *Error in err's chain, or KindInternal
+// if there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
@@ -0,0 +1,23 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestKindOf(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(KindOf(ErrTaskNotFound), KindNotFound)                                   // Sentinels carry a kind
+	is.Equal(KindOf(fmt.Errorf("failed to find: %w", ErrTaskNotFound)), KindNotFound) // Wrapping keeps the kind
+	is.Equal(KindOf(errors.New("boom")), KindInternal)                                // Other errors are internal
+	is.Equal(KindOf(nil), KindInternal)                                               // Even nil
+
+	err := Invalid(&FieldError{Field: "text", Code: "required", Message: "is required"})
+	is.Equal(KindOf(err), KindInvalid)                                        // Invalid errors are KindInvalid
+	is.Equal(err.Fields[0].Field, "text")                                     // And keep their fields
+	is.True(errors.Is(fmt.Errorf("x: %w", ErrBatchAborted), ErrBatchAborted)) // Sentinels still match with errors.Is
+}
@@ -0,0 +1,198 @@
+// Package events publishes changes to tasks to subscribers within the
+// process, such as clients of the event stream.
+package events
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/tasks"
+)
+
+// Type is the kind of change an event describes.
+type Type string
+
+// The types of event.
+const (
+	Created Type = "created"
+	Updated Type = "updated"
+	Deleted Type = "deleted"
+)
+
+// Event is a change to a task.
+type Event struct {
+	// ID orders the events published by a broker, starting at 1. IDs are
+	// only unique within the broker's epoch.
+	ID uint64
+
+	Type Type
+	Time time.Time
+
+	// Task is the task as it was after the change. Only the ID is set on the
+	// tasks of Deleted events.
+	Task *tasks.Task
+}
+
+const (
+	// DefaultHistory is the number of events a broker keeps for resuming
+	// subscriptions when no other size is given.
+	DefaultHistory = 1000
+
+	// subscriberBuffer is the number of events which may be waiting for a
+	// subscriber before it is considered too slow and dropped.
+	subscriberBuffer = 64
+)
+
+// Broker delivers published events to subscribers. It keeps a history of the
+// most recent events so that subscribers which were disconnected can resume
+// from the last event they saw. It is safe for concurrent use.
+type Broker struct {
+	epoch string
+
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker which keeps the last size events for resuming
+// subscriptions. If size is not positive, DefaultHistory is used.
+func NewBroker(size int) *Broker {
+	if size <= 0 {
+		size = DefaultHistory
+	}
+
+	return &Broker{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  size,
+		subs:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Epoch identifies the broker among those which have published events, such
+// as the brokers of earlier runs of the process, whose event IDs overlap with
+// its own.
+func (b *Broker) Epoch() string {
+	return b.epoch
+}
+
+// Publish assigns the next ID to an event of type t for task, and delivers it
+// to every subscriber. It never blocks; subscribers which have fallen too far
+// behind are closed instead.
+func (b *Broker) Publish(t Type, task *tasks.Task) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := Event{ID: b.lastID, Type: t, Time: time.Now().UTC(), Task: task}
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+
+	for s := range b.subs {
+		select {
+		case s.events <- e:
+		default:
+			b.unsubscribe(s)
+		}
+	}
+
+	return e
+}
+
+// Subscribe starts a subscription to events published after the event with
+// ID after in epoch, or to new events only when after is zero. Events which
+// were published before the subscription started are taken from the
+// history. If they are no longer all there, or the ID is from another epoch,
+// the subscription only receives new events, and Resumed reports false.
+func (b *Broker) Subscribe(epoch string, after uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{
+		broker:  b,
+		events:  make(chan Event, subscriberBuffer),
+		start:   b.lastID,
+		resumed: true,
+	}
+
+	if after > 0 {
+		switch {
+		case epoch != b.epoch || after > b.lastID:
+			// The ID was issued by another broker, e.g. before a restart.
+			s.resumed = false
+		case after < b.lastID:
+			i := len(b.history) - int(b.lastID-after)
+			if i < 0 {
+				s.resumed = false
+			} else {
+				s.backlog = append([]Event(nil), b.history[i:]...)
+			}
+		}
+	}
+
+	b.subs[s] = struct{}{}
+
+	return s
+}
+
+// Subscribers returns the number of open subscriptions.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+// unsubscribe closes s. The caller must hold b.mu.
+func (b *Broker) unsubscribe(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// Subscription receives the events published by a broker.
+type Subscription struct {
+	broker  *Broker
+	events  chan Event
+	backlog []Event
+	start   uint64
+	resumed bool
+}
+
+// Start returns the ID of the last event published before the subscription
+// started, or zero if there was none.
+func (s *Subscription) Start() uint64 {
+	return s.start
+}
+
+// Backlog returns the events which were published before the subscription
+// started, and after the ID it was started with.
+func (s *Subscription) Backlog() []Event {
+	return s.backlog
+}
+
+// Resumed reports whether every event after the ID the subscription was
+// started with is in the backlog or will be received.
+func (s *Subscription) Resumed() bool {
+	return s.resumed
+}
+
+// Events returns the channel new events are received on. It is closed when
+// the subscription is closed, or when the subscriber falls too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	s.broker.unsubscribe(s)
+}
@@ -0,0 +1,147 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"example.com/tasks"
+	"example.com/tasks/mock"
+)
+
+func TestBrokerResume(t *testing.T) {
+	is := is.New(t)
+	b := NewBroker(2)
+
+	for i := 0; i < 3; i++ {
+		b.Publish(Created, &tasks.Task{ID: tasks.NewTaskID()})
+	}
+
+	s := b.Subscribe(b.Epoch(), 1)
+	defer s.Close()
+	is.True(s.Resumed())                   // Events after 1 are still in the history
+	is.Equal(len(s.Backlog()), 2)          // Backlog holds the missed events
+	is.Equal(s.Backlog()[0].ID, uint64(2)) // Backlog starts after the given ID
+
+	s = b.Subscribe(b.Epoch(), 3)
+	defer s.Close()
+	is.True(s.Resumed())          // Nothing was missed
+	is.Equal(len(s.Backlog()), 0) // Backlog is empty
+
+	is.True(b.Subscribe(b.Epoch(), 0).Resumed()) // New subscriptions miss nothing
+
+	e := b.Publish(Deleted, &tasks.Task{ID: tasks.NewTaskID()})
+	is.Equal((<-s.Events()).ID, e.ID) // New events are delivered
+
+	is.True(!b.Subscribe(b.Epoch(), 1).Resumed())   // Event 2 fell out of the history
+	is.True(!b.Subscribe(b.Epoch(), 99).Resumed())  // IDs from another broker cannot be resumed
+	is.True(!b.Subscribe("other", 3).Resumed())     // IDs from another epoch cannot be resumed
+	is.Equal(b.Subscribe("other", 3).Start(), e.ID) // Subscriptions start after the last event
+	is.True(NewBroker(0).Epoch() != b.Epoch())      // Brokers have their own epochs
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	is := is.New(t)
+	b := NewBroker(0)
+
+	s := b.Subscribe(b.Epoch(), 0)
+	for i := 0; i <= subscriberBuffer; i++ {
+		b.Publish(Created, &tasks.Task{ID: tasks.NewTaskID()})
+	}
+
+	n := 0
+	for range s.Events() {
+		n++
+	}
+	is.Equal(n, subscriberBuffer) // Subscription was closed once its buffer was full
+
+	s.Close() // Closing a dropped subscription is harmless
+}
+
+func TestPublish(t *testing.T) {
+	is := is.New(t)
+	b := NewBroker(0)
+	repo := Publish(mock.New(), b)
+
+	s := b.Subscribe(b.Epoch(), 0)
+	defer s.Close()
+
+	task := &tasks.Task{Text: "testing"}
+	is.NoErr(repo.CreateTask(task)) // Error from CreateTask
+	e := <-s.Events()
+	is.Equal(e.Type, Created)    // Creation is published
+	is.Equal(e.Task.ID, task.ID) // Event carries the task
+	is.True(e.Task != task)      // Event carries a copy
+
+	_, err := repo.PatchTask(task.ID, func(t *tasks.Task) error { return nil })
+	is.NoErr(err) // Error from PatchTask
+
+	_, err = repo.PatchTask(task.ID, func(t *tasks.Task) error {
+		t.IsComplete = true
+		return nil
+	})
+	is.NoErr(err) // Error from PatchTask
+	e = <-s.Events()
+	is.Equal(e.Type, Updated)  // Only patches which change the task are published
+	is.True(e.Task.IsComplete) // Event carries the patched task
+
+	created, err := repo.UpsertTask(&tasks.Task{ID: task.ID, Text: "testing", IsComplete: true})
+	is.NoErr(err)     // Error from UpsertTask
+	is.True(!created) // Existing task is replaced
+
+	_, err = repo.UpsertTask(&tasks.Task{ID: task.ID, Text: "replaced", IsComplete: true})
+	is.NoErr(err) // Error from UpsertTask
+	e = <-s.Events()
+	is.Equal(e.Type, Updated)                  // Only replacements which change the task are published
+	is.Equal(e.Task.Text, "replaced")          // Event carries the replaced task
+	is.Equal(e.Task.CreatedAt, task.CreatedAt) // Replaced task keeps its creation time
+
+	upserted := &tasks.Task{ID: tasks.NewTaskID(), Text: "upserted"}
+	created, err = repo.UpsertTask(upserted)
+	is.NoErr(err)    // Error from UpsertTask
+	is.True(created) // Missing task is created
+	e = <-s.Events()
+	is.Equal(e.Type, Created)        // Creation by replacement is published
+	is.Equal(e.Task.ID, upserted.ID) // Event carries the created task
+
+	deleted, err := repo.DeleteTask(tasks.NewTaskID())
+	is.NoErr(err)     // Error from DeleteTask
+	is.True(!deleted) // Missing task is not deleted
+	_, _, err = repo.DeleteTasks([]string{tasks.NewTaskID()}, tasks.BatchAtomic)
+	is.NoErr(err) // Error from DeleteTasks
+
+	txr, ok := repo.(tasks.Transactor)
+	is.True(ok) // Transactions are supported when the repository supports them
+
+	failed := errors.New("failed")
+	err = txr.WithTx(func(tx tasks.TaskRepository) error {
+		_, err := tx.DeleteTask(task.ID)
+		is.NoErr(err) // Error from DeleteTask
+		return failed
+	})
+	is.Equal(err, failed) // Error from fn is returned
+
+	err = txr.WithTx(func(tx tasks.TaskRepository) error {
+		is.NoErr(tx.CreateTask(&tasks.Task{Text: "created"})) // Error from CreateTask
+		select {
+		case <-s.Events():
+			t.Fatal("event published before commit")
+		default:
+		}
+		_, err := tx.DeleteTask(task.ID)
+		return err
+	})
+	is.NoErr(err) // Error from WithTx
+
+	is.Equal((<-s.Events()).Type, Created) // Committed changes are published in order
+	e = <-s.Events()
+	is.Equal(e.Type, Deleted)    // Committed changes are published in order
+	is.Equal(e.Task.ID, task.ID) // Deleted events carry the ID
+
+	select {
+	case e := <-s.Events():
+		t.Fatalf("unexpected %s event from rolled back transaction", e.Type)
+	default:
+	}
+}
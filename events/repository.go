@@ -0,0 +1,209 @@
+package events
+
+import (
+	"example.com/tasks"
+)
+
+// Publish returns a repository which stores tasks in repo and publishes an
+// event to b for every change it makes. Changes made in a transaction are
+// only published once it has been committed. The returned repository is a
+// tasks.Transactor if repo is.
+func Publish(repo tasks.TaskRepository, b *Broker) tasks.TaskRepository {
+	p := &publisher{TaskRepository: repo, broker: b}
+	if txr, ok := repo.(tasks.Transactor); ok {
+		return &txPublisher{publisher: p, txr: txr}
+	}
+	return p
+}
+
+type pending struct {
+	typ  Type
+	task *tasks.Task
+}
+
+type publisher struct {
+	tasks.TaskRepository
+	broker *Broker
+
+	// pending holds the events of a transaction until it is committed. It
+	// is nil outside of a transaction.
+	pending *[]pending
+}
+
+// publish publishes an event for a copy of t, or holds it until the
+// transaction is committed.
+func (p *publisher) publish(typ Type, t *tasks.Task) {
+	c := *t
+	if p.pending != nil {
+		*p.pending = append(*p.pending, pending{typ, &c})
+		return
+	}
+	p.broker.Publish(typ, &c)
+}
+
+func (p *publisher) CreateTask(t *tasks.Task) error {
+	if err := p.TaskRepository.CreateTask(t); err != nil {
+		return err
+	}
+	p.publish(Created, t)
+	return nil
+}
+
+func (p *publisher) PatchTask(id string, patch func(t *tasks.Task) error) (*tasks.Task, error) {
+	var changed bool
+	patched, err := p.TaskRepository.PatchTask(id, func(t *tasks.Task) error {
+		before := *t
+		if err := patch(t); err != nil {
+			return err
+		}
+		changed = t.Changed(&before)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		p.publish(Updated, patched)
+	}
+	return patched, nil
+}
+
+// UpsertTask replaces an existing task with PatchTask, so that, as there,
+// replacements which change nothing are not published. Only tasks which do not
+// exist are left to the repository's UpsertTask.
+func (p *publisher) UpsertTask(t *tasks.Task) (bool, error) {
+	var changed bool
+	replaced, err := p.TaskRepository.PatchTask(t.ID, func(e *tasks.Task) error {
+		before := *e
+		e.Text = t.Text
+		e.IsComplete = t.IsComplete
+		e.DueAt = t.DueAt
+		e.List, e.ParentID = t.List, t.ParentID
+		changed = e.Changed(&before)
+		return nil
+	})
+	switch {
+	case err == nil:
+		*t = *replaced
+		if changed {
+			p.publish(Updated, t)
+		}
+		return false, nil
+	case err != tasks.ErrTaskNotFound:
+		return false, err
+	}
+
+	created, err := p.TaskRepository.UpsertTask(t)
+	if err != nil {
+		return false, err
+	}
+	if created {
+		p.publish(Created, t)
+	} else {
+		// The task was created since it was patched, so whether this
+		// changed it is unknown.
+		p.publish(Updated, t)
+	}
+	return created, nil
+}
+
+func (p *publisher) DeleteTask(id string) (bool, error) {
+	deleted, err := p.TaskRepository.DeleteTask(id)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		p.publish(Deleted, &tasks.Task{ID: id})
+	}
+	return deleted, nil
+}
+
+func (p *publisher) CreateTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	errs, err := p.TaskRepository.CreateTasks(ts, mode)
+	if err == nil {
+		for i, t := range ts {
+			if errs[i] == nil {
+				p.publish(Created, t)
+			}
+		}
+	}
+	return errs, err
+}
+
+func (p *publisher) ImportTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	errs, err := p.TaskRepository.ImportTasks(ts, mode)
+	if err == nil {
+		for i, t := range ts {
+			if errs[i] == nil {
+				p.publish(Created, t)
+			}
+		}
+	}
+	return errs, err
+}
+
+func (p *publisher) PatchTasks(ps []*tasks.TaskPatch, mode tasks.BatchMode) ([]*tasks.Task, []error, error) {
+	// As with PatchTask, only the tasks a patch changed are published.
+	changed := make([]bool, len(ps))
+	wrapped := make([]*tasks.TaskPatch, len(ps))
+	for i, tp := range ps {
+		i, patch := i, tp.Patch
+		wrapped[i] = &tasks.TaskPatch{ID: tp.ID, Patch: func(t *tasks.Task) error {
+			before := *t
+			if err := patch(t); err != nil {
+				return err
+			}
+			changed[i] = t.Changed(&before)
+			return nil
+		}}
+	}
+
+	updated, errs, err := p.TaskRepository.PatchTasks(wrapped, mode)
+	if err == nil {
+		for i, t := range updated {
+			if errs[i] == nil && t != nil && changed[i] {
+				p.publish(Updated, t)
+			}
+		}
+	}
+	return updated, errs, err
+}
+
+func (p *publisher) DeleteTasks(ids []string, mode tasks.BatchMode) ([]bool, []error, error) {
+	deleted, errs, err := p.TaskRepository.DeleteTasks(ids, mode)
+	if err == nil {
+		for i, id := range ids {
+			if errs[i] == nil && deleted[i] {
+				p.publish(Deleted, &tasks.Task{ID: id})
+			}
+		}
+	}
+	return deleted, errs, err
+}
+
+// txPublisher is a publisher for a repository which supports transactions.
+type txPublisher struct {
+	*publisher
+	txr tasks.Transactor
+}
+
+// WithTx runs fn in a transaction of the underlying repository, publishing
+// the changes made by fn only once the transaction has been committed.
+func (p *txPublisher) WithTx(fn func(repo tasks.TaskRepository) error) error {
+	var events []pending
+	err := p.txr.WithTx(func(repo tasks.TaskRepository) error {
+		tx := &publisher{TaskRepository: repo, broker: p.broker, pending: &events}
+		if txr, ok := repo.(tasks.Transactor); ok {
+			return fn(&txPublisher{publisher: tx, txr: txr})
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		p.publish(e.typ, e.task)
+	}
+	return nil
+}
@@ -12,4 +12,5 @@ require (
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.6.2
 	go.uber.org/zap v1.14.0
+	gopkg.in/yaml.v2 v2.2.4
 )
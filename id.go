@@ -0,0 +1,231 @@
+package tasks
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// IDGenerator generates IDs for new tasks. Implementations must be safe for
+// concurrent use.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts an ordinary function to an IDGenerator.
+type IDGeneratorFunc func() string
+
+// NewID calls f.
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+var (
+	// UUIDv4 generates random version 4 UUIDs. This is the default.
+	UUIDv4 IDGenerator = IDGeneratorFunc(func() string {
+		return uuid.Must(uuid.NewV4()).String()
+	})
+
+	// UUIDv7 generates version 7 UUIDs, which begin with a millisecond
+	// timestamp and so sort in the order they were generated.
+	UUIDv7 IDGenerator = &uuidV7Generator{}
+
+	// ULID generates Universally Unique Lexicographically Sortable
+	// Identifiers, which sort in the order they were generated.
+	ULID IDGenerator = &ulidGenerator{}
+)
+
+// IDGenerators maps the names by which ID generators can be configured to the
+// generators themselves.
+var IDGenerators = map[string]IDGenerator{
+	"uuidv4": UUIDv4,
+	"uuidv7": UUIDv7,
+	"ulid":   ULID,
+}
+
+// NewTaskID creates a new task ID using the default generator.
+func NewTaskID() string {
+	return UUIDv4.NewID()
+}
+
+// ValidTaskID reports whether id is in the canonical form of an ID produced
+// by one of the IDGenerators: a lowercase hyphenated UUID or an uppercase
+// ULID.
+func ValidTaskID(id string) bool {
+	switch len(id) {
+	case 36:
+		return validUUID(id)
+	case 26:
+		return validULID(id)
+	default:
+		return false
+	}
+}
+
+func validUUID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func validULID(id string) bool {
+	// The first character only carries 3 bits of the 128 bit value.
+	if id[0] > '7' {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if crockfordDecode[id[i]] == 0xFF {
+			return false
+		}
+	}
+
+	return true
+}
+
+// uuidV7Generator generates version 7 UUIDs. The 12 bits following the
+// timestamp are used as a counter, as described in RFC 9562 section 6.2, so
+// that IDs generated within the same millisecond still sort in order.
+type uuidV7Generator struct {
+	mu      sync.Mutex
+	lastMS  uint64
+	counter uint16
+}
+
+func (g *uuidV7Generator) NewID() string {
+	var u [16]byte
+	if _, err := io.ReadFull(rand.Reader, u[6:]); err != nil {
+		panic(err)
+	}
+
+	g.mu.Lock()
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if ms > g.lastMS {
+		g.lastMS = ms
+		// Leave the top bit clear so the counter has room to grow.
+		g.counter = binary.BigEndian.Uint16(u[6:8]) & 0x07FF
+	} else {
+		g.counter++
+		if g.counter > 0x0FFF {
+			// The counter overflowed, so borrow from the next millisecond.
+			g.lastMS++
+			g.counter = 0
+		}
+	}
+	ms, counter := g.lastMS, g.counter
+	g.mu.Unlock()
+
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = 0x70 | byte(counter>>8)
+	u[7] = byte(counter)
+	u[8] = 0x80 | u[8]&0x3F
+
+	var s [36]byte
+	hex.Encode(s[0:8], u[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], u[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], u[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], u[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], u[10:])
+
+	return string(s[:])
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var crockfordDecode = func() [256]byte {
+	var d [256]byte
+	for i := range d {
+		d[i] = 0xFF
+	}
+	for i := 0; i < len(crockfordAlphabet); i++ {
+		d[crockfordAlphabet[i]] = byte(i)
+	}
+	return d
+}()
+
+// ulidGenerator generates monotonic ULIDs. Within the same millisecond the
+// random component of the previous ID is incremented rather than regenerated
+// so that IDs still sort in order.
+type ulidGenerator struct {
+	mu      sync.Mutex
+	lastMS  uint64
+	entropy [10]byte
+}
+
+func (g *ulidGenerator) NewID() string {
+	g.mu.Lock()
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	if ms > g.lastMS {
+		g.lastMS = ms
+		if _, err := io.ReadFull(rand.Reader, g.entropy[:]); err != nil {
+			g.mu.Unlock()
+			panic(err)
+		}
+	} else if !increment(g.entropy[:]) {
+		// The entropy overflowed, so borrow from the next millisecond.
+		g.lastMS++
+	}
+
+	var u [16]byte
+	u[0] = byte(g.lastMS >> 40)
+	u[1] = byte(g.lastMS >> 32)
+	u[2] = byte(g.lastMS >> 24)
+	u[3] = byte(g.lastMS >> 16)
+	u[4] = byte(g.lastMS >> 8)
+	u[5] = byte(g.lastMS)
+	copy(u[6:], g.entropy[:])
+	g.mu.Unlock()
+
+	// Encode the 128 bits as 26 base32 characters, 5 bits at a time starting
+	// from the least significant end. The first character holds the
+	// remaining 3 bits.
+	var s [26]byte
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordAlphabet[lo&0x1F]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(s[:])
+}
+
+// increment adds one to the big endian number in b, returning false if it
+// overflowed.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestIDGenerators(t *testing.T) {
+	for name, g := range IDGenerators {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id := g.NewID()
+				is.True(ValidTaskID(id)) // generated IDs should be valid
+				is.True(!seen[id])       // generated IDs should be unique
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestSortableIDGenerators(t *testing.T) {
+	for _, g := range []IDGenerator{UUIDv7, ULID} {
+		is := is.New(t)
+
+		prev := g.NewID()
+		for i := 0; i < 1000; i++ {
+			id := g.NewID()
+			is.True(id > prev) // IDs should sort in the order they were generated
+			prev = id
+		}
+	}
+}
+
+func TestValidTaskID(t *testing.T) {
+	is := is.New(t)
+
+	is.True(ValidTaskID("0170ac3a-4b2e-7c41-8a3e-1b2c3d4e5f60"))  // lowercase UUID
+	is.True(ValidTaskID("01ARZ3NDEKTSV4RRFFQ69G5FAV"))            // ULID
+	is.True(!ValidTaskID("0170AC3A-4B2E-7C41-8A3E-1B2C3D4E5F60")) // uppercase UUID
+	is.True(!ValidTaskID("01arz3ndektsv4rrffq69g5fav"))           // lowercase ULID
+	is.True(!ValidTaskID("81ARZ3NDEKTSV4RRFFQ69G5FAV"))           // ULID overflowing 128 bits
+	is.True(!ValidTaskID("'; DROP TABLE tasks; --"))              // anything else
+	is.True(!ValidTaskID(""))                                     // empty
+}
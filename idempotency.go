@@ -0,0 +1,49 @@
+package tasks
+
+import (
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// idempotency key, so that retries of the request can be answered with the
+// same response rather than repeating it.
+type IdempotencyRecord struct {
+	Key string
+
+	// Fingerprint identifies the request the key was first used for.
+	Fingerprint string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// Status is the status code of the response, or zero while the first
+	// request is still in flight.
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// InFlight reports whether the request the key was first used for has not yet
+// completed.
+func (r *IdempotencyRecord) InFlight() bool {
+	return r.Status == 0
+}
+
+// IdempotencyStore persists idempotency records. Implementations must be safe
+// for concurrent use.
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey claims key for a new request with fingerprint
+	// until expiresAt. If the key is already claimed and has not expired,
+	// nothing is changed and the existing record is returned. Otherwise the
+	// returned record is nil.
+	ReserveIdempotencyKey(key, fingerprint string, expiresAt time.Time) (*IdempotencyRecord, error)
+
+	// CompleteIdempotencyKey stores the response to the request which
+	// reserved key.
+	CompleteIdempotencyKey(key string, status int, header map[string][]string, body []byte) error
+
+	// ReleaseIdempotencyKey gives up a reservation, so that the key can be
+	// used again, e.g. after the request failed through no fault of the
+	// client's.
+	ReleaseIdempotencyKey(key string) error
+}
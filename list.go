@@ -0,0 +1,327 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a cursor can not be decoded, or was
+// produced by a listing with a different sort order.
+var ErrInvalidCursor = NewError(KindMalformed, "invalid cursor")
+
+// ListOptions controls which tasks are returned by TaskRepository.ListTasks.
+type ListOptions struct {
+	// Limit is the maximum number of tasks to return. Zero means no limit.
+	Limit int
+
+	// Cursor continues a listing from a page returned previously. A nil
+	// Cursor lists from the beginning.
+	Cursor *Cursor
+
+	// Count requests that the total number of tasks matching Filter be
+	// counted.
+	Count bool
+
+	// Filter restricts which tasks are listed.
+	Filter Filter
+
+	// Sort orders the listing, with ties broken by ID. An empty Sort lists
+	// in DefaultSort order.
+	Sort []SortKey
+}
+
+// Filter restricts which tasks are listed. The zero value of each field
+// matches every task.
+type Filter struct {
+	IsComplete    *bool
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+
+	// TextContains matches tasks whose text contains the string, ignoring
+	// case.
+	TextContains string
+
+	// List matches the tasks on the named list, or the tasks on no list if
+	// it is empty.
+	List *string
+
+	// ParentID matches the subtasks of the task with the ID, or the tasks
+	// which are not subtasks if it is empty.
+	ParentID *string
+}
+
+// Match reports whether t is matched by the filter.
+func (f *Filter) Match(t *Task) bool {
+	switch {
+	case f.IsComplete != nil && t.IsComplete != *f.IsComplete:
+		return false
+	case !f.CreatedAfter.IsZero() && !t.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !t.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !t.UpdatedAt.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !t.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	case f.TextContains != "" && !strings.Contains(strings.ToLower(t.Text), strings.ToLower(f.TextContains)):
+		return false
+	case f.List != nil && t.List != *f.List:
+		return false
+	case f.ParentID != nil && t.ParentID != *f.ParentID:
+		return false
+	}
+
+	return true
+}
+
+// SortField names a field which tasks can be sorted by.
+type SortField string
+
+// The fields tasks can be sorted by. Text is deliberately absent, as it may
+// be encrypted at rest.
+const (
+	SortCreatedAt  SortField = "created_at"
+	SortUpdatedAt  SortField = "updated_at"
+	SortIsComplete SortField = "is_complete"
+)
+
+// SortFields is the set of valid sort fields.
+var SortFields = map[SortField]bool{
+	SortCreatedAt:  true,
+	SortUpdatedAt:  true,
+	SortIsComplete: true,
+}
+
+// DefaultSort is the order tasks are listed in when no sort is given.
+var DefaultSort = []SortKey{{Field: SortCreatedAt}}
+
+// SortKey is one field of a sort order.
+type SortKey struct {
+	Field SortField
+	Desc  bool
+}
+
+// String formats the key as the field name, prefixed by "-" when descending.
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + string(k.Field)
+	}
+	return string(k.Field)
+}
+
+// sortKeys returns the keys a listing is effectively sorted by.
+func sortKeys(keys []SortKey) []SortKey {
+	if len(keys) == 0 {
+		return DefaultSort
+	}
+	return keys
+}
+
+func sortSignature(keys []SortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range sortKeys(keys) {
+		parts = append(parts, k.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Compare orders a and b by keys, then by ID. It returns a negative number
+// when a is listed before b, a positive number when a is listed after b and
+// zero when they are the same task.
+func Compare(a, b *Task, keys []SortKey) int {
+	for _, k := range sortKeys(keys) {
+		c := compareField(a, b, k.Field)
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return strings.Compare(a.ID, b.ID)
+}
+
+func compareField(a, b *Task, f SortField) int {
+	switch f {
+	case SortCreatedAt:
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case SortUpdatedAt:
+		return compareTime(a.UpdatedAt, b.UpdatedAt)
+	case SortIsComplete:
+		switch {
+		case a.IsComplete == b.IsComplete:
+			return 0
+		case b.IsComplete:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Cursor marks a position between two tasks in a listing. It holds the
+// sortable fields of the task on one side of the position.
+type Cursor struct {
+	ID         string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	IsComplete bool
+
+	// Before lists the tasks before the position, rather than after it.
+	Before bool
+
+	// sort is the signature of the sort order the cursor was created for.
+	sort string
+}
+
+// Task returns a task holding the cursor's sortable fields, for comparing
+// against other tasks.
+func (c *Cursor) Task() *Task {
+	return &Task{
+		ID:         c.ID,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+		IsComplete: c.IsComplete,
+	}
+}
+
+// CursorAt returns a cursor positioned just after t, or just before it if
+// before is set, in a listing sorted by keys.
+func CursorAt(t *Task, keys []SortKey, before bool) *Cursor {
+	return &Cursor{
+		ID:         t.ID,
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+		IsComplete: t.IsComplete,
+		Before:     before,
+		sort:       sortSignature(keys),
+	}
+}
+
+type encodedCursor struct {
+	ID         string    `json:"i"`
+	CreatedAt  time.Time `json:"c"`
+	UpdatedAt  time.Time `json:"u"`
+	IsComplete bool      `json:"x,omitempty"`
+	Before     bool      `json:"b,omitempty"`
+	Sort       string    `json:"s"`
+}
+
+// Encode encodes the cursor as an opaque, URL safe string.
+func (c *Cursor) Encode() string {
+	data, err := json.Marshal(&encodedCursor{
+		ID:         c.ID,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+		IsComplete: c.IsComplete,
+		Before:     c.Before,
+		Sort:       c.sort,
+	})
+	if err != nil {
+		panic("json marshal error on cursor, this is a bug")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor decodes a cursor produced by Cursor.Encode for a listing sorted
+// by keys.
+func DecodeCursor(s string, keys []SortKey) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c encodedCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	if c.Sort != sortSignature(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{
+		ID:         c.ID,
+		CreatedAt:  c.CreatedAt,
+		UpdatedAt:  c.UpdatedAt,
+		IsComplete: c.IsComplete,
+		Before:     c.Before,
+		sort:       c.Sort,
+	}, nil
+}
+
+// TaskPage is a single page of a listing.
+type TaskPage struct {
+	Tasks []*Task
+
+	// Next and Prev continue the listing after and before this page. They are
+	// nil when there are no more tasks in that direction.
+	Next *Cursor
+	Prev *Cursor
+
+	// Total is the number of tasks matching the filter, or -1 if it was not
+	// requested with ListOptions.Count.
+	Total int
+}
+
+// NewTaskPage builds a page from the tasks fetched by a repository for opts.
+// Repositories should fetch up to opts.Limit+1 tasks, in listing order when
+// listing forwards and in reverse when opts.Cursor.Before is set, so that the
+// extra task tells whether there are more to come.
+func NewTaskPage(fetched []*Task, opts ListOptions) *TaskPage {
+	page := &TaskPage{Total: -1}
+	backwards := opts.Cursor != nil && opts.Cursor.Before
+
+	more := opts.Limit > 0 && len(fetched) > opts.Limit
+	if more {
+		fetched = fetched[:opts.Limit]
+	}
+
+	if backwards {
+		for i, j := 0, len(fetched)-1; i < j; i, j = i+1, j-1 {
+			fetched[i], fetched[j] = fetched[j], fetched[i]
+		}
+	}
+	page.Tasks = fetched
+
+	// Whichever way we listed, the cursor we came from means there are tasks
+	// on its side of the page.
+	hasPrev, hasNext := more, opts.Cursor != nil
+	if !backwards {
+		hasPrev, hasNext = opts.Cursor != nil, more
+	}
+
+	if len(fetched) == 0 {
+		// There are no tasks on this page to anchor cursors to.
+		return page
+	}
+
+	if hasPrev {
+		page.Prev = CursorAt(fetched[0], opts.Sort, true)
+	}
+
+	if hasNext {
+		page.Next = CursorAt(fetched[len(fetched)-1], opts.Sort, false)
+	}
+
+	return page
+}
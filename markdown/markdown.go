@@ -0,0 +1,304 @@
+// Package markdown reads and writes tasks as Markdown task lists, such as
+// those pasted into documents and pull requests.
+//
+// Each task list item, bulleted with -, * or + or numbered as 1. or 1), is a
+// task, which is complete if its box is checked with x or X. An item indented
+// under another is a subtask of it, and the items under an ATX heading are on
+// the list the heading names; those before the first heading are on no list.
+// Anything else, list items without a box and fenced code blocks included, is
+// ignored.
+//
+// The ID of a task is written after its text in an HTML comment, which
+// renderers hide, so that tasks keep it when they are read back. Tasks read
+// without one are given a new ID, so that their subtasks can refer to them.
+package markdown
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"example.com/tasks"
+)
+
+const (
+	// idPrefix and idSuffix enclose the ID of a task after its text.
+	idPrefix = "<!-- id:"
+	idSuffix = " -->"
+
+	// headingPrefix starts the heading of each list written.
+	headingPrefix = "## "
+
+	// indent nests a subtask under its parent when written.
+	indent = "  "
+
+	// tabWidth is the number of columns a tab indents an item by.
+	tabWidth = 4
+)
+
+// Format formats t as a task list item, without indentation or a line
+// ending.
+func Format(t *tasks.Task) string {
+	box := "[ ]"
+	if t.IsComplete {
+		box = "[x]"
+	}
+
+	s := "- " + box + " " + t.Text
+	if t.ID != "" {
+		s += " " + idPrefix + t.ID + idSuffix
+	}
+	return s
+}
+
+// item parses a task list item, returning the column its marker starts in,
+// the text after its box and whether the box is checked.
+func item(line string) (column int, text string, checked bool, ok bool) {
+	rest := line
+	for len(rest) > 0 && (rest[0] == ' ' || rest[0] == '\t') {
+		if rest[0] == '\t' {
+			column += tabWidth - column%tabWidth
+		} else {
+			column++
+		}
+		rest = rest[1:]
+	}
+
+	switch {
+	case strings.HasPrefix(rest, "- "), strings.HasPrefix(rest, "* "), strings.HasPrefix(rest, "+ "):
+		rest = rest[2:]
+	default:
+		digits := 0
+		for digits < len(rest) && digits < 9 && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || len(rest) < digits+2 || (rest[digits] != '.' && rest[digits] != ')') || rest[digits+1] != ' ' {
+			return 0, "", false, false
+		}
+		rest = rest[digits+2:]
+	}
+
+	rest = strings.TrimLeft(rest, " ")
+	if len(rest) < 3 || rest[0] != '[' || rest[2] != ']' || (len(rest) > 3 && rest[3] != ' ' && rest[3] != '\t') {
+		return 0, "", false, false
+	}
+	switch rest[1] {
+	case ' ':
+	case 'x', 'X':
+		checked = true
+	default:
+		return 0, "", false, false
+	}
+
+	return column, strings.TrimSpace(rest[3:]), checked, true
+}
+
+// heading parses an ATX heading, returning its text.
+func heading(line string) (string, bool) {
+	rest := strings.TrimLeft(line, " ")
+	if len(line)-len(rest) > 3 {
+		return "", false
+	}
+
+	level := 0
+	for level < len(rest) && rest[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || (level < len(rest) && rest[level] != ' ' && rest[level] != '\t') {
+		return "", false
+	}
+
+	text := strings.TrimSpace(rest[level:])
+
+	// A closing sequence of #s is not part of the text.
+	if closed := strings.TrimRight(text, "#"); closed == "" {
+		text = ""
+	} else if closed != text && strings.HasSuffix(closed, " ") {
+		text = strings.TrimSpace(closed)
+	}
+	return text, true
+}
+
+// fence returns the fence which opens or closes a fenced code block on line,
+// or an empty string if there is none.
+func fence(line string) string {
+	rest := strings.TrimLeft(line, " ")
+	if len(line)-len(rest) > 3 || len(rest) < 3 || (rest[0] != '`' && rest[0] != '~') {
+		return ""
+	}
+
+	n := 0
+	for n < len(rest) && rest[n] == rest[0] {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return rest[:n]
+}
+
+// open is an item containing those read after it, until one which is not
+// indented further is read.
+type open struct {
+	column int
+	id     string
+}
+
+// Reader reads tasks from Markdown.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+
+	// list is the text of the last heading read.
+	list string
+
+	// fence is the fence of the code block being read, or empty if none is.
+	fence string
+
+	// open are the items which may contain the next, innermost last.
+	open []open
+}
+
+// NewReader returns a Reader which reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Read reads the task of the next task list item. It returns io.EOF when
+// there are no more tasks.
+func (r *Reader) Read() (*tasks.Task, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Text()
+
+		if r.fence != "" {
+			// A block is closed by a fence of the same character, at least
+			// as long as the one which opened it.
+			if f := fence(line); f != "" && f[0] == r.fence[0] && len(f) >= len(r.fence) && strings.TrimSpace(line) == f {
+				r.fence = ""
+			}
+			continue
+		}
+		if f := fence(line); f != "" {
+			r.fence = f
+			continue
+		}
+
+		if text, ok := heading(line); ok {
+			r.list, r.open = text, nil
+			continue
+		}
+
+		column, text, checked, ok := item(line)
+		if !ok {
+			continue
+		}
+
+		t := &tasks.Task{Text: text, IsComplete: checked, List: r.list}
+		if i := strings.LastIndex(text, idPrefix); i >= 0 && strings.HasSuffix(text, idSuffix) {
+			if id := text[i+len(idPrefix) : len(text)-len(idSuffix)]; tasks.ValidTaskID(id) {
+				t.ID, t.Text = id, strings.TrimSpace(text[:i])
+			}
+		}
+		if t.ID == "" {
+			t.ID = tasks.NewTaskID()
+		}
+
+		for len(r.open) > 0 && r.open[len(r.open)-1].column >= column {
+			r.open = r.open[:len(r.open)-1]
+		}
+		if len(r.open) > 0 {
+			t.ParentID = r.open[len(r.open)-1].id
+		}
+		r.open = append(r.open, open{column: column, id: t.ID})
+
+		return t, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Line returns the number of the line the last task was read from, counting
+// from 1.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Write writes ts as task lists: first the tasks on no list, then a heading
+// for each list followed by its tasks, with the lists in the order they first
+// appear in ts. Subtasks are nested under their parent if it is on the same
+// list in ts, and are otherwise written as if they had none.
+func Write(w io.Writer, ts []*tasks.Task) error {
+	var lists []string
+	byList := make(map[string][]*tasks.Task)
+	for _, t := range ts {
+		if _, ok := byList[t.List]; !ok {
+			lists = append(lists, t.List)
+		}
+		byList[t.List] = append(byList[t.List], t)
+	}
+	sort.SliceStable(lists, func(i, j int) bool {
+		return lists[i] == "" && lists[j] != ""
+	})
+
+	var b bytes.Buffer
+	for i, list := range lists {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if list != "" {
+			b.WriteString(headingPrefix + list + "\n\n")
+		}
+		writeList(&b, byList[list])
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeList writes the tasks of one list, each after its parent.
+func writeList(b *bytes.Buffer, ts []*tasks.Task) {
+	ids := make(map[string]bool, len(ts))
+	for _, t := range ts {
+		ids[t.ID] = true
+	}
+
+	var roots []*tasks.Task
+	subtasks := make(map[string][]*tasks.Task)
+	for _, t := range ts {
+		if t.ParentID != "" && t.ParentID != t.ID && ids[t.ParentID] {
+			subtasks[t.ParentID] = append(subtasks[t.ParentID], t)
+		} else {
+			roots = append(roots, t)
+		}
+	}
+
+	written := make(map[*tasks.Task]bool, len(ts))
+	var write func(t *tasks.Task, depth int)
+	write = func(t *tasks.Task, depth int) {
+		if written[t] {
+			return
+		}
+		written[t] = true
+
+		b.WriteString(strings.Repeat(indent, depth) + Format(t) + "\n")
+		for _, s := range subtasks[t.ID] {
+			write(s, depth+1)
+		}
+	}
+
+	for _, t := range roots {
+		write(t, 0)
+	}
+
+	// Tasks which are subtasks of their own subtasks cannot be reached from
+	// any of the roots, so they are written as roots themselves.
+	for _, t := range ts {
+		write(t, 0)
+	}
+}
@@ -0,0 +1,109 @@
+package markdown
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"example.com/tasks"
+)
+
+// readAll reads every task from s.
+func readAll(is *is.I, s string) []*tasks.Task {
+	r := NewReader(strings.NewReader(s))
+
+	var ts []*tasks.Task
+	for {
+		t, err := r.Read()
+		if err == io.EOF {
+			return ts
+		}
+		is.NoErr(err) // Error from Read
+		ts = append(ts, t)
+	}
+}
+
+func TestRead(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	ts := readAll(is, `# Trip
+
+Some notes, which are not tasks.
+
+- [ ] Book flights <!-- id:`+id+` -->
+  - [x] Compare prices
+    * [X] Ask around
+  - plain items are not tasks
+  - [ ] Pick seats
+- [ ] Pack
+
+`+"```"+`
+- [ ] Code is not a task
+`+"```"+`
+
+## Groceries ##
+
+1. [ ] Buy milk
+2) [x]  Buy eggs
+- [] Not a box
+- [y] Not a box either
+`)
+	is.Equal(len(ts), 7) // Items with boxes are read
+
+	is.Equal(ts[0].ID, id)                         // ID is read from its comment
+	is.Equal(ts[0].Text, "Book flights")           // Comment is removed from the text
+	is.Equal(ts[0].List, "Trip")                   // List is named by the heading
+	is.Equal(ts[0].ParentID, "")                   // Top level tasks have no parent
+	is.True(tasks.ValidTaskID(ts[1].ID))           // Tasks without an ID are given one
+	is.True(ts[1].IsComplete)                      // Checked items are complete
+	is.Equal(ts[1].ParentID, id)                   // Indented items are subtasks
+	is.Equal(ts[2].ParentID, ts[1].ID)             // Subtasks may be nested
+	is.True(ts[2].IsComplete)                      // Boxes may be checked with X
+	is.Equal(ts[3].ParentID, id)                   // Less indented items close nested ones
+	is.Equal(ts[4].ParentID, "")                   // Unindented items close every one
+	is.Equal(ts[5].Text, "Buy milk")               // Numbered items are read
+	is.Equal(ts[5].List, "Groceries")              // Closing #s are not part of the list
+	is.Equal(ts[6].Text, "Buy eggs")               // Text is trimmed
+	is.True(!ts[5].IsComplete && ts[6].IsComplete) // Completion is read from each box
+}
+
+func TestWrite(t *testing.T) {
+	is := is.New(t)
+
+	parent := &tasks.Task{ID: tasks.NewTaskID(), Text: "Buy milk", List: "Groceries"}
+	child := &tasks.Task{ID: tasks.NewTaskID(), Text: "Check the fridge", IsComplete: true, List: "Groceries", ParentID: parent.ID}
+	orphan := &tasks.Task{ID: tasks.NewTaskID(), Text: "Orphan", List: "Groceries", ParentID: tasks.NewTaskID()}
+	plain := &tasks.Task{ID: tasks.NewTaskID(), Text: "Plan the trip"}
+
+	// a and b are subtasks of each other.
+	a := &tasks.Task{ID: tasks.NewTaskID(), Text: "a", List: "Loop"}
+	b := &tasks.Task{ID: tasks.NewTaskID(), Text: "b", List: "Loop", ParentID: a.ID}
+	a.ParentID = b.ID
+
+	var buf bytes.Buffer
+	is.NoErr(Write(&buf, []*tasks.Task{child, parent, orphan, plain, a, b})) // Error from Write
+	is.Equal(buf.String(), Format(plain)+"\n"+
+		"\n## Groceries\n\n"+
+		Format(parent)+"\n"+
+		"  "+Format(child)+"\n"+
+		Format(orphan)+"\n"+
+		"\n## Loop\n\n"+
+		Format(a)+"\n"+
+		"  "+Format(b)+"\n") // Tasks are written under their lists and parents
+
+	ts := readAll(is, buf.String())
+	is.Equal(len(ts), 6) // Every task is read back
+	for i, want := range []*tasks.Task{plain, parent, child, orphan, a, b} {
+		is.Equal(ts[i].ID, want.ID)                 // IDs are read back
+		is.Equal(ts[i].Text, want.Text)             // Text is read back
+		is.Equal(ts[i].IsComplete, want.IsComplete) // Completion is read back
+		is.Equal(ts[i].List, want.List)             // Lists are read back
+	}
+	is.Equal(ts[2].ParentID, parent.ID) // Parents are read back
+	is.Equal(ts[3].ParentID, "")        // Missing parents are not written
+	is.Equal(ts[4].ParentID, "")        // Loops are broken
+}
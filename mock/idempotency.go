@@ -0,0 +1,56 @@
+package mock
+
+import (
+	"time"
+
+	"example.com/tasks"
+)
+
+// ReserveIdempotencyKey claims key for a new request with fingerprint until
+// expiresAt. If the key is already claimed and has not expired, nothing is
+// changed and the existing record is returned. Otherwise the returned record
+// is nil.
+func (r *Repository) ReserveIdempotencyKey(key, fingerprint string, expiresAt time.Time) (*tasks.IdempotencyRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	if rec, ok := r.keys[key]; ok && rec.ExpiresAt.After(now) {
+		c := *rec
+		return &c, nil
+	}
+
+	r.keys[key] = &tasks.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   expiresAt,
+	}
+
+	return nil, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request which reserved
+// key.
+func (r *Repository) CompleteIdempotencyKey(key string, status int, header map[string][]string, body []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rec, ok := r.keys[key]; ok {
+		rec.Status = status
+		rec.Header = header
+		rec.Body = body
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey gives up a reservation, so that the key can be used
+// again.
+func (r *Repository) ReleaseIdempotencyKey(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.keys, key)
+	return nil
+}
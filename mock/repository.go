@@ -1,6 +1,7 @@
 package mock
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -10,13 +11,27 @@ import (
 // Repository is an in-memory implementation of a repository. This is safe for
 // concurrent use so you may use it inside of an HTTP handler concurrently.
 type Repository struct {
+	// txMu serializes transactions started with WithTx.
+	txMu sync.Mutex
+
 	mu   sync.RWMutex
+	ids  tasks.IDGenerator
 	data map[string]*tasks.Task
+	keys map[string]*tasks.IdempotencyRecord
+
+	webhooks   map[string]*tasks.Webhook
+	deliveries map[string][]*tasks.WebhookDelivery
 }
 
 // New creates a new Repository. Any tasks passed to the repository will be used
 // to initialize the in-memory db.
 func New(ts ...*tasks.Task) *Repository {
+	return NewWithIDGenerator(tasks.UUIDv4, ts...)
+}
+
+// NewWithIDGenerator creates a new Repository which uses g to generate the IDs
+// of new tasks.
+func NewWithIDGenerator(g tasks.IDGenerator, ts ...*tasks.Task) *Repository {
 	data := make(map[string]*tasks.Task)
 
 	for _, t := range ts {
@@ -24,36 +39,81 @@ func New(ts ...*tasks.Task) *Repository {
 	}
 
 	return &Repository{
+		ids:  g,
 		data: data,
+		keys: make(map[string]*tasks.IdempotencyRecord),
+
+		webhooks:   make(map[string]*tasks.Webhook),
+		deliveries: make(map[string][]*tasks.WebhookDelivery),
 	}
 }
 
-// CreateTask creates a new task. All fields except Task.Text will be
-// overridden by defaults.
+// CreateTask creates a new task. All fields except Task.Text, Task.List and
+// Task.ParentID will be overridden by defaults.
 func (r *Repository) CreateTask(t *tasks.Task) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	t.ID = tasks.NewTaskID()
+	r.create(t)
+	return nil
+}
+
+// create creates a task. The caller must hold r.mu.
+func (r *Repository) create(t *tasks.Task) {
+	t.ID = r.ids.NewID()
 	t.CreatedAt = time.Now().UTC()
 	t.UpdatedAt = t.CreatedAt
 	t.IsComplete = false
+	t.DueAt = nil
+	t.Priority = ""
+	t.CompletedAt = nil
 
 	r.data[t.ID] = t
-	return nil
 }
 
-// ListTasks lists all tasks in the in-memory repo.
-func (r *Repository) ListTasks() ([]*tasks.Task, error) {
+// ListTasks lists a page of tasks in the in-memory repo.
+func (r *Repository) ListTasks(opts tasks.ListOptions) (*tasks.TaskPage, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	tasks := make([]*tasks.Task, 0)
+	ts := make([]*tasks.Task, 0, len(r.data))
 	for _, t := range r.data {
-		tasks = append(tasks, t)
+		if opts.Filter.Match(t) {
+			ts = append(ts, t)
+		}
+	}
+
+	sort.Slice(ts, func(i, j int) bool {
+		return tasks.Compare(ts[i], ts[j], opts.Sort) < 0
+	})
+
+	fetched := ts
+	if c := opts.Cursor; c != nil {
+		at := c.Task()
+		if c.Before {
+			// Listing backwards, so fetch in reverse starting just before
+			// the cursor.
+			i := sort.Search(len(ts), func(i int) bool { return tasks.Compare(ts[i], at, opts.Sort) >= 0 })
+			fetched = make([]*tasks.Task, 0, i)
+			for j := i - 1; j >= 0; j-- {
+				fetched = append(fetched, ts[j])
+			}
+		} else {
+			i := sort.Search(len(ts), func(i int) bool { return tasks.Compare(ts[i], at, opts.Sort) > 0 })
+			fetched = ts[i:]
+		}
+	}
+
+	if opts.Limit > 0 && len(fetched) > opts.Limit+1 {
+		fetched = fetched[:opts.Limit+1]
+	}
+
+	page := tasks.NewTaskPage(fetched, opts)
+	if opts.Count {
+		page.Total = len(ts)
 	}
 
-	return tasks, nil
+	return page, nil
 }
 
 // RetrieveTask retrieves the task from the repo by ID.
@@ -69,45 +129,209 @@ func (r *Repository) RetrieveTask(id string) (*tasks.Task, error) {
 	return t, nil
 }
 
-// UpdateTask updates a task, by id, in the repo. If the task, does not exist,
-// it will return tasks.ErrTaskNotFound. Only t.Text and t.IsCompleted are used
-// to update the fields. The returned Task is the updated version of the task.
-func (r *Repository) UpdateTask(id string, t *tasks.Task) (*tasks.Task, error) {
+// PatchTask applies patch to a task, by id. If the task does not exist, it
+// will return tasks.ErrTaskNotFound. If patch returns an error, the task is
+// left unchanged and the error is returned.
+func (r *Repository) PatchTask(id string, patch func(t *tasks.Task) error) (*tasks.Task, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	return r.patch(id, patch)
+}
+
+// UpsertTask stores a copy of t under t.ID if no task has that ID, or replaces
+// the text, completion, due date, list and parent of the task which does.
+// Either way t is set to the stored task, and whether it was created is
+// returned.
+func (r *Repository) UpsertTask(t *tasks.Task) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	task, err := r.patch(t.ID, func(e *tasks.Task) error {
+		e.Text = t.Text
+		e.IsComplete = t.IsComplete
+		e.DueAt = t.DueAt
+		e.List, e.ParentID = t.List, t.ParentID
+		return nil
+	})
+	if err == nil {
+		*t = *task
+		return false, nil
+	}
+
+	t.CreatedAt = time.Now().UTC()
+	t.UpdatedAt = t.CreatedAt
+	t.Priority, t.CompletedAt = "", nil
+	if t.IsComplete {
+		completed := t.UpdatedAt
+		t.CompletedAt = &completed
+	}
+
+	// The caller keeps t, so a copy is stored.
+	stored := *t
+	r.data[t.ID] = &stored
+
+	return true, nil
+}
+
+// patch patches a task. The caller must hold r.mu.
+func (r *Repository) patch(id string, patch func(*tasks.Task) error) (*tasks.Task, error) {
 	e, ok := r.data[id]
 	if !ok {
 		return nil, tasks.ErrTaskNotFound
 	}
 
-	var hasChanged bool
-
-	// if t.Text has changed AND it is not empty
-	if t.Text != e.Text && t.Text != "" {
-		e.Text = t.Text
-		hasChanged = true
+	// The patch is applied to a copy, so that the stored task is untouched if
+	// it fails.
+	p := *e
+	if err := patch(&p); err != nil {
+		return nil, err
 	}
 
-	if t.IsComplete != e.IsComplete {
-		e.IsComplete = t.IsComplete
-		hasChanged = true
+	// Only the text, completion, due date, list and parent of a task may be
+	// patched.
+	p.ID, p.CreatedAt, p.UpdatedAt = e.ID, e.CreatedAt, e.UpdatedAt
+	p.Priority, p.CompletedAt = e.Priority, e.CompletedAt
+	if !p.Changed(e) {
+		return e, nil
 	}
 
-	if hasChanged {
-		e.UpdatedAt = time.Now().UTC()
+	p.UpdatedAt = time.Now().UTC()
+	if p.IsComplete != e.IsComplete {
+		p.CompletedAt = nil
+		if p.IsComplete {
+			completed := p.UpdatedAt
+			p.CompletedAt = &completed
+		}
 	}
+	*e = p
 
 	return e, nil
 }
 
-// DeleteTask deletes the task by ID. Attempting to delete a task with an ID
-// which does not exist is not considered an error.
-func (r *Repository) DeleteTask(id string) error {
+// DeleteTask deletes the task by ID, reporting whether it existed.
+// Attempting to delete a task with an ID which does not exist is not
+// considered an error.
+func (r *Repository) DeleteTask(id string) (bool, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	_, ok := r.data[id]
 	delete(r.data, id)
 
-	return nil
+	return ok, nil
+}
+
+// CreateTasks creates many tasks. As with CreateTask, all fields except
+// Task.Text, Task.List and Task.ParentID will be overridden by defaults.
+func (r *Repository) CreateTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range ts {
+		r.create(t)
+	}
+
+	return make([]error, len(ts)), nil
+}
+
+// ImportTasks creates many tasks, keeping the IDs, times, completion, lists
+// and parents they are given.
+func (r *Repository) ImportTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := make([]error, len(ts))
+
+	// The only way an import can fail is if the ID is taken, so check for
+	// that up front rather than having to undo imports.
+	var failed bool
+	taken := make(map[string]bool)
+	for i, t := range ts {
+		if _, ok := r.data[t.ID]; ok || taken[t.ID] {
+			errs[i] = tasks.ErrTaskExists
+			failed = true
+		}
+		if t.ID != "" {
+			taken[t.ID] = true
+		}
+	}
+
+	if failed && mode == tasks.BatchAtomic {
+		return errs, tasks.ErrBatchAborted
+	}
+
+	for i, t := range ts {
+		if errs[i] != nil {
+			continue
+		}
+
+		if t.ID == "" {
+			t.ID = r.ids.NewID()
+		}
+		if t.CreatedAt.IsZero() {
+			t.CreatedAt = time.Now().UTC()
+		}
+		if t.UpdatedAt.IsZero() {
+			t.UpdatedAt = t.CreatedAt
+		}
+		if !t.IsComplete {
+			t.CompletedAt = nil
+		}
+		r.data[t.ID] = t
+	}
+
+	return errs, nil
+}
+
+// PatchTasks applies many patches, each as PatchTask does. The patched tasks
+// are returned at the index of the corresponding patch, or nil where a patch
+// failed.
+func (r *Repository) PatchTasks(ps []*tasks.TaskPatch, mode tasks.BatchMode) ([]*tasks.Task, []error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	updated := make([]*tasks.Task, len(ps))
+	errs := make([]error, len(ps))
+
+	// Patches can fail part way through, so the tasks are saved first to be
+	// restored if the batch is aborted.
+	saved := make(map[string]tasks.Task, len(ps))
+	for _, p := range ps {
+		if e, ok := r.data[p.ID]; ok {
+			if _, ok := saved[p.ID]; !ok {
+				saved[p.ID] = *e
+			}
+		}
+	}
+
+	var failed bool
+	for i, p := range ps {
+		updated[i], errs[i] = r.patch(p.ID, p.Patch)
+		failed = failed || errs[i] != nil
+	}
+
+	if failed && mode == tasks.BatchAtomic {
+		for id, e := range saved {
+			*r.data[id] = e
+		}
+		return make([]*tasks.Task, len(ps)), errs, tasks.ErrBatchAborted
+	}
+
+	return updated, errs, nil
+}
+
+// DeleteTasks deletes many tasks by ID. As with DeleteTask, deleting a task
+// which does not exist is not considered an error.
+func (r *Repository) DeleteTasks(ids []string, mode tasks.BatchMode) ([]bool, []error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := make([]bool, len(ids))
+	for i, id := range ids {
+		_, deleted[i] = r.data[id]
+		delete(r.data, id)
+	}
+
+	return deleted, make([]error, len(ids)), nil
 }
@@ -0,0 +1,46 @@
+package mock
+
+import (
+	"example.com/tasks"
+)
+
+// WithTx runs fn with the repository, restoring every task to how it was if
+// fn returns an error. Transactions are serialized with each other, but not
+// isolated from operations made outside of them.
+func (r *Repository) WithTx(fn func(repo tasks.TaskRepository) error) error {
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+
+	r.mu.RLock()
+	snapshot := make(map[string]tasks.Task, len(r.data))
+	for id, t := range r.data {
+		snapshot[id] = *t
+	}
+	r.mu.RUnlock()
+
+	err := fn(r)
+	if err == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.data {
+		if _, ok := snapshot[id]; !ok {
+			delete(r.data, id)
+		}
+	}
+
+	for id, t := range snapshot {
+		t := t
+		if e, ok := r.data[id]; ok {
+			// Callers may hold the stored pointer, so it is restored in place.
+			*e = t
+		} else {
+			r.data[id] = &t
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,156 @@
+package mock
+
+import (
+	"sort"
+	"time"
+
+	"example.com/tasks"
+)
+
+// maxLoggedDeliveries is the number of delivery attempts kept in the log of
+// each webhook.
+const maxLoggedDeliveries = 100
+
+// copyWebhook returns a copy of w which shares nothing with it.
+func copyWebhook(w *tasks.Webhook) *tasks.Webhook {
+	c := *w
+	c.Events = append([]string(nil), w.Events...)
+	return &c
+}
+
+// CreateWebhook stores a new webhook, setting its ID and timestamps.
+func (r *Repository) CreateWebhook(w *tasks.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.ID = r.ids.NewID()
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = w.CreatedAt
+	w.Failures = 0
+
+	r.webhooks[w.ID] = copyWebhook(w)
+	return nil
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func (r *Repository) ListWebhooks() ([]*tasks.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ws := make([]*tasks.Webhook, 0, len(r.webhooks))
+	for _, w := range r.webhooks {
+		ws = append(ws, copyWebhook(w))
+	}
+
+	sort.Slice(ws, func(i, j int) bool {
+		if !ws[i].CreatedAt.Equal(ws[j].CreatedAt) {
+			return ws[i].CreatedAt.Before(ws[j].CreatedAt)
+		}
+		return ws[i].ID < ws[j].ID
+	})
+
+	return ws, nil
+}
+
+// RetrieveWebhook returns the webhook with id.
+func (r *Repository) RetrieveWebhook(id string) (*tasks.Webhook, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w, ok := r.webhooks[id]
+	if !ok {
+		return nil, tasks.ErrWebhookNotFound
+	}
+
+	return copyWebhook(w), nil
+}
+
+// UpdateWebhook replaces the URL, Secret, Events and Disabled of the webhook
+// with w.ID. Failures is reset when the webhook is enabled. w is set to the
+// stored webhook.
+func (r *Repository) UpdateWebhook(w *tasks.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.webhooks[w.ID]
+	if !ok {
+		return tasks.ErrWebhookNotFound
+	}
+
+	w.CreatedAt = existing.CreatedAt
+	w.UpdatedAt = time.Now().UTC()
+	w.Failures = existing.Failures
+	if !w.Disabled {
+		w.Failures = 0
+	}
+
+	r.webhooks[w.ID] = copyWebhook(w)
+	return nil
+}
+
+// DeleteWebhook deletes the webhook with id, along with its delivery log.
+func (r *Repository) DeleteWebhook(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.webhooks[id]; !ok {
+		return tasks.ErrWebhookNotFound
+	}
+
+	delete(r.webhooks, id)
+	delete(r.deliveries, id)
+	return nil
+}
+
+// RecordWebhookResult counts a delivery to a webhook which succeeded or
+// failed, disabling the webhook once maxFailures deliveries in a row have
+// failed. The updated webhook is returned.
+func (r *Repository) RecordWebhookResult(id string, delivered bool, maxFailures int) (*tasks.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.webhooks[id]
+	if !ok {
+		return nil, tasks.ErrWebhookNotFound
+	}
+
+	if delivered {
+		w.Failures = 0
+	} else {
+		w.Failures++
+		w.Disabled = w.Disabled || w.Failures >= maxFailures
+	}
+
+	return copyWebhook(w), nil
+}
+
+// LogWebhookDelivery adds an attempt to the log of a webhook's deliveries.
+func (r *Repository) LogWebhookDelivery(d *tasks.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := *d
+	log := append(r.deliveries[d.WebhookID], &c)
+	if len(log) > maxLoggedDeliveries {
+		log = log[len(log)-maxLoggedDeliveries:]
+	}
+	r.deliveries[d.WebhookID] = log
+
+	return nil
+}
+
+// ListWebhookDeliveries returns up to limit of the most recent attempts
+// logged for a webhook, newest first.
+func (r *Repository) ListWebhookDeliveries(webhookID string, limit int) ([]*tasks.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	log := r.deliveries[webhookID]
+	ds := make([]*tasks.WebhookDelivery, 0, limit)
+	for i := len(log) - 1; i >= 0 && len(ds) < limit; i-- {
+		c := *log[i]
+		ds = append(ds, &c)
+	}
+
+	return ds, nil
+}
@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// backupTimeFormat is used to name backups so that they sort chronologically.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backup writes a consistent snapshot of the database to dest while the
+// repository continues to serve reads and writes. The file at dest must not
+// already exist.
+func (r *Repository) Backup(ctx context.Context, dest string) error {
+	// VACUUM INTO only reads from the database, so it is run on a reader to
+	// avoid blocking writes for the duration of the backup. Readers are query
+	// only though, which also forbids writing the destination file, so that is
+	// lifted on this connection while the backup runs.
+	conn, err := r.reader.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if r.reader != r.db {
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = false;"); err != nil {
+			return fmt.Errorf("failed to prepare connection for backup: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "PRAGMA query_only = true;")
+	}
+
+	if _, err := conn.ExecContext(ctx, "VACUUM INTO ?;", dest); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	return nil
+}
+
+// Restore replaces the database at dest with the backup at src. The backup is
+// checked for integrity and copied next to dest before being moved into place,
+// so a failed restore leaves dest untouched. Restore must not be called while
+// a repository has dest open.
+func Restore(src, dest string) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+
+	// The path is escaped, or characters such as ? and # in it would be
+	// taken for the parameters of the URI.
+	db, err := sqlx.Connect("sqlite3", "file:"+url.PathEscape(src)+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.Get(&result, "PRAGMA integrity_check;"); err != nil {
+		return fmt.Errorf("failed to check backup integrity: %w", err)
+	} else if result != "ok" {
+		return fmt.Errorf("backup failed integrity check: %s", result)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	// VACUUM INTO refuses to overwrite an existing file.
+	os.Remove(tmp.Name())
+
+	if _, err := db.Exec("VACUUM INTO ?;", tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	// Any write-ahead log left over belongs to the old database and must not
+	// be replayed on top of the restored one.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dest + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to remove %s: %w", dest+suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to move backup into place: %w", err)
+	}
+
+	return nil
+}
+
+// Backups writes timestamped backups of a repository into a directory,
+// removing the oldest ones so that only a fixed number are kept.
+type Backups struct {
+	repo *Repository
+	dir  string
+	keep int
+}
+
+// NewBackups creates a Backups which keeps the newest keep backups of repo in
+// dir. A keep of zero or less keeps every backup.
+func NewBackups(repo *Repository, dir string, keep int) *Backups {
+	return &Backups{
+		repo: repo,
+		dir:  dir,
+		keep: keep,
+	}
+}
+
+// Backup writes a new backup and prunes old ones. The path of the new backup
+// is returned.
+func (b *Backups) Backup(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	name := "tasks-" + time.Now().UTC().Format(backupTimeFormat) + ".db"
+	path := filepath.Join(b.dir, name)
+
+	if err := b.repo.Backup(ctx, path); err != nil {
+		return "", err
+	}
+
+	if err := b.prune(); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+// prune removes all but the newest b.keep backups.
+func (b *Backups) prune() error {
+	if b.keep <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), "tasks-") && strings.HasSuffix(e.Name(), ".db") {
+			names = append(names, e.Name())
+		}
+	}
+
+	if len(names) <= b.keep {
+		return nil
+	}
+
+	sort.Strings(names)
+	for _, name := range names[:len(names)-b.keep] {
+		if err := os.Remove(filepath.Join(b.dir, name)); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+
+	"example.com/tasks"
+)
+
+// CreateTasks creates many tasks in a single transaction. As with CreateTask,
+// all fields except Task.Text, Task.List and Task.ParentID will be overridden
+// by defaults.
+func (r *Repository) CreateTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	errs := make([]error, len(ts))
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		insert, err := tx.Preparex(insertTaskQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert: %w", err)
+		}
+		defer insert.Close()
+
+		for i, t := range ts {
+			args, err := r.insertArgs(t)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			if _, err := insert.Exec(args...); err != nil {
+				errs[i] = err
+			}
+		}
+
+		return batchResult(errs, mode)
+	})
+	if err != nil && err != tasks.ErrBatchAborted {
+		return errs, fmt.Errorf("failed to create tasks: %w", err)
+	}
+
+	return errs, err
+}
+
+// ImportTasks creates many tasks in a single transaction, keeping the IDs,
+// times and completion they are given.
+func (r *Repository) ImportTasks(ts []*tasks.Task, mode tasks.BatchMode) ([]error, error) {
+	errs := make([]error, len(ts))
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		insert, err := tx.Preparex(insertTaskQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert: %w", err)
+		}
+		defer insert.Close()
+
+		for i, t := range ts {
+			args, err := r.importArgs(t)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			if _, err := insert.Exec(args...); isUniqueViolation(err) {
+				errs[i] = tasks.ErrTaskExists
+			} else if err != nil {
+				errs[i] = err
+			}
+		}
+
+		return batchResult(errs, mode)
+	})
+	if err != nil && err != tasks.ErrBatchAborted {
+		return errs, fmt.Errorf("failed to import tasks: %w", err)
+	}
+
+	return errs, err
+}
+
+// isUniqueViolation reports whether err is from inserting a row whose key is
+// taken.
+func isUniqueViolation(err error) bool {
+	var se sqlite3.Error
+	return errors.As(err, &se) &&
+		(se.ExtendedCode == sqlite3.ErrConstraintUnique || se.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+// PatchTasks applies many patches, each as PatchTask does, in a single
+// transaction. The patched tasks are returned at the index of the
+// corresponding patch, or nil where a patch failed.
+func (r *Repository) PatchTasks(ps []*tasks.TaskPatch, mode tasks.BatchMode) ([]*tasks.Task, []error, error) {
+	updated := make([]*tasks.Task, len(ps))
+	errs := make([]error, len(ps))
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		retrieve, update, err := prepareUpdate(tx)
+		if err != nil {
+			return fmt.Errorf("failed to prepare update: %w", err)
+		}
+		defer retrieve.Close()
+		defer update.Close()
+
+		for i, p := range ps {
+			updated[i], errs[i] = r.patch(retrieve, update, p.ID, p.Patch)
+		}
+
+		return batchResult(errs, mode)
+	})
+	if err != nil && err != tasks.ErrBatchAborted {
+		return nil, errs, fmt.Errorf("failed to patch tasks: %w", err)
+	}
+
+	return updated, errs, err
+}
+
+// DeleteTasks deletes many tasks by ID in a single transaction. As with
+// DeleteTask, deleting a task which does not exist is not considered an error.
+func (r *Repository) DeleteTasks(ids []string, mode tasks.BatchMode) ([]bool, []error, error) {
+	deleted := make([]bool, len(ids))
+	errs := make([]error, len(ids))
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		del, err := tx.Preparex(deleteTaskQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare delete: %w", err)
+		}
+		defer del.Close()
+
+		for i, id := range ids {
+			res, err := del.Exec(id)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			n, err := res.RowsAffected()
+			deleted[i], errs[i] = n > 0, err
+		}
+
+		return batchResult(errs, mode)
+	})
+	if err != nil && err != tasks.ErrBatchAborted {
+		return deleted, errs, fmt.Errorf("failed to delete tasks: %w", err)
+	}
+
+	return deleted, errs, err
+}
+
+// batchResult returns tasks.ErrBatchAborted, causing the transaction to be
+// rolled back, if any item failed in an atomic batch.
+func batchResult(errs []error, mode tasks.BatchMode) error {
+	if mode != tasks.BatchAtomic {
+		return nil
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return tasks.ErrBatchAborted
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the name of the sqlite3 driver the repository connects with,
+// which registers the functions its queries use on every connection.
+const driverName = "sqlite3_tasks"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// sqlite's own lower and LIKE only fold ASCII, so text is
+			// searched with Go's case folding, as tasks.Filter does.
+			return conn.RegisterFunc("casefold", strings.ToLower, true)
+		},
+	})
+}
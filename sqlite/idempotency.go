@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"example.com/tasks"
+)
+
+// idempotencyRow is an idempotency record as it is stored in the
+// idempotency_keys table. Responses hold task text, so when KeyID is set Body
+// holds ciphertext like the text of a taskRow.
+type idempotencyRow struct {
+	Key         string         `db:"idempotency_key"`
+	Fingerprint string         `db:"fingerprint"`
+	CreatedAt   time.Time      `db:"created_at"`
+	ExpiresAt   time.Time      `db:"expires_at"`
+	Status      int            `db:"status"`
+	Header      sql.NullString `db:"header"`
+	Body        []byte         `db:"body"`
+	KeyID       sql.NullString `db:"key_id"`
+	DataKey     []byte         `db:"data_key"`
+}
+
+// ReserveIdempotencyKey claims key for a new request with fingerprint until
+// expiresAt. If the key is already claimed and has not expired, nothing is
+// changed and the existing record is returned. Otherwise the returned record
+// is nil.
+func (r *Repository) ReserveIdempotencyKey(key, fingerprint string, expiresAt time.Time) (*tasks.IdempotencyRecord, error) {
+	var existing *tasks.IdempotencyRecord
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		now := time.Now().UTC()
+
+		// Expired keys are swept here rather than on a schedule. The index on
+		// expires_at keeps this cheap.
+		if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE expires_at <= ?;", now); err != nil {
+			return err
+		}
+
+		row := &idempotencyRow{}
+		err := tx.Get(row, "SELECT * FROM idempotency_keys WHERE idempotency_key=?;", key)
+		if err == nil {
+			existing, err = r.openIdempotency(row)
+			return err
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO idempotency_keys (idempotency_key, fingerprint, created_at, expires_at) VALUES (?, ?, ?, ?);",
+			key, fingerprint, now, expiresAt.UTC(),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	return existing, nil
+}
+
+// CompleteIdempotencyKey stores the response to the request which reserved
+// key.
+func (r *Repository) CompleteIdempotencyKey(key string, status int, header map[string][]string, body []byte) error {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	var keyID sql.NullString
+	var dataKey []byte
+	if r.keyring != nil {
+		var ciphertext string
+		if keyID.String, dataKey, ciphertext, err = r.keyring.seal(rowContext("idempotency_keys", key), string(body)); err != nil {
+			return fmt.Errorf("failed to complete idempotency key: %w", err)
+		}
+		keyID.Valid = true
+		body = []byte(ciphertext)
+	}
+
+	if _, err := r.writer().Exec(
+		"UPDATE idempotency_keys SET status=?, header=?, body=?, key_id=?, data_key=? WHERE idempotency_key=?;",
+		status, string(h), body, keyID, dataKey, key,
+	); err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey gives up a reservation, so that the key can be used
+// again.
+func (r *Repository) ReleaseIdempotencyKey(key string) error {
+	if _, err := r.writer().Exec("DELETE FROM idempotency_keys WHERE idempotency_key=?;", key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// openIdempotency converts a row back to a record, decrypting the body if
+// needed.
+func (r *Repository) openIdempotency(row *idempotencyRow) (*tasks.IdempotencyRecord, error) {
+	rec := &tasks.IdempotencyRecord{
+		Key:         row.Key,
+		Fingerprint: row.Fingerprint,
+		CreatedAt:   row.CreatedAt,
+		ExpiresAt:   row.ExpiresAt,
+		Status:      row.Status,
+		Body:        row.Body,
+	}
+
+	if row.Header.Valid {
+		if err := json.Unmarshal([]byte(row.Header.String), &rec.Header); err != nil {
+			return nil, err
+		}
+	}
+
+	if row.KeyID.Valid {
+		if r.keyring == nil {
+			return nil, ErrNoKeyring
+		}
+
+		body, err := r.keyring.open(rowContext("idempotency_keys", row.Key), row.KeyID.String, row.DataKey, string(row.Body))
+		if err != nil {
+			return nil, err
+		}
+		rec.Body = []byte(body)
+	}
+
+	return rec, nil
+}
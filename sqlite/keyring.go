@@ -0,0 +1,220 @@
+package sqlite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// dataKeySize is the size, in bytes, of the per-row data encryption keys.
+	dataKeySize = 32
+
+	// boundPrefix marks ciphertexts which are bound to their rows and keys
+	// by associated data. Ciphertexts sealed before they were bound have no
+	// prefix, which base64 never contains, and are opened without associated
+	// data until they are rotated.
+	boundPrefix = "v2:"
+)
+
+var (
+	// ErrUnknownKey is returned when a row was encrypted with a key which is
+	// not present in the keyring.
+	ErrUnknownKey = errors.New("unknown encryption key")
+
+	// ErrNoKeyring is returned by operations which require a keyring when the
+	// repository was created without one.
+	ErrNoKeyring = errors.New("no keyring configured")
+)
+
+// Keyring holds the key encryption keys used to protect task text at rest.
+// Each row is encrypted with its own random data key, which is in turn sealed
+// with the keyring's primary key. Older keys are kept around so that rows
+// sealed with them can still be read until they are rotated.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from a set of AES-256 keys indexed by key ID.
+// The primary key is used to seal all new rows and must be one of keys.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{
+		primary: primary,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+
+		k.keys[id] = aead
+	}
+
+	if _, ok := k.keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+
+	return k, nil
+}
+
+// LoadKeyring reads a keyring from a JSON file of the form:
+//
+//	{
+//		"primary": "2020-03",
+//		"keys": {
+//			"2020-01": "<base64 encoded 32 byte key>",
+//			"2020-03": "<base64 encoded 32 byte key>"
+//		}
+//	}
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	var file struct {
+		Primary string            `json:"primary"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal keyring: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", id, err)
+		}
+		keys[id] = key
+	}
+
+	return NewKeyring(file.Primary, keys)
+}
+
+// Primary returns the ID of the key used to seal new rows.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// rowContext identifies the row of table with id, for binding the values
+// sealed for it to the row.
+func rowContext(table, id string) string {
+	return table + "/" + id
+}
+
+// associatedData binds a sealed value to the row identified by context and
+// the key its data key is wrapped with, so that neither the value nor its
+// data key can be moved to another row or relabelled with another key.
+func associatedData(context, keyID string) []byte {
+	return []byte(context + "\x00" + keyID)
+}
+
+// seal encrypts plaintext for the row identified by context with a fresh data
+// key and wraps that data key with the primary key. The returned ciphertext
+// is base64 encoded so that it can live in a text column.
+func (k *Keyring) seal(context, plaintext string) (keyID string, dataKey []byte, ciphertext string, err error) {
+	dek := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	ad := associatedData(context, k.primary)
+
+	sealedText, err := sealWith(aead, []byte(plaintext), ad)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	wrappedKey, err := sealWith(k.keys[k.primary], dek, ad)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	return k.primary, wrappedKey, boundPrefix + base64.StdEncoding.EncodeToString(sealedText), nil
+}
+
+// open reverses seal.
+func (k *Keyring) open(context, keyID string, dataKey []byte, ciphertext string) (string, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	var ad []byte
+	if strings.HasPrefix(ciphertext, boundPrefix) {
+		ad = associatedData(context, keyID)
+		ciphertext = strings.TrimPrefix(ciphertext, boundPrefix)
+	}
+
+	dek, err := openWith(kek, dataKey, ad)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	sealedText, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+
+	plaintext, err := openWith(aead, sealedText, ad)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt text: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealWith encrypts and authenticates data, and authenticates ad, prefixing
+// the result with a random nonce.
+func sealWith(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, data, ad), nil
+}
+
+// openWith decrypts data produced by sealWith with the same ad.
+func openWith(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, ad)
+}
@@ -0,0 +1,265 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"example.com/tasks"
+)
+
+// textScanBatchSize is the number of rows fetched at a time when text has to
+// be filtered after decryption.
+const textScanBatchSize = 200
+
+// sortID sorts by ID. It is only used internally to break ties.
+const sortID tasks.SortField = "id"
+
+// sortColumns maps sort fields to the columns they sort by.
+var sortColumns = map[tasks.SortField]string{
+	tasks.SortCreatedAt:  "created_at",
+	tasks.SortUpdatedAt:  "updated_at",
+	tasks.SortIsComplete: "is_complete",
+	sortID:               "id",
+}
+
+// ListTasks lists a page of tasks in the repo.
+func (r *Repository) ListTasks(opts tasks.ListOptions) (*tasks.TaskPage, error) {
+	// Encrypted text can't be searched by sqlite, so when there is a keyring
+	// the text filter is applied to the decrypted rows instead.
+	filterText := r.keyring != nil && opts.Filter.TextContains != ""
+
+	limit := 0
+	if opts.Limit > 0 {
+		// Fetch one more than asked for to find out if there is another page.
+		limit = opts.Limit + 1
+	}
+
+	var (
+		ts  []*tasks.Task
+		err error
+	)
+	if filterText {
+		ts, err = r.scanText(opts, opts.Cursor, limit)
+	} else {
+		ts, err = r.list(opts, opts.Cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	page := tasks.NewTaskPage(ts, opts)
+
+	if opts.Count {
+		if filterText {
+			all, err := r.scanText(opts, nil, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count tasks: %w", err)
+			}
+			page.Total = len(all)
+		} else {
+			where, args := filterClause(&opts.Filter)
+			query := "SELECT COUNT(*) FROM tasks " + where + ";"
+			if err := r.readers().Get(&page.Total, query, args...); err != nil {
+				return nil, fmt.Errorf("failed to count tasks: %w", err)
+			}
+		}
+	}
+
+	return page, nil
+}
+
+// list fetches up to limit tasks matching opts.Filter from the position c, in
+// the order described by tasks.NewTaskPage. A limit of zero fetches every
+// task.
+func (r *Repository) list(opts tasks.ListOptions, c *tasks.Cursor, limit int) ([]*tasks.Task, error) {
+	where, args := filterClause(&opts.Filter)
+
+	backwards := c != nil && c.Before
+	if c != nil {
+		clause, cursorArgs := cursorClause(c, opts.Sort)
+		if where == "" {
+			where = "WHERE " + clause
+		} else {
+			where += " AND " + clause
+		}
+		args = append(args, cursorArgs...)
+	}
+
+	// A negative limit means no limit to sqlite.
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf("SELECT * FROM tasks %s ORDER BY %s LIMIT ?;", where, orderClause(opts.Sort, backwards))
+	rows := make([]*taskRow, 0)
+
+	if err := r.readers().Select(&rows, query, args...); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	ts := make([]*tasks.Task, len(rows))
+	for i, row := range rows {
+		t, err := r.open(row)
+		if err != nil {
+			return nil, err
+		}
+		ts[i] = t
+	}
+
+	return ts, nil
+}
+
+// scanText behaves like list, except that the text filter is applied after
+// rows have been decrypted. Rows are read in batches from c until limit
+// matching tasks have been found or there are no rows left.
+func (r *Repository) scanText(opts tasks.ListOptions, c *tasks.Cursor, limit int) ([]*tasks.Task, error) {
+	text := opts.Filter.TextContains
+	textFilter := tasks.Filter{TextContains: text}
+	opts.Filter.TextContains = ""
+
+	var matched []*tasks.Task
+	for {
+		batch, err := r.list(opts, c, textScanBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range batch {
+			if textFilter.Match(t) {
+				matched = append(matched, t)
+				if limit > 0 && len(matched) == limit {
+					return matched, nil
+				}
+			}
+		}
+
+		if len(batch) < textScanBatchSize {
+			return matched, nil
+		}
+
+		// Continue from the last row of the batch in the same direction.
+		c = tasks.CursorAt(batch[len(batch)-1], opts.Sort, c != nil && c.Before)
+	}
+}
+
+// filterClause builds a WHERE clause, which may be empty, for f.
+func filterClause(f *tasks.Filter) (string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+
+	if f.IsComplete != nil {
+		conds = append(conds, "is_complete = ?")
+		args = append(args, *f.IsComplete)
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at > ?")
+		args = append(args, f.CreatedAfter.UTC())
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.CreatedBefore.UTC())
+	}
+	if !f.UpdatedAfter.IsZero() {
+		conds = append(conds, "updated_at > ?")
+		args = append(args, f.UpdatedAfter.UTC())
+	}
+	if !f.UpdatedBefore.IsZero() {
+		conds = append(conds, "updated_at < ?")
+		args = append(args, f.UpdatedBefore.UTC())
+	}
+	if f.TextContains != "" {
+		conds = append(conds, "instr(casefold(text), ?) > 0")
+		args = append(args, strings.ToLower(f.TextContains))
+	}
+	if f.List != nil {
+		conds = append(conds, "list = ?")
+		args = append(args, *f.List)
+	}
+	if f.ParentID != nil {
+		conds = append(conds, "parent_id = ?")
+		args = append(args, *f.ParentID)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderClause builds the ORDER BY expression for keys, reversed if listing
+// backwards.
+func orderClause(keys []tasks.SortKey, backwards bool) string {
+	var parts []string
+	for _, k := range effectiveSort(keys) {
+		dir := "ASC"
+		if k.Desc != backwards {
+			dir = "DESC"
+		}
+		parts = append(parts, sortColumns[k.Field]+" "+dir)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// cursorClause builds a condition matching the rows after, or before, the
+// cursor's position. For a sort of a, b this expands to
+//
+//	a > ? OR (a = ? AND b > ?) OR (a = ? AND b = ? AND id > ?)
+//
+// with the comparisons flipped for descending keys and when listing
+// backwards.
+func cursorClause(c *tasks.Cursor, keys []tasks.SortKey) (string, []interface{}) {
+	at := c.Task()
+
+	var (
+		ors    []string
+		args   []interface{}
+		equals []string
+		eqArgs []interface{}
+	)
+	for _, k := range effectiveSort(keys) {
+		col := sortColumns[k.Field]
+		op := ">"
+		if k.Desc != c.Before {
+			op = "<"
+		}
+
+		val := sortValue(at, k.Field)
+		cond := append(append([]string{}, equals...), fmt.Sprintf("%s %s ?", col, op))
+		ors = append(ors, "("+strings.Join(cond, " AND ")+")")
+		args = append(append(args, eqArgs...), val)
+
+		equals = append(equals, col+" = ?")
+		eqArgs = append(eqArgs, val)
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// effectiveSort returns keys with the default sort and the ID tie breaker
+// applied.
+func effectiveSort(keys []tasks.SortKey) []tasks.SortKey {
+	if len(keys) == 0 {
+		keys = tasks.DefaultSort
+	}
+
+	return append(append([]tasks.SortKey{}, keys...), tasks.SortKey{Field: sortID})
+}
+
+func sortValue(t *tasks.Task, f tasks.SortField) interface{} {
+	switch f {
+	case tasks.SortCreatedAt:
+		return t.CreatedAt.UTC()
+	case tasks.SortUpdatedAt:
+		return t.UpdatedAt.UTC()
+	case tasks.SortIsComplete:
+		return t.IsComplete
+	default:
+		return t.ID
+	}
+}
@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrations are applied in order to bring a database up to date. The index
+// of a migration plus one is stored in the database's user_version once it
+// has been applied, so migrations must never be reordered or edited, only
+// appended to.
+var migrations = []string{
+	`
+CREATE TABLE IF NOT EXISTS tasks (
+	id TEXT,
+	created_at DATETIME,
+	updated_at DATETIME,
+	text TEXT,
+	is_complete BOOLEAN
+);
+`,
+	`
+ALTER TABLE tasks ADD COLUMN key_id TEXT;
+ALTER TABLE tasks ADD COLUMN data_key BLOB;
+`,
+	`
+CREATE UNIQUE INDEX IF NOT EXISTS tasks_id ON tasks (id);
+CREATE INDEX IF NOT EXISTS tasks_created_at_id ON tasks (created_at, id);
+`,
+	`
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	idempotency_key TEXT PRIMARY KEY,
+	fingerprint TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	status INTEGER NOT NULL DEFAULT 0,
+	header TEXT,
+	body BLOB,
+	key_id TEXT,
+	data_key BLOB
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
+`,
+	`
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL DEFAULT '',
+	disabled BOOLEAN NOT NULL DEFAULT 0,
+	failures INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL,
+	key_id TEXT,
+	data_key BLOB
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	id TEXT NOT NULL,
+	webhook_id TEXT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	attempt INTEGER NOT NULL,
+	status_code INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	duration INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_seq ON webhook_deliveries (webhook_id, seq);
+`,
+	`
+ALTER TABLE tasks ADD COLUMN priority TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN completed_at DATETIME;
+UPDATE tasks SET completed_at = updated_at WHERE is_complete;
+`,
+	`
+ALTER TABLE tasks ADD COLUMN due_at DATETIME;
+`,
+	`
+ALTER TABLE tasks ADD COLUMN list TEXT NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
+`,
+}
+
+// migrate applies any migrations which have not yet been applied to db.
+func migrate(db *sqlx.DB) error {
+	var version int
+	if err := db.Get(&version, "PRAGMA user_version;"); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Beginx()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+
+		// PRAGMA statements cannot be parameterized.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
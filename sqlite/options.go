@@ -0,0 +1,130 @@
+package sqlite
+
+import (
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/tasks"
+)
+
+// options holds the configuration of a Repository.
+type options struct {
+	ids         tasks.IDGenerator
+	keyring     *Keyring
+	journalMode string
+	busyTimeout time.Duration
+	foreignKeys bool
+	synchronous string
+	maxReaders  int
+}
+
+func defaultOptions() *options {
+	return &options{
+		ids:         tasks.UUIDv4,
+		journalMode: "WAL",
+		busyTimeout: 5 * time.Second,
+		foreignKeys: true,
+		synchronous: "NORMAL",
+		maxReaders:  runtime.NumCPU(),
+	}
+}
+
+// Option configures a Repository.
+type Option func(*options)
+
+// WithIDGenerator sets the generator used for the IDs of new tasks. Defaults
+// to tasks.UUIDv4.
+func WithIDGenerator(g tasks.IDGenerator) Option {
+	return func(o *options) {
+		o.ids = g
+	}
+}
+
+// WithKeyring enables encryption of task text at rest using the given keyring.
+// Rows written before a keyring was configured remain readable and are
+// encrypted the next time they are written or when keys are rotated.
+func WithKeyring(k *Keyring) Option {
+	return func(o *options) {
+		o.keyring = k
+	}
+}
+
+// WithJournalMode sets the journal mode of the database, e.g. "WAL" or
+// "DELETE". Defaults to "WAL", which allows readers to proceed while a write
+// is in progress.
+func WithJournalMode(mode string) Option {
+	return func(o *options) {
+		o.journalMode = mode
+	}
+}
+
+// WithBusyTimeout sets how long a connection waits for a lock held by another
+// connection before failing with "database is locked". Defaults to five
+// seconds.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// WithForeignKeys sets whether foreign key constraints are enforced. Defaults
+// to true.
+func WithForeignKeys(enabled bool) Option {
+	return func(o *options) {
+		o.foreignKeys = enabled
+	}
+}
+
+// WithSynchronous sets the synchronous mode of the database, e.g. "NORMAL" or
+// "FULL". Defaults to "NORMAL", which is durable in WAL mode except against
+// power loss.
+func WithSynchronous(mode string) Option {
+	return func(o *options) {
+		o.synchronous = mode
+	}
+}
+
+// WithMaxReaders sets the maximum number of connections used for reads.
+// Writes always go through a single connection. Defaults to the number of
+// CPUs.
+func WithMaxReaders(n int) Option {
+	return func(o *options) {
+		o.maxReaders = n
+	}
+}
+
+// dsn builds a data source name for s with the configured pragmas applied.
+// Read only connections refuse to write.
+func (o *options) dsn(s string, readOnly bool) string {
+	params := url.Values{}
+	params.Set("_journal_mode", o.journalMode)
+	params.Set("_busy_timeout", strconv.FormatInt(int64(o.busyTimeout/time.Millisecond), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(o.foreignKeys))
+	params.Set("_synchronous", o.synchronous)
+
+	if readOnly {
+		params.Set("_query_only", "true")
+	} else {
+		// Take the write lock when a transaction begins rather than when it
+		// first writes, so that two transactions can't both read and then
+		// deadlock upgrading to a write.
+		params.Set("_txlock", "immediate")
+	}
+
+	sep := "?"
+	if strings.Contains(s, "?") {
+		sep = "&"
+	}
+
+	return s + sep + params.Encode()
+}
+
+// isMemory reports whether s names an in-memory database. Each connection to
+// an in-memory database sees its own database, so these can't be split into
+// separate read and write pools.
+func isMemory(s string) bool {
+	return s == "" || strings.HasPrefix(s, ":memory:") || strings.Contains(s, "mode=memory")
+}
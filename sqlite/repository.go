@@ -11,112 +11,389 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const initializeTableQuery = `
-CREATE TABLE IF NOT EXISTS tasks (
-	id TEXT,
-	created_at DATETIME,
-	updated_at DATETIME,
-	text TEXT,
-	is_complete BOOLEAN
-);
-`
-
-// Repository is an sqlite3 implementation of a repository.
+// Repository is an sqlite3 implementation of a repository. Writes are
+// serialized through a single connection, which avoids "database is locked"
+// errors between writers, while reads are spread across a pool of read only
+// connections.
 type Repository struct {
-	db *sqlx.DB
+	ids     tasks.IDGenerator
+	db      *sqlx.DB
+	reader  *sqlx.DB
+	keyring *Keyring
+
+	// tx is set on the copies of a repository handed out by WithTx, and
+	// used in place of db and reader.
+	tx *sqlx.Tx
+}
+
+// queryer is the part of sqlx implemented by both *sqlx.DB and *sqlx.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+}
+
+// writer returns where writes should be made.
+func (r *Repository) writer() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// readers returns where reads should be made. Within a transaction they must
+// be made on it, to see its writes.
+func (r *Repository) readers() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.reader
 }
 
 // New connects to a database, creating it if it doesn't exist, and
-// initializes a repository. Errors come from connection issues. This
-// method will panic if it encounters an error setting up the tables in the
-// database at all.
-func New(s string) (*Repository, error) {
-	db, err := sqlx.Connect("sqlite3", s)
+// initializes a repository. Errors come from connection issues or from
+// failing to bring the database schema up to date.
+func New(s string, opts ...Option) (*Repository, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	db, err := sqlx.Connect(driverName, o.dsn(s, false))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
+	db.SetMaxOpenConns(1)
 
-	sqlx.MustExec(db, initializeTableQuery)
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate: %w", err)
+	}
 
 	repo := &Repository{
-		db: db,
+		ids:     o.ids,
+		db:      db,
+		reader:  db,
+		keyring: o.keyring,
+	}
+
+	if !isMemory(s) {
+		reader, err := sqlx.Connect(driverName, o.dsn(s, true))
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect reader: %w", err)
+		}
+		reader.SetMaxOpenConns(o.maxReaders)
+		reader.SetMaxIdleConns(o.maxReaders)
+
+		repo.reader = reader
 	}
 
 	return repo, nil
 }
 
-// CreateTask creates a new task. All fields except Task.Text will be
-// overridden by defaults.
+// transact runs fn in a transaction on the writer. The transaction is
+// committed if fn returns nil and rolled back otherwise. Within WithTx, fn
+// runs in a savepoint of the enclosing transaction instead, so that it can
+// fail without undoing the rest of the transaction.
+func (r *Repository) transact(fn func(tx *sqlx.Tx) error) error {
+	if r.tx != nil {
+		if _, err := r.tx.Exec("SAVEPOINT nested;"); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+
+		if err := fn(r.tx); err != nil {
+			r.tx.Exec("ROLLBACK TO nested;")
+			r.tx.Exec("RELEASE nested;")
+			return err
+		}
+
+		if _, err := r.tx.Exec("RELEASE nested;"); err != nil {
+			return fmt.Errorf("failed to release savepoint: %w", err)
+		}
+		return nil
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// WithTx runs fn with a copy of the repository whose operations all take place
+// in one transaction. The transaction is committed if fn returns nil and
+// rolled back otherwise.
+func (r *Repository) WithTx(fn func(repo tasks.TaskRepository) error) error {
+	return r.transact(func(tx *sqlx.Tx) error {
+		txRepo := *r
+		txRepo.tx = tx
+		return fn(&txRepo)
+	})
+}
+
+// Close closes the connections to the database.
+func (r *Repository) Close() error {
+	if r.reader != r.db {
+		if err := r.reader.Close(); err != nil {
+			r.db.Close()
+			return err
+		}
+	}
+
+	return r.db.Close()
+}
+
+const (
+	insertTaskQuery   = "INSERT INTO tasks (id, created_at, updated_at, text, is_complete, due_at, priority, completed_at, list, parent_id, key_id, data_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+	retrieveTaskQuery = "SELECT * FROM tasks WHERE id=? LIMIT 1;"
+	updateTaskQuery   = "UPDATE tasks SET updated_at=?, text=?, is_complete=?, due_at=?, completed_at=?, list=?, parent_id=?, key_id=?, data_key=? WHERE id=?;"
+	deleteTaskQuery   = "DELETE FROM tasks WHERE id=?;"
+)
+
+// CreateTask creates a new task. All fields except Task.Text, Task.List and
+// Task.ParentID will be overridden by defaults.
 func (r *Repository) CreateTask(t *tasks.Task) error {
-	const query = "INSERT INTO tasks (id, created_at, updated_at, text, is_complete) VALUES (?, ?, ?, ?, ?);"
+	args, err := r.insertArgs(t)
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+
+	if _, err := r.writer().Exec(insertTaskQuery, args...); err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+
+	return nil
+}
 
-	t.ID = tasks.NewTaskID()
+// insertArgs sets the defaults of a new task and returns the arguments for
+// insertTaskQuery.
+func (r *Repository) insertArgs(t *tasks.Task) ([]interface{}, error) {
+	t.ID = r.ids.NewID()
 	t.CreatedAt = time.Now().UTC()
 	t.UpdatedAt = t.CreatedAt
 	t.IsComplete = false
+	t.DueAt = nil
+	t.Priority = ""
+	t.CompletedAt = nil
 
-	if _, err := r.db.Exec(query, t.ID, t.CreatedAt, t.UpdatedAt, t.Text, t.IsComplete); err != nil {
-		return fmt.Errorf("failed to create task: %w", err)
+	return r.sealedArgs(t)
+}
+
+// importArgs sets the defaults of an imported task which were not given and
+// returns the arguments for insertTaskQuery.
+func (r *Repository) importArgs(t *tasks.Task) ([]interface{}, error) {
+	if t.ID == "" {
+		t.ID = r.ids.NewID()
+	}
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now().UTC()
+	}
+	if t.UpdatedAt.IsZero() {
+		t.UpdatedAt = t.CreatedAt
+	}
+	if !t.IsComplete {
+		t.CompletedAt = nil
 	}
 
-	return nil
+	return r.sealedArgs(t)
 }
 
-// ListTasks lists all tasks in the repo.
-func (r *Repository) ListTasks() ([]*tasks.Task, error) {
-	const query = "SELECT * FROM tasks;"
-	ts := make([]*tasks.Task, 0)
-
-	if err := r.db.Select(&ts, query); err != nil && err != sql.ErrNoRows {
-		return nil, fmt.Errorf("failed to list tasks: %w", err)
+// sealedArgs seals t and returns the arguments for insertTaskQuery.
+func (r *Repository) sealedArgs(t *tasks.Task) ([]interface{}, error) {
+	row, err := r.seal(t)
+	if err != nil {
+		return nil, err
 	}
 
-	return ts, nil
+	return []interface{}{row.ID, row.CreatedAt, row.UpdatedAt, row.Text, row.IsComplete, row.DueAt, row.Priority, row.CompletedAt, row.List, row.ParentID, row.KeyID, row.DataKey}, nil
 }
 
 // RetrieveTask retrieves the task from the repo by ID.
 func (r *Repository) RetrieveTask(id string) (*tasks.Task, error) {
-	const query = "SELECT * FROM tasks WHERE id=? LIMIT 1;"
-	task := &tasks.Task{}
+	row := &taskRow{}
 
-	if err := r.db.Get(task, query, id); err == sql.ErrNoRows {
+	if err := r.readers().Get(row, retrieveTaskQuery, id); err == sql.ErrNoRows {
 		return nil, tasks.ErrTaskNotFound
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to retrieve task: %w", err)
 	}
 
+	task, err := r.open(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve task: %w", err)
+	}
+
 	return task, nil
 }
 
-// UpdateTask updates a task, by id, in the repo. If the task, does not exist,
-// it will return tasks.ErrTaskNotFound. Only t.Text and t.IsCompleted are used
-// to update the fields. The returned Task is the updated version of the task.
-func (r *Repository) UpdateTask(id string, t *tasks.Task) (*tasks.Task, error) {
-	const query = "UPDATE tasks SET text=?, is_complete=? WHERE id=?;"
-	const getQuery = "SELECT * FROM tasks WHERE id=? LIMIT 1;"
-	var task tasks.Task
+// PatchTask applies patch to a task, by id, within a transaction. If the task
+// does not exist, it will return tasks.ErrTaskNotFound. If patch returns an
+// error, the task is left unchanged and the error is returned.
+func (r *Repository) PatchTask(id string, patch func(t *tasks.Task) error) (*tasks.Task, error) {
+	var task *tasks.Task
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		retrieve, update, err := prepareUpdate(tx)
+		if err != nil {
+			return err
+		}
+		defer retrieve.Close()
+		defer update.Close()
+
+		task, err = r.patch(retrieve, update, id, patch)
+		return err
+	})
+	if tasks.KindOf(err) != tasks.KindInternal {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to patch task: %w", err)
+	}
+
+	return task, nil
+}
+
+// UpsertTask creates a task with the ID t.ID if none exists, or replaces the
+// text, completion, due date, list and parent of the existing one, within a
+// transaction. The CreatedAt of an existing task is preserved. t is set to
+// the stored task, and whether it was created is returned.
+func (r *Repository) UpsertTask(t *tasks.Task) (bool, error) {
+	var created bool
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		retrieve, update, err := prepareUpdate(tx)
+		if err != nil {
+			return err
+		}
+		defer retrieve.Close()
+		defer update.Close()
+
+		task, err := r.patch(retrieve, update, t.ID, func(e *tasks.Task) error {
+			e.Text = t.Text
+			e.IsComplete = t.IsComplete
+			e.DueAt = t.DueAt
+			e.List, e.ParentID = t.List, t.ParentID
+			return nil
+		})
+		if err != tasks.ErrTaskNotFound {
+			if err == nil {
+				*t = *task
+			}
+			return err
+		}
+
+		created = true
+		t.CreatedAt = time.Now().UTC()
+		t.UpdatedAt = t.CreatedAt
+		t.Priority, t.CompletedAt = "", nil
+		if t.IsComplete {
+			completed := t.UpdatedAt
+			t.CompletedAt = &completed
+		}
+
+		args, err := r.sealedArgs(t)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(insertTaskQuery, args...)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to upsert task: %w", err)
+	}
+
+	return created, nil
+}
+
+// prepareUpdate prepares the statements used by patch within tx.
+func prepareUpdate(tx *sqlx.Tx) (retrieve, update *sqlx.Stmt, err error) {
+	retrieve, err = tx.Preparex(retrieveTaskQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	update, err = tx.Preparex(updateTaskQuery)
+	if err != nil {
+		retrieve.Close()
+		return nil, nil, err
+	}
 
-	if _, err := r.db.Exec(query, t.Text, t.IsComplete, id); err == sql.ErrNoRows {
+	return retrieve, update, nil
+}
+
+// patch applies patch to the task with the given id using statements
+// prepared by prepareUpdate.
+func (r *Repository) patch(retrieve, update *sqlx.Stmt, id string, patch func(*tasks.Task) error) (*tasks.Task, error) {
+	row := &taskRow{}
+	if err := retrieve.Get(row, id); err == sql.ErrNoRows {
 		return nil, tasks.ErrTaskNotFound
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to update task: %w", err)
+		return nil, err
+	}
+
+	e, err := r.open(row)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := r.db.Get(&task, getQuery, id); err != nil {
-		return nil, fmt.Errorf("failed to retrieve task after update: %w", err)
+	before := *e
+	if err := patch(e); err != nil {
+		return nil, err
 	}
 
-	return &task, nil
+	// Only the text, completion, due date, list and parent of a task may be
+	// patched.
+	e.ID, e.CreatedAt, e.UpdatedAt = before.ID, before.CreatedAt, before.UpdatedAt
+	e.Priority, e.CompletedAt = before.Priority, before.CompletedAt
+	if !e.Changed(&before) {
+		return e, nil
+	}
+
+	e.UpdatedAt = time.Now().UTC()
+	if e.IsComplete != before.IsComplete {
+		e.CompletedAt = nil
+		if e.IsComplete {
+			completed := e.UpdatedAt
+			e.CompletedAt = &completed
+		}
+	}
+
+	sealed, err := r.seal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := update.Exec(sealed.UpdatedAt, sealed.Text, sealed.IsComplete, sealed.DueAt, sealed.CompletedAt, sealed.List, sealed.ParentID, sealed.KeyID, sealed.DataKey, id); err != nil {
+		return nil, err
+	}
+
+	return e, nil
 }
 
-// DeleteTask deletes the task by ID. Attempting to delete a task with an ID
-// which does not exist is not considered an error.
-func (r *Repository) DeleteTask(id string) error {
-	const query = "DELETE FROM tasks WHERE id=?;"
+// DeleteTask deletes the task by ID, reporting whether it existed.
+// Attempting to delete a task with an ID which does not exist is not
+// considered an error.
+func (r *Repository) DeleteTask(id string) (bool, error) {
+	res, err := r.writer().Exec(deleteTaskQuery, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete task: %w", err)
+	}
 
-	if _, err := r.db.Exec(query, id); err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("failed to delete task: %w", err)
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete task: %w", err)
 	}
 
-	return nil
+	return n > 0, nil
 }
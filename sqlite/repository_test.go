@@ -1,6 +1,19 @@
 package sqlite
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -60,38 +73,744 @@ func TestListTasks(t *testing.T) {
 		id, time.Now().UTC(), time.Now().UTC(), "testing", false,
 	)
 
-	tasks, err := repo.ListTasks()
-	is.NoErr(err)                      // Error from ListTask
-	is.Equal(id, tasks[0].ID)          // should be id
-	is.Equal("testing", tasks[0].Text) // should be "testing"
+	page, err := repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                           // Error from ListTask
+	is.Equal(id, page.Tasks[0].ID)          // should be id
+	is.Equal("testing", page.Tasks[0].Text) // should be "testing"
 }
 
-func TestUpdateTask(t *testing.T) {
+func TestPatchTask(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	existing := &tasks.Task{Text: "changeme"}
+	is.NoErr(repo.CreateTask(existing)) // Error from CreateTask
+
+	failed := errors.New("patch failed")
+	_, err := repo.PatchTask(existing.ID, func(t *tasks.Task) error {
+		t.Text = "testing"
+		return failed
+	})
+	is.True(errors.Is(err, failed)) // Error from the patch is returned
+
+	task, err := repo.RetrieveTask(existing.ID)
+	is.NoErr(err)                   // Error from RetrieveTask
+	is.Equal(task.Text, "changeme") // Failed patch is not stored
+
+	task, err = repo.PatchTask(existing.ID, func(t *tasks.Task) error {
+		t.IsComplete = true
+		t.ID = "ignored"
+		return nil
+	})
+	is.NoErr(err)                                     // Error from PatchTask
+	is.Equal(task.ID, existing.ID)                    // ID cannot be patched
+	is.True(task.IsComplete)                          // Completion is patched
+	is.Equal(task.Text, "changeme")                   // Text is untouched
+	is.True(task.UpdatedAt.After(existing.UpdatedAt)) // UpdatedAt is bumped
+	is.Equal(*task.CompletedAt, task.UpdatedAt)       // CompletedAt is set on completion
+
+	task, err = repo.RetrieveTask(existing.ID)
+	is.NoErr(err)                               // Error from RetrieveTask
+	is.Equal(*task.CompletedAt, task.UpdatedAt) // CompletedAt is stored
+
+	task, err = repo.PatchTask(existing.ID, func(t *tasks.Task) error {
+		t.IsComplete = false
+		return nil
+	})
+	is.NoErr(err)                   // Error from PatchTask
+	is.Equal(task.CompletedAt, nil) // CompletedAt is cleared on reopening
+
+	parentID := tasks.NewTaskID()
+	_, err = repo.PatchTask(existing.ID, func(t *tasks.Task) error {
+		t.List, t.ParentID = "Errands", parentID
+		return nil
+	})
+	is.NoErr(err) // Error from PatchTask
+
+	task, err = repo.RetrieveTask(existing.ID)
+	is.NoErr(err)                     // Error from RetrieveTask
+	is.Equal(task.List, "Errands")    // List is stored
+	is.Equal(task.ParentID, parentID) // Parent is stored
+
+	_, err = repo.PatchTask(tasks.NewTaskID(), func(t *tasks.Task) error { return nil })
+	is.Equal(err, tasks.ErrTaskNotFound) // Missing tasks are not found
+}
+
+func TestUpsertTask(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	task := &tasks.Task{ID: tasks.NewTaskID(), Text: "offline", IsComplete: true}
+	created, err := repo.UpsertTask(task)
+	is.NoErr(err)            // Error from UpsertTask
+	is.True(created)         // Missing task is created
+	is.True(task.IsComplete) // Completion is kept on create
+
+	replacement := &tasks.Task{ID: task.ID, Text: "synced"}
+	created, err = repo.UpsertTask(replacement)
+	is.NoErr(err)                                        // Error from UpsertTask
+	is.True(!created)                                    // Existing task is replaced
+	is.True(replacement.CreatedAt.Equal(task.CreatedAt)) // CreatedAt is preserved
+
+	stored, err := repo.RetrieveTask(task.ID)
+	is.NoErr(err)                   // Error from RetrieveTask
+	is.Equal(stored.Text, "synced") // Text is replaced
+	is.True(!stored.IsComplete)     // Completion is replaced
+}
+
+func TestDeleteTask(t *testing.T) {
 	is := is.New(t)
 	repo := newInMemoryRepository(t)
 	id := tasks.NewTaskID()
+
+	deleted, err := repo.DeleteTask(id)
+	is.NoErr(err)     // Error from DeleteTask
+	is.True(!deleted) // Missing tasks are not reported as deleted
+
 	sqlx.MustExec(repo.db,
 		`INSERT INTO tasks (id, created_at, updated_at, text, is_complete) VALUES (?, ?, ?, ?, ?);`,
 		id, time.Now().UTC(), time.Now().UTC(), "changeme", false,
 	)
 
-	task, err := repo.UpdateTask(id, &tasks.Task{Text: "testing"})
-	is.NoErr(err)                  // Error from UpdateTask
-	is.Equal(id, task.ID)          // should be id
-	is.Equal("testing", task.Text) // should be "testing"
+	deleted, err = repo.DeleteTask(id)
+	is.NoErr(err)    // Error from DeleteTask
+	is.True(deleted) // Existing tasks are reported as deleted
 }
 
-func TestDeleteTask(t *testing.T) {
+func newKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+	t.Helper()
+
+	keys := make(map[string][]byte)
+	for i, id := range ids {
+		keys[id] = bytes.Repeat([]byte{byte(i + 1)}, 32)
+	}
+
+	k, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatalf("could not create keyring: %s", err)
+	}
+
+	return k
+}
+
+func TestEncryptedText(t *testing.T) {
 	is := is.New(t)
 	repo := newInMemoryRepository(t)
-	id := tasks.NewTaskID()
+	repo.keyring = newKeyring(t, "k1", "k1")
+
+	task := &tasks.Task{Text: "call the customer"}
+	is.NoErr(repo.CreateTask(task)) // Error from CreateTask
+
+	var stored struct {
+		Text  string `db:"text"`
+		KeyID string `db:"key_id"`
+	}
+	is.NoErr(repo.db.Get(&stored, "SELECT text, key_id FROM tasks WHERE id=?;", task.ID))
+	is.Equal(stored.KeyID, "k1")                                     // should be sealed with k1
+	is.True(!strings.Contains(stored.Text, "customer"))              // text should not be stored in plaintext
+	is.True(stored.Text != "" && stored.Text != "call the customer") // text should be ciphertext
+
+	retrieved, err := repo.RetrieveTask(task.ID)
+	is.NoErr(err)                                 // Error from RetrieveTask
+	is.Equal(retrieved.Text, "call the customer") // should be decrypted
+
+	listed, err := repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                                       // Error from ListTasks
+	is.Equal(listed.Tasks[0].Text, "call the customer") // should be decrypted
 
-	is.NoErr(repo.DeleteTask(id)) // Error from DeleteTask
+	// Sealed values are bound to their rows, so they cannot be moved to
+	// another row to be read through it.
+	other := &tasks.Task{Text: "something else"}
+	is.NoErr(repo.CreateTask(other)) // Error from CreateTask
+	sqlx.MustExec(repo.db, "UPDATE tasks SET text=(SELECT text FROM tasks WHERE id=?), data_key=(SELECT data_key FROM tasks WHERE id=?) WHERE id=?;", task.ID, task.ID, other.ID)
+	_, err = repo.RetrieveTask(other.ID)
+	is.True(err != nil) // moved values should not open
+}
+
+func TestRotateKeys(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
 
+	// A plaintext row written before encryption was enabled.
+	plainID := tasks.NewTaskID()
 	sqlx.MustExec(repo.db,
 		`INSERT INTO tasks (id, created_at, updated_at, text, is_complete) VALUES (?, ?, ?, ?, ?);`,
-		id, time.Now().UTC(), time.Now().UTC(), "changeme", false,
+		plainID, time.Now().UTC(), time.Now().UTC(), "plaintext", false,
 	)
 
-	is.NoErr(repo.DeleteTask(id)) // Error from DeleteTask
+	// A row sealed with an old key.
+	repo.keyring = newKeyring(t, "old", "old")
+	old := &tasks.Task{Text: "sealed with old"}
+	is.NoErr(repo.CreateTask(old))
+
+	// A webhook secret and a stored response sealed with the old key, and a
+	// reserved key which has no response yet.
+	hook := &tasks.Webhook{URL: "https://example.com/hook", Secret: "0123456789abcdef"}
+	is.NoErr(repo.CreateWebhook(hook)) // Error from CreateWebhook
+	expires := time.Now().Add(time.Hour)
+	_, err := repo.ReserveIdempotencyKey("completed", "fingerprint", expires)
+	is.NoErr(err)                                                                                            // Error from ReserveIdempotencyKey
+	is.NoErr(repo.CompleteIdempotencyKey("completed", http.StatusCreated, nil, []byte(`{"text":"secret"}`))) // Error from CompleteIdempotencyKey
+	_, err = repo.ReserveIdempotencyKey("reserved", "fingerprint", expires)
+	is.NoErr(err) // Error from ReserveIdempotencyKey
+
+	// A row sealed with the primary key before values were bound to their
+	// rows.
+	unbound := &tasks.Task{Text: "sealed unbound"}
+	is.NoErr(repo.CreateTask(unbound)) // Error from CreateTask
+	dek := make([]byte, dataKeySize)
+	aead, err := newAEAD(dek)
+	is.NoErr(err) // Error from newAEAD
+	sealedText, err := sealWith(aead, []byte("sealed unbound"), nil)
+	is.NoErr(err) // Error from sealWith
+	wrappedKey, err := sealWith(repo.keyring.keys["old"], dek, nil)
+	is.NoErr(err) // Error from sealWith
+	sqlx.MustExec(repo.db, "UPDATE tasks SET text=?, data_key=? WHERE id=?;", base64.StdEncoding.EncodeToString(sealedText), wrappedKey, unbound.ID)
+
+	task, err := repo.RetrieveTask(unbound.ID)
+	is.NoErr(err)                         // Error from RetrieveTask
+	is.Equal(task.Text, "sealed unbound") // unbound rows should still open
+
+	rotated, err := repo.RotateKeys(context.Background(), 1)
+	is.NoErr(err)        // Error from RotateKeys
+	is.Equal(rotated, 2) // plaintext and unbound rows should be re-encrypted
+
+	repo.keyring = newKeyring(t, "new", "old", "new")
+	rotated, err = repo.RotateKeys(context.Background(), 1)
+	is.NoErr(err)        // Error from RotateKeys
+	is.Equal(rotated, 5) // every sealed row should be re-encrypted
+
+	for _, table := range []string{"tasks", "webhooks"} {
+		var remaining int
+		is.NoErr(repo.db.Get(&remaining, "SELECT COUNT(*) FROM "+table+" WHERE key_id IS NULL OR key_id != 'new';"))
+		is.Equal(remaining, 0) // every row should be sealed with the new key
+	}
+
+	// The old key can be removed once rotation completes.
+	repo.keyring = &Keyring{primary: "new", keys: map[string]cipher.AEAD{"new": repo.keyring.keys["new"]}}
+
+	task, err = repo.RetrieveTask(plainID)
+	is.NoErr(err)                    // Error from RetrieveTask
+	is.Equal(task.Text, "plaintext") // should survive rotation
+
+	task, err = repo.RetrieveTask(old.ID)
+	is.NoErr(err)                          // Error from RetrieveTask
+	is.Equal(task.Text, "sealed with old") // should survive rotation
+
+	task, err = repo.RetrieveTask(unbound.ID)
+	is.NoErr(err)                         // Error from RetrieveTask
+	is.Equal(task.Text, "sealed unbound") // should survive rotation
+
+	retrieved, err := repo.RetrieveWebhook(hook.ID)
+	is.NoErr(err)                                  // Error from RetrieveWebhook
+	is.Equal(retrieved.Secret, "0123456789abcdef") // secret should survive rotation
+
+	rec, err := repo.ReserveIdempotencyKey("completed", "fingerprint", expires)
+	is.NoErr(err)                                   // Error from ReserveIdempotencyKey
+	is.Equal(string(rec.Body), `{"text":"secret"}`) // response should survive rotation
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	dir, err := ioutil.TempDir("", "tasks-backup")
+	is.NoErr(err) // Error creating temporary directory
+	defer os.RemoveAll(dir)
+
+	task := &tasks.Task{Text: "testing"}
+	is.NoErr(repo.CreateTask(task)) // Error from CreateTask
+
+	// Characters which mean something in a URI must not confuse Restore.
+	backup := filepath.Join(dir, "backup #1?%.db")
+	is.NoErr(repo.Backup(context.Background(), backup)) // Error from Backup
+
+	database := filepath.Join(dir, "tasks.db")
+	is.NoErr(Restore(backup, database)) // Error from Restore
+
+	restored, err := New(database)
+	is.NoErr(err) // Error opening restored database
+
+	got, err := restored.RetrieveTask(task.ID)
+	is.NoErr(err)                 // Error from RetrieveTask
+	is.Equal(got.Text, "testing") // should be "testing"
+}
+
+func TestBackupsRotation(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	dir, err := ioutil.TempDir("", "tasks-backups")
+	is.NoErr(err) // Error creating temporary directory
+	defer os.RemoveAll(dir)
+
+	backups := NewBackups(repo, dir, 2)
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := backups.Backup(context.Background())
+		is.NoErr(err) // Error from Backup
+		paths = append(paths, path)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	is.NoErr(err)             // Error listing backups
+	is.Equal(len(entries), 2) // should keep two backups
+
+	_, err = os.Stat(paths[0])
+	is.True(os.IsNotExist(err)) // oldest backup should be removed
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	is := is.New(t)
+
+	dir, err := ioutil.TempDir("", "tasks-concurrent")
+	is.NoErr(err) // Error creating temporary directory
+	defer os.RemoveAll(dir)
+
+	repo, err := New(filepath.Join(dir, "tasks.db"))
+	is.NoErr(err) // Error from New
+	defer repo.Close()
+
+	errs := make(chan error, 8*25*2)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				errs <- repo.CreateTask(&tasks.Task{Text: "testing"})
+				_, err := repo.ListTasks(tasks.ListOptions{Limit: 10})
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		is.NoErr(err) // Error from concurrent CreateTask or ListTasks
+	}
+}
+
+// openBaseline opens a repository as New did before it was tuned for
+// concurrency: with sqlite's stock settings and a single pool of connections
+// shared by reads and writes.
+func openBaseline(path string) (*Repository, error) {
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Repository{ids: tasks.UUIDv4, db: db, reader: db}, nil
+}
+
+// BenchmarkConcurrentReadWrite measures throughput of a mixed workload of one
+// write for every four reads issued from many goroutines, with the defaults
+// tuned for concurrency and with the baseline they replaced. Operations which
+// fail, such as with "database is locked", are reported as errors/op.
+func BenchmarkConcurrentReadWrite(b *testing.B) {
+	configs := []struct {
+		name string
+		open func(path string) (*Repository, error)
+	}{
+		{"baseline", openBaseline},
+		{"tuned", func(path string) (*Repository, error) { return New(path) }},
+	}
+
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "tasks-bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			repo, err := c.open(filepath.Join(dir, "tasks.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer repo.Close()
+
+			seed := &tasks.Task{Text: "seed"}
+			if err := repo.CreateTask(seed); err != nil {
+				b.Fatal(err)
+			}
+
+			var failed int64
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					var err error
+					if i%5 == 0 {
+						err = repo.CreateTask(&tasks.Task{Text: "benchmark"})
+					} else {
+						_, err = repo.RetrieveTask(seed.ID)
+					}
+					if err != nil {
+						atomic.AddInt64(&failed, 1)
+					}
+				}
+			})
+			b.ReportMetric(float64(failed)/float64(b.N), "errors/op")
+		})
+	}
+}
+
+func TestCreateTasks(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	ts := []*tasks.Task{{Text: "one"}, {Text: "two"}}
+	errs, err := repo.CreateTasks(ts, tasks.BatchAtomic)
+	is.NoErr(err)                     // Error from CreateTasks
+	is.Equal(errs, []error{nil, nil}) // no item should fail
+
+	listed, err := repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                  // Error from ListTasks
+	is.Equal(len(listed.Tasks), 2) // both tasks should be created
+}
+
+func TestImportTasks(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	existing := &tasks.Task{Text: "existing"}
+	is.NoErr(repo.CreateTask(existing)) // Error from CreateTask
+
+	created := time.Date(2019, time.May, 1, 10, 0, 0, 0, time.UTC)
+	ts := []*tasks.Task{
+		{ID: tasks.NewTaskID(), CreatedAt: created, Text: "kept", IsComplete: true, Priority: "A", CompletedAt: &created},
+		{Text: "generated", List: "Errands", ParentID: existing.ID},
+		{ID: existing.ID, Text: "taken"},
+	}
+
+	errs, err := repo.ImportTasks(ts, tasks.BatchBestEffort)
+	is.NoErr(err)                          // Error from ImportTasks
+	is.NoErr(errs[0])                      // task with an ID should be imported
+	is.NoErr(errs[1])                      // task without an ID should be imported
+	is.Equal(errs[2], tasks.ErrTaskExists) // taken ID should fail
+
+	kept, err := repo.RetrieveTask(ts[0].ID)
+	is.NoErr(err)                            // Error from RetrieveTask
+	is.True(kept.IsComplete)                 // completion should be kept
+	is.True(kept.CreatedAt.Equal(created))   // creation time should be kept
+	is.True(kept.UpdatedAt.Equal(created))   // update time should default to creation
+	is.Equal(kept.Priority, "A")             // priority should be kept
+	is.True(kept.CompletedAt.Equal(created)) // completion time should be kept
+	is.True(tasks.ValidTaskID(ts[1].ID))     // missing ID should be generated
+
+	generated, err := repo.RetrieveTask(ts[1].ID)
+	is.NoErr(err)                             // Error from RetrieveTask
+	is.Equal(generated.List, "Errands")       // list should be kept
+	is.Equal(generated.ParentID, existing.ID) // parent should be kept
+}
+
+func TestPatchTasks(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	existing := &tasks.Task{Text: "changeme"}
+	is.NoErr(repo.CreateTask(existing)) // Error from CreateTask
+
+	setText := func(t *tasks.Task) error {
+		t.Text = "testing"
+		return nil
+	}
+	ps := []*tasks.TaskPatch{
+		{ID: existing.ID, Patch: setText},
+		{ID: tasks.NewTaskID(), Patch: setText},
+	}
+
+	_, errs, err := repo.PatchTasks(ps, tasks.BatchAtomic)
+	is.Equal(err, tasks.ErrBatchAborted)     // atomic batch should be aborted
+	is.NoErr(errs[0])                        // existing task should not fail
+	is.Equal(errs[1], tasks.ErrTaskNotFound) // missing task should not be found
+
+	task, err := repo.RetrieveTask(existing.ID)
+	is.NoErr(err)                   // Error from RetrieveTask
+	is.Equal(task.Text, "changeme") // aborted update should be rolled back
+
+	updated, errs, err := repo.PatchTasks(ps, tasks.BatchBestEffort)
+	is.NoErr(err)                            // Error from PatchTasks
+	is.Equal(errs[1], tasks.ErrTaskNotFound) // missing task should not be found
+	is.Equal(updated[0].Text, "testing")     // existing task should be updated
+
+	task, err = repo.RetrieveTask(existing.ID)
+	is.NoErr(err)                  // Error from RetrieveTask
+	is.Equal(task.Text, "testing") // best effort update should be committed
+}
+
+func TestDeleteTasks(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	task := &tasks.Task{Text: "testing"}
+	is.NoErr(repo.CreateTask(task)) // Error from CreateTask
+
+	deleted, errs, err := repo.DeleteTasks([]string{task.ID, tasks.NewTaskID()}, tasks.BatchAtomic)
+	is.NoErr(err)                          // Error from DeleteTasks
+	is.Equal(errs, []error{nil, nil})      // deleting missing tasks is not an error
+	is.Equal(deleted, []bool{true, false}) // only existing tasks are reported as deleted
+
+	_, err = repo.RetrieveTask(task.ID)
+	is.Equal(err, tasks.ErrTaskNotFound) // task should be deleted
+}
+
+func TestListTasksPagination(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	created := time.Now().UTC()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id := tasks.NewTaskID()
+		ids = append(ids, id)
+		sqlx.MustExec(repo.db,
+			`INSERT INTO tasks (id, created_at, updated_at, text, is_complete) VALUES (?, ?, ?, ?, ?);`,
+			id, created.Add(time.Duration(i)*time.Second), created, "testing", false,
+		)
+	}
+
+	first, err := repo.ListTasks(tasks.ListOptions{Limit: 2, Count: true})
+	is.NoErr(err)                       // Error from ListTasks
+	is.Equal(len(first.Tasks), 2)       // should be a full page
+	is.Equal(first.Tasks[0].ID, ids[0]) // should start at the beginning
+	is.Equal(first.Total, 5)            // should count every task
+	is.True(first.Prev == nil)          // should have no previous page
+	is.True(first.Next != nil)          // should have a next page
+
+	second, err := repo.ListTasks(tasks.ListOptions{Limit: 2, Cursor: first.Next})
+	is.NoErr(err)                        // Error from ListTasks
+	is.Equal(second.Tasks[0].ID, ids[2]) // should continue after the first page
+	is.Equal(second.Tasks[1].ID, ids[3]) // should continue after the first page
+	is.Equal(second.Total, -1)           // should not count without being asked
+	is.True(second.Prev != nil)          // should have a previous page
+
+	last, err := repo.ListTasks(tasks.ListOptions{Limit: 2, Cursor: second.Next})
+	is.NoErr(err)                      // Error from ListTasks
+	is.Equal(len(last.Tasks), 1)       // should be the remainder
+	is.Equal(last.Tasks[0].ID, ids[4]) // should be the last task
+	is.True(last.Next == nil)          // should have no next page
+
+	back, err := repo.ListTasks(tasks.ListOptions{Limit: 2, Cursor: second.Prev})
+	is.NoErr(err)                      // Error from ListTasks
+	is.Equal(back.Tasks[0].ID, ids[0]) // should return to the first page in order
+	is.Equal(back.Tasks[1].ID, ids[1]) // should return to the first page in order
+	is.True(back.Prev == nil)          // should have no previous page
+	is.True(back.Next != nil)          // should have a next page
+}
+
+func TestListTasksFilterAndSort(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		is := is.New(t)
+		repo := newInMemoryRepository(t)
+		if encrypted {
+			repo.keyring = newKeyring(t, "k1", "k1")
+		}
+
+		now := time.Now().UTC()
+		seed := []struct {
+			text       string
+			isComplete bool
+			updated    time.Duration
+		}{
+			{"pay invoice 1", false, -1 * time.Hour},
+			{"pay INVOICE 2", false, -2 * time.Hour},
+			{"pay invoice 3", true, -3 * time.Hour},
+			{"pay invoice 4", false, -48 * time.Hour},
+			{"walk the dog", false, -4 * time.Hour},
+			{"100% done_", false, -5 * time.Hour},
+			{"ÉCOLE trip", false, -6 * time.Hour},
+		}
+		for i, s := range seed {
+			task := &tasks.Task{Text: s.text}
+			is.NoErr(repo.CreateTask(task)) // Error from CreateTask
+			sqlx.MustExec(repo.db, "UPDATE tasks SET created_at=?, updated_at=?, is_complete=? WHERE id=?;",
+				now.Add(time.Duration(i)*time.Second), now.Add(s.updated), s.isComplete, task.ID)
+		}
+
+		incomplete := false
+		opts := tasks.ListOptions{
+			Limit: 1,
+			Count: true,
+			Filter: tasks.Filter{
+				IsComplete:   &incomplete,
+				UpdatedAfter: now.Add(-24 * time.Hour),
+				TextContains: "invoice",
+			},
+			Sort: []tasks.SortKey{{Field: tasks.SortUpdatedAt, Desc: true}},
+		}
+
+		first, err := repo.ListTasks(opts)
+		is.NoErr(err)                                  // Error from ListTasks
+		is.Equal(first.Total, 2)                       // should count matching tasks only
+		is.Equal(first.Tasks[0].Text, "pay invoice 1") // should be most recently updated first
+
+		opts.Cursor = first.Next
+		second, err := repo.ListTasks(opts)
+		is.NoErr(err)                                   // Error from ListTasks
+		is.Equal(len(second.Tasks), 1)                  // should be one more match
+		is.Equal(second.Tasks[0].Text, "pay INVOICE 2") // should match case insensitively
+		is.True(second.Next == nil)                     // should be no more matches
+
+		wildcards, err := repo.ListTasks(tasks.ListOptions{Filter: tasks.Filter{TextContains: "0% done_"}})
+		is.NoErr(err)                     // Error from ListTasks
+		is.Equal(len(wildcards.Tasks), 1) // LIKE wildcards should match literally
+
+		accented, err := repo.ListTasks(tasks.ListOptions{Filter: tasks.Filter{TextContains: "école"}})
+		is.NoErr(err)                    // Error from ListTasks
+		is.Equal(len(accented.Tasks), 1) // should fold case beyond ASCII, like tasks.Filter
+
+		child := &tasks.Task{Text: "subtask"}
+		is.NoErr(repo.CreateTask(child)) // Error from CreateTask
+		sqlx.MustExec(repo.db, "UPDATE tasks SET parent_id=? WHERE id=?;", first.Tasks[0].ID, child.ID)
+
+		subtasks, err := repo.ListTasks(tasks.ListOptions{Filter: tasks.Filter{ParentID: &first.Tasks[0].ID}})
+		is.NoErr(err)                            // Error from ListTasks
+		is.Equal(len(subtasks.Tasks), 1)         // should match subtasks of the parent only
+		is.Equal(subtasks.Tasks[0].ID, child.ID) // should be the subtask
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+	repo.keyring = newKeyring(t, "k1", "k1")
+
+	existing, err := repo.ReserveIdempotencyKey("abc", "fp", time.Now().Add(time.Hour))
+	is.NoErr(err)            // Error from ReserveIdempotencyKey
+	is.True(existing == nil) // New key is reserved
+
+	existing, err = repo.ReserveIdempotencyKey("abc", "fp", time.Now().Add(time.Hour))
+	is.NoErr(err)                // Error from ReserveIdempotencyKey
+	is.True(existing.InFlight()) // Reserved key is in flight
+
+	header := map[string][]string{"Content-Type": {"application/json"}}
+	is.NoErr(repo.CompleteIdempotencyKey("abc", 201, header, []byte(`{"text":"secret"}`))) // Error from CompleteIdempotencyKey
+
+	var stored []byte
+	is.NoErr(repo.db.Get(&stored, "SELECT body FROM idempotency_keys WHERE idempotency_key=?;", "abc")) // Error from Get
+	is.True(!bytes.Contains(stored, []byte("secret")))                                                  // Body is encrypted at rest
+
+	existing, err = repo.ReserveIdempotencyKey("abc", "fp", time.Now().Add(time.Hour))
+	is.NoErr(err)                                        // Error from ReserveIdempotencyKey
+	is.Equal(existing.Status, 201)                       // Status is stored
+	is.Equal(existing.Header, header)                    // Header is stored
+	is.Equal(string(existing.Body), `{"text":"secret"}`) // Body is decrypted
+
+	is.NoErr(repo.ReleaseIdempotencyKey("abc")) // Error from ReleaseIdempotencyKey
+	existing, err = repo.ReserveIdempotencyKey("abc", "other", time.Now().Add(-time.Second))
+	is.NoErr(err)            // Error from ReserveIdempotencyKey
+	is.True(existing == nil) // Released key can be reserved again
+
+	existing, err = repo.ReserveIdempotencyKey("abc", "fp", time.Now().Add(time.Hour))
+	is.NoErr(err)            // Error from ReserveIdempotencyKey
+	is.True(existing == nil) // Expired key can be reserved again
+}
+
+func TestWithTx(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+
+	existing := &tasks.Task{Text: "changeme"}
+	is.NoErr(repo.CreateTask(existing)) // Error from CreateTask
+
+	failed := errors.New("failed")
+	err := repo.WithTx(func(tx tasks.TaskRepository) error {
+		created := &tasks.Task{Text: "created"}
+		is.NoErr(tx.CreateTask(created)) // Error from CreateTask
+
+		// Reads within the transaction see its writes.
+		_, err := tx.RetrieveTask(created.ID)
+		is.NoErr(err) // Error from RetrieveTask
+
+		// A failed atomic batch only rolls back itself.
+		_, _, err = tx.DeleteTasks([]string{existing.ID}, tasks.BatchAtomic)
+		is.NoErr(err) // Error from DeleteTasks
+		setText := func(t *tasks.Task) error {
+			t.Text = "x"
+			return nil
+		}
+		_, _, err = tx.PatchTasks([]*tasks.TaskPatch{{ID: created.ID, Patch: setText}, {ID: tasks.NewTaskID(), Patch: setText}}, tasks.BatchAtomic)
+		is.Equal(err, tasks.ErrBatchAborted) // Error from PatchTasks
+
+		task, err := tx.RetrieveTask(created.ID)
+		is.NoErr(err)                  // Error from RetrieveTask
+		is.Equal(task.Text, "created") // Aborted batch was rolled back to its savepoint
+
+		return failed
+	})
+	is.Equal(err, failed) // Error from fn is returned
+
+	page, err := repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                           // Error from ListTasks
+	is.Equal(len(page.Tasks), 1)            // Transaction was rolled back
+	is.Equal(page.Tasks[0].ID, existing.ID) // Deleted task is restored
+
+	is.NoErr(repo.WithTx(func(tx tasks.TaskRepository) error {
+		return tx.CreateTask(&tasks.Task{Text: "committed"})
+	})) // Error from WithTx
+
+	page, err = repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                // Error from ListTasks
+	is.Equal(len(page.Tasks), 2) // Transaction was committed
+}
+
+func TestWebhooks(t *testing.T) {
+	is := is.New(t)
+	repo := newInMemoryRepository(t)
+	repo.keyring = newKeyring(t, "k1", "k1")
+
+	hook := &tasks.Webhook{URL: "https://example.com/hook", Secret: "0123456789abcdef", Events: []string{"created", "deleted"}}
+	is.NoErr(repo.CreateWebhook(hook)) // Error from CreateWebhook
+	is.True(hook.ID != "")             // ID was set
+
+	var stored string
+	is.NoErr(repo.db.Get(&stored, "SELECT secret FROM webhooks WHERE id=?;", hook.ID)) // Error from Get
+	is.True(stored != hook.Secret)                                                     // Secret is encrypted at rest
+
+	got, err := repo.RetrieveWebhook(hook.ID)
+	is.NoErr(err)                                        // Error from RetrieveWebhook
+	is.Equal(got.Secret, hook.Secret)                    // Secret is decrypted
+	is.Equal(got.Events, []string{"created", "deleted"}) // Events are stored
+
+	for i := 0; i < 2; i++ {
+		got, err = repo.RecordWebhookResult(hook.ID, false, 2)
+		is.NoErr(err) // Error from RecordWebhookResult
+	}
+	is.Equal(got.Failures, 2) // Failures are counted
+	is.True(got.Disabled)     // Webhook is disabled after too many failures
+
+	got.Disabled = false
+	is.NoErr(repo.UpdateWebhook(got)) // Error from UpdateWebhook
+	is.Equal(got.Failures, 0)         // Enabling resets the failures
+
+	for i := 0; i < maxLoggedDeliveries+5; i++ {
+		is.NoErr(repo.LogWebhookDelivery(&tasks.WebhookDelivery{
+			ID:        "d" + strconv.Itoa(i),
+			WebhookID: hook.ID,
+			EventID:   uint64(i),
+			EventType: "created",
+			Attempt:   1,
+			CreatedAt: time.Now(),
+		})) // Error from LogWebhookDelivery
+	}
+
+	ds, err := repo.ListWebhookDeliveries(hook.ID, maxLoggedDeliveries+5)
+	is.NoErr(err)                                          // Error from ListWebhookDeliveries
+	is.Equal(len(ds), maxLoggedDeliveries)                 // Old attempts are pruned
+	is.Equal(ds[0].EventID, uint64(maxLoggedDeliveries+4)) // Newest attempts are listed first
+
+	is.NoErr(repo.DeleteWebhook(hook.ID))                           // Error from DeleteWebhook
+	is.Equal(repo.DeleteWebhook(hook.ID), tasks.ErrWebhookNotFound) // Deleted webhook is gone
+
+	ds, err = repo.ListWebhookDeliveries(hook.ID, 10)
+	is.NoErr(err)        // Error from ListWebhookDeliveries
+	is.Equal(len(ds), 0) // Log is deleted with the webhook
 }
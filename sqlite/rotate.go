@@ -0,0 +1,163 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// rotation re-encrypts the rows of one table.
+type rotation struct {
+	table string
+
+	// rotate re-encrypts at most limit rows of the table which are not
+	// sealed with the primary key, or are not bound to their rows, within
+	// tx, and returns how many it did.
+	rotate func(tx *sqlx.Tx, limit int) (int, error)
+}
+
+// RotateKeys re-encrypts every row which is not sealed with the keyring's
+// primary key, including rows which were written in plaintext and rows sealed
+// before their values were bound to them by associated data: the text of
+// tasks, the secrets of webhooks and the responses stored for idempotency
+// keys. Rows are processed batchSize at a time, each batch in its own
+// transaction, so a large table does not hold the database lock for the
+// whole rotation. The number of re-encrypted rows is returned, even if an
+// error occurs part way.
+func (r *Repository) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	if r.keyring == nil {
+		return 0, ErrNoKeyring
+	}
+
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	rotations := []rotation{
+		{"tasks", r.rotateTasks},
+		{"webhooks", r.rotateWebhooks},
+		{"idempotency_keys", r.rotateIdempotencyKeys},
+	}
+
+	var rotated int
+	for _, rot := range rotations {
+		for {
+			if err := ctx.Err(); err != nil {
+				return rotated, err
+			}
+
+			tx, err := r.db.Beginx()
+			if err != nil {
+				return rotated, fmt.Errorf("failed to begin rotation batch: %w", err)
+			}
+
+			n, err := rot.rotate(tx, batchSize)
+			if err != nil {
+				tx.Rollback()
+				return rotated, fmt.Errorf("failed to rotate %s: %w", rot.table, err)
+			}
+
+			if n == 0 {
+				tx.Rollback()
+				break
+			}
+
+			if err := tx.Commit(); err != nil {
+				return rotated, fmt.Errorf("failed to commit rotation batch: %w", err)
+			}
+
+			rotated += n
+		}
+	}
+
+	return rotated, nil
+}
+
+// rotateTasks re-encrypts the text of tasks.
+func (r *Repository) rotateTasks(tx *sqlx.Tx, limit int) (int, error) {
+	const query = "SELECT * FROM tasks WHERE key_id IS NULL OR key_id != ? OR substr(text, 1, ?) != ? LIMIT ?;"
+	const update = "UPDATE tasks SET text=?, key_id=?, data_key=? WHERE id=?;"
+
+	rows := make([]*taskRow, 0, limit)
+	if err := tx.Select(&rows, query, r.keyring.Primary(), len(boundPrefix), boundPrefix, limit); err != nil {
+		return 0, fmt.Errorf("failed to select rows to rotate: %w", err)
+	}
+
+	for _, row := range rows {
+		task, err := r.open(row)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open task %s: %w", row.ID, err)
+		}
+
+		sealed, err := r.seal(task)
+		if err != nil {
+			return 0, fmt.Errorf("failed to seal task %s: %w", row.ID, err)
+		}
+
+		if _, err := tx.Exec(update, sealed.Text, sealed.KeyID, sealed.DataKey, row.ID); err != nil {
+			return 0, fmt.Errorf("failed to update task %s: %w", row.ID, err)
+		}
+	}
+
+	return len(rows), nil
+}
+
+// rotateWebhooks re-encrypts the secrets of webhooks.
+func (r *Repository) rotateWebhooks(tx *sqlx.Tx, limit int) (int, error) {
+	const query = "SELECT * FROM webhooks WHERE key_id IS NULL OR key_id != ? OR substr(secret, 1, ?) != ? LIMIT ?;"
+	const update = "UPDATE webhooks SET secret=?, key_id=?, data_key=? WHERE id=?;"
+
+	rows := make([]*webhookRow, 0, limit)
+	if err := tx.Select(&rows, query, r.keyring.Primary(), len(boundPrefix), boundPrefix, limit); err != nil {
+		return 0, fmt.Errorf("failed to select rows to rotate: %w", err)
+	}
+
+	for _, row := range rows {
+		w, err := r.openWebhook(row)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open webhook %s: %w", row.ID, err)
+		}
+
+		sealed, err := r.sealWebhook(w)
+		if err != nil {
+			return 0, fmt.Errorf("failed to seal webhook %s: %w", row.ID, err)
+		}
+
+		if _, err := tx.Exec(update, sealed.Secret, sealed.KeyID, sealed.DataKey, row.ID); err != nil {
+			return 0, fmt.Errorf("failed to update webhook %s: %w", row.ID, err)
+		}
+	}
+
+	return len(rows), nil
+}
+
+// rotateIdempotencyKeys re-encrypts the responses stored for idempotency
+// keys. Keys which are reserved but not completed have no response yet.
+func (r *Repository) rotateIdempotencyKeys(tx *sqlx.Tx, limit int) (int, error) {
+	const query = "SELECT * FROM idempotency_keys WHERE body IS NOT NULL AND (key_id IS NULL OR key_id != ? OR substr(CAST(body AS TEXT), 1, ?) != ?) LIMIT ?;"
+	const update = "UPDATE idempotency_keys SET body=?, key_id=?, data_key=? WHERE idempotency_key=?;"
+
+	rows := make([]*idempotencyRow, 0, limit)
+	if err := tx.Select(&rows, query, r.keyring.Primary(), len(boundPrefix), boundPrefix, limit); err != nil {
+		return 0, fmt.Errorf("failed to select rows to rotate: %w", err)
+	}
+
+	for _, row := range rows {
+		rec, err := r.openIdempotency(row)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open idempotency key %s: %w", row.Key, err)
+		}
+
+		keyID, dataKey, ciphertext, err := r.keyring.seal(rowContext("idempotency_keys", row.Key), string(rec.Body))
+		if err != nil {
+			return 0, fmt.Errorf("failed to seal idempotency key %s: %w", row.Key, err)
+		}
+
+		if _, err := tx.Exec(update, []byte(ciphertext), keyID, dataKey, row.Key); err != nil {
+			return 0, fmt.Errorf("failed to update idempotency key %s: %w", row.Key, err)
+		}
+	}
+
+	return len(rows), nil
+}
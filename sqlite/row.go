@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"example.com/tasks"
+)
+
+// taskRow is a task as it is stored in the tasks table. When KeyID is set,
+// Text holds ciphertext which must be opened with the keyring before the task
+// is handed back to callers.
+type taskRow struct {
+	tasks.Task
+	KeyID   sql.NullString `db:"key_id"`
+	DataKey []byte         `db:"data_key"`
+}
+
+// seal converts a task to a row, encrypting the text if the repository has a
+// keyring.
+func (r *Repository) seal(t *tasks.Task) (*taskRow, error) {
+	row := &taskRow{Task: *t}
+	if r.keyring == nil {
+		return row, nil
+	}
+
+	keyID, dataKey, ciphertext, err := r.keyring.seal(rowContext("tasks", t.ID), t.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	row.Text = ciphertext
+	row.KeyID = sql.NullString{String: keyID, Valid: true}
+	row.DataKey = dataKey
+
+	return row, nil
+}
+
+// open converts a row back to a task, decrypting the text if needed.
+func (r *Repository) open(row *taskRow) (*tasks.Task, error) {
+	t := row.Task
+	if !row.KeyID.Valid {
+		return &t, nil
+	}
+
+	if r.keyring == nil {
+		return nil, ErrNoKeyring
+	}
+
+	text, err := r.keyring.open(rowContext("tasks", row.ID), row.KeyID.String, row.DataKey, row.Text)
+	if err != nil {
+		return nil, err
+	}
+	t.Text = text
+
+	return &t, nil
+}
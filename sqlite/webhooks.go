@@ -0,0 +1,319 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"example.com/tasks"
+)
+
+// maxLoggedDeliveries is the number of delivery attempts kept in the log of
+// each webhook. Older attempts are pruned as new ones are logged.
+const maxLoggedDeliveries = 100
+
+// webhookRow is a webhook as it is stored in the webhooks table. When KeyID is
+// set, Secret holds ciphertext like the text of a taskRow.
+type webhookRow struct {
+	ID        string         `db:"id"`
+	URL       string         `db:"url"`
+	Secret    string         `db:"secret"`
+	Events    string         `db:"events"`
+	Disabled  bool           `db:"disabled"`
+	Failures  int            `db:"failures"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+	KeyID     sql.NullString `db:"key_id"`
+	DataKey   []byte         `db:"data_key"`
+}
+
+type deliveryRow struct {
+	Seq        int64     `db:"seq"`
+	ID         string    `db:"id"`
+	WebhookID  string    `db:"webhook_id"`
+	EventID    int64     `db:"event_id"`
+	EventType  string    `db:"event_type"`
+	Attempt    int       `db:"attempt"`
+	StatusCode int       `db:"status_code"`
+	Error      string    `db:"error"`
+	Duration   int64     `db:"duration"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// CreateWebhook stores a new webhook, setting its ID and timestamps.
+func (r *Repository) CreateWebhook(w *tasks.Webhook) error {
+	w.ID = r.ids.NewID()
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = w.CreatedAt
+	w.Failures = 0
+
+	row, err := r.sealWebhook(w)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	if _, err := r.writer().Exec(
+		"INSERT INTO webhooks (id, url, secret, events, disabled, failures, created_at, updated_at, key_id, data_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		row.ID, row.URL, row.Secret, row.Events, row.Disabled, row.Failures, row.CreatedAt, row.UpdatedAt, row.KeyID, row.DataKey,
+	); err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return nil
+}
+
+// ListWebhooks returns every webhook, oldest first.
+func (r *Repository) ListWebhooks() ([]*tasks.Webhook, error) {
+	var rows []*webhookRow
+	if err := r.readers().Select(&rows, "SELECT * FROM webhooks ORDER BY created_at, id;"); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+
+	ws := make([]*tasks.Webhook, len(rows))
+	for i, row := range rows {
+		w, err := r.openWebhook(row)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list webhooks: %w", err)
+		}
+		ws[i] = w
+	}
+
+	return ws, nil
+}
+
+// RetrieveWebhook returns the webhook with id.
+func (r *Repository) RetrieveWebhook(id string) (*tasks.Webhook, error) {
+	w, err := r.retrieveWebhook(r.readers(), id)
+	if err == tasks.ErrWebhookNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve webhook: %w", err)
+	}
+
+	return w, nil
+}
+
+func (r *Repository) retrieveWebhook(q queryer, id string) (*tasks.Webhook, error) {
+	row := &webhookRow{}
+	if err := q.Get(row, "SELECT * FROM webhooks WHERE id=?;", id); err == sql.ErrNoRows {
+		return nil, tasks.ErrWebhookNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return r.openWebhook(row)
+}
+
+// UpdateWebhook replaces the URL, Secret, Events and Disabled of the webhook
+// with w.ID. Failures is reset when the webhook is enabled. w is set to the
+// stored webhook.
+func (r *Repository) UpdateWebhook(w *tasks.Webhook) error {
+	err := r.transact(func(tx *sqlx.Tx) error {
+		existing, err := r.retrieveWebhook(tx, w.ID)
+		if err != nil {
+			return err
+		}
+
+		w.CreatedAt = existing.CreatedAt
+		w.UpdatedAt = time.Now().UTC()
+		w.Failures = existing.Failures
+		if !w.Disabled {
+			w.Failures = 0
+		}
+
+		row, err := r.sealWebhook(w)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"UPDATE webhooks SET url=?, secret=?, events=?, disabled=?, failures=?, updated_at=?, key_id=?, data_key=? WHERE id=?;",
+			row.URL, row.Secret, row.Events, row.Disabled, row.Failures, row.UpdatedAt, row.KeyID, row.DataKey, row.ID,
+		)
+		return err
+	})
+	if err == tasks.ErrWebhookNotFound {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes the webhook with id, along with its delivery log.
+func (r *Repository) DeleteWebhook(id string) error {
+	err := r.transact(func(tx *sqlx.Tx) error {
+		// The log is deleted explicitly, as foreign keys may not be enforced.
+		if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id=?;", id); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec("DELETE FROM webhooks WHERE id=?;", id)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return tasks.ErrWebhookNotFound
+		}
+
+		return nil
+	})
+	if err == tasks.ErrWebhookNotFound {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	return nil
+}
+
+// RecordWebhookResult counts a delivery to a webhook which succeeded or
+// failed, disabling the webhook once maxFailures deliveries in a row have
+// failed. The updated webhook is returned.
+func (r *Repository) RecordWebhookResult(id string, delivered bool, maxFailures int) (*tasks.Webhook, error) {
+	var w *tasks.Webhook
+
+	err := r.transact(func(tx *sqlx.Tx) error {
+		var err error
+		if delivered {
+			_, err = tx.Exec("UPDATE webhooks SET failures=0 WHERE id=?;", id)
+		} else {
+			_, err = tx.Exec(
+				"UPDATE webhooks SET failures=failures+1, disabled=(disabled OR failures+1>=?) WHERE id=?;",
+				maxFailures, id,
+			)
+		}
+		if err != nil {
+			return err
+		}
+
+		w, err = r.retrieveWebhook(tx, id)
+		return err
+	})
+	if err == tasks.ErrWebhookNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to record webhook result: %w", err)
+	}
+
+	return w, nil
+}
+
+// LogWebhookDelivery adds an attempt to the log of a webhook's deliveries.
+func (r *Repository) LogWebhookDelivery(d *tasks.WebhookDelivery) error {
+	err := r.transact(func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(
+			"INSERT INTO webhook_deliveries (id, webhook_id, event_id, event_type, attempt, status_code, error, duration, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);",
+			d.ID, d.WebhookID, int64(d.EventID), d.EventType, d.Attempt, d.StatusCode, d.Error, int64(d.Duration), d.CreatedAt.UTC(),
+		); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(
+			"DELETE FROM webhook_deliveries WHERE webhook_id=? AND seq <= (SELECT seq FROM webhook_deliveries WHERE webhook_id=? ORDER BY seq DESC LIMIT 1 OFFSET ?);",
+			d.WebhookID, d.WebhookID, maxLoggedDeliveries,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries returns up to limit of the most recent attempts
+// logged for a webhook, newest first.
+func (r *Repository) ListWebhookDeliveries(webhookID string, limit int) ([]*tasks.WebhookDelivery, error) {
+	var rows []*deliveryRow
+	if err := r.readers().Select(&rows,
+		"SELECT * FROM webhook_deliveries WHERE webhook_id=? ORDER BY seq DESC LIMIT ?;",
+		webhookID, limit,
+	); err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+
+	ds := make([]*tasks.WebhookDelivery, len(rows))
+	for i, row := range rows {
+		ds[i] = &tasks.WebhookDelivery{
+			ID:         row.ID,
+			WebhookID:  row.WebhookID,
+			EventID:    uint64(row.EventID),
+			EventType:  row.EventType,
+			Attempt:    row.Attempt,
+			StatusCode: row.StatusCode,
+			Error:      row.Error,
+			Duration:   time.Duration(row.Duration),
+			CreatedAt:  row.CreatedAt,
+		}
+	}
+
+	return ds, nil
+}
+
+// sealWebhook converts a webhook to a row, encrypting the secret if the
+// repository has a keyring.
+func (r *Repository) sealWebhook(w *tasks.Webhook) (*webhookRow, error) {
+	row := &webhookRow{
+		ID:        w.ID,
+		URL:       w.URL,
+		Secret:    w.Secret,
+		Events:    strings.Join(w.Events, ","),
+		Disabled:  w.Disabled,
+		Failures:  w.Failures,
+		CreatedAt: w.CreatedAt,
+		UpdatedAt: w.UpdatedAt,
+	}
+	if r.keyring == nil {
+		return row, nil
+	}
+
+	keyID, dataKey, ciphertext, err := r.keyring.seal(rowContext("webhooks", w.ID), w.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	row.Secret = ciphertext
+	row.KeyID = sql.NullString{String: keyID, Valid: true}
+	row.DataKey = dataKey
+
+	return row, nil
+}
+
+// openWebhook converts a row back to a webhook, decrypting the secret if
+// needed.
+func (r *Repository) openWebhook(row *webhookRow) (*tasks.Webhook, error) {
+	w := &tasks.Webhook{
+		ID:        row.ID,
+		URL:       row.URL,
+		Secret:    row.Secret,
+		Disabled:  row.Disabled,
+		Failures:  row.Failures,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+	if row.Events != "" {
+		w.Events = strings.Split(row.Events, ",")
+	}
+
+	if row.KeyID.Valid {
+		if r.keyring == nil {
+			return nil, ErrNoKeyring
+		}
+
+		secret, err := r.keyring.open(rowContext("webhooks", row.ID), row.KeyID.String, row.DataKey, row.Secret)
+		if err != nil {
+			return nil, err
+		}
+		w.Secret = secret
+	}
+
+	return w, nil
+}
@@ -1,18 +1,51 @@
 package tasks
 
 import (
-	"errors"
+	"fmt"
+	"strings"
 	"time"
-
-	"github.com/gofrs/uuid"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
 	// ErrTaskNotFound is returned by repositories when a task is not found in
 	// the respository.
-	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskNotFound = NewError(KindNotFound, "task not found")
+
+	// ErrTaskExists is returned by repositories when a task is imported with
+	// the ID of a task which already exists.
+	ErrTaskExists = NewError(KindConflict, "task already exists")
+
+	// ErrBatchAborted is returned by batch operations in BatchAtomic mode when
+	// one or more items failed and the batch was rolled back.
+	ErrBatchAborted = NewError(KindConflict, "batch aborted")
+)
+
+// MaxTextLength is the longest text a task or its list may have, in
+// characters.
+const MaxTextLength = 1000
+
+// BatchMode controls how batch operations handle items which fail.
+type BatchMode int
+
+const (
+	// BatchAtomic applies every item in a batch or, if any item fails, none of
+	// them.
+	BatchAtomic BatchMode = iota
+
+	// BatchBestEffort applies every item in a batch which succeeds and skips
+	// the items which fail.
+	BatchBestEffort
 )
 
+// TaskPatch is a patch to the task with ID, applied as by
+// TaskRepository.PatchTask.
+type TaskPatch struct {
+	ID    string
+	Patch func(t *Task) error
+}
+
 // Task is the domain task implementation.
 type Task struct {
 	ID         string    `db:"id"`
@@ -20,19 +53,124 @@ type Task struct {
 	UpdatedAt  time.Time `db:"updated_at"`
 	Text       string    `db:"text"`
 	IsComplete bool      `db:"is_complete"`
+
+	// DueAt is when the task is due, or nil if it has no due date.
+	DueAt *time.Time `db:"due_at"`
+
+	// Priority ranks the task from "A", the highest, to "Z", or is empty if
+	// the task has no priority.
+	Priority string `db:"priority"`
+
+	// CompletedAt is when the task was completed. It is nil if the task is
+	// not complete, or if when it was completed is not known.
+	CompletedAt *time.Time `db:"completed_at"`
+
+	// List names the list the task is on, or is empty if it is on none.
+	List string `db:"list"`
+
+	// ParentID is the ID of the task this is a subtask of, or is empty if it
+	// is not a subtask. The parent may have been deleted since.
+	ParentID string `db:"parent_id"`
+}
+
+// Changed reports whether any of the fields of t which may be changed by
+// TaskRepository.PatchTask differ from those of before.
+func (t *Task) Changed(before *Task) bool {
+	if t.Text != before.Text || t.IsComplete != before.IsComplete || t.List != before.List || t.ParentID != before.ParentID {
+		return true
+	}
+
+	if t.DueAt == nil || before.DueAt == nil {
+		return t.DueAt != before.DueAt
+	}
+	return !t.DueAt.Equal(*before.DueAt)
+}
+
+// ValidPriority reports whether p is a priority a task may have: a single
+// uppercase letter.
+func ValidPriority(p string) bool {
+	return len(p) == 1 && p[0] >= 'A' && p[0] <= 'Z'
+}
+
+// ValidateText checks text found at field, such as the text or list of a
+// task, against the rules shared by every way of storing tasks. Text which is
+// required must not be blank. It returns nil if the text is valid.
+func ValidateText(field, text string, required bool) *FieldError {
+	switch {
+	case required && strings.TrimSpace(text) == "":
+		return &FieldError{Field: field, Code: "required", Message: "is required"}
+	case utf8.RuneCountInString(text) > MaxTextLength:
+		return &FieldError{
+			Field:   field,
+			Code:    "max_length",
+			Message: fmt.Sprintf("must be at most %d characters", MaxTextLength),
+		}
+	}
+
+	for _, c := range text {
+		// Tabs are harmless, but anything else, newlines included, would
+		// break line based clients and exports.
+		if unicode.IsControl(c) && c != '\t' {
+			return &FieldError{Field: field, Code: "control_character", Message: "must not contain control characters"}
+		}
+	}
+
+	return nil
 }
 
 // TaskRepository defines the interface which repositories must implement in
 // order to be used by the application.
 type TaskRepository interface {
 	CreateTask(t *Task) error
-	ListTasks() ([]*Task, error)
+	ListTasks(opts ListOptions) (*TaskPage, error)
 	RetrieveTask(id string) (*Task, error)
-	UpdateTask(id string, t *Task) (*Task, error)
-	DeleteTask(id string) error
+
+	// PatchTask applies patch to the current version of a task, by id, and
+	// stores the result, atomically. If patch returns an error nothing is
+	// stored and the error is returned. Only Text, IsComplete, DueAt, List
+	// and ParentID may be changed by patch, and UpdatedAt is set if any of
+	// them is.
+	// CompletedAt is set when the task is completed and cleared when it is
+	// reopened.
+	PatchTask(id string, patch func(t *Task) error) (*Task, error)
+
+	// UpsertTask creates a task with the ID t.ID if none exists, or replaces
+	// the Text, IsComplete, DueAt, List and ParentID of the existing one,
+	// preserving its CreatedAt. t is set to the stored task, and whether it
+	// was created is returned.
+	UpsertTask(t *Task) (created bool, err error)
+
+	// DeleteTask deletes a task by id and reports whether it existed.
+	// Deleting a task which does not exist is not an error.
+	DeleteTask(id string) (deleted bool, err error)
+
+	// Batch operations apply many items in a single transaction. The returned
+	// slice of errors holds the result of each item at the same index, with a
+	// nil error for items which succeeded. If the batch is rolled back because
+	// of failing items, ErrBatchAborted is also returned.
+	CreateTasks(ts []*Task, mode BatchMode) ([]error, error)
+
+	// DeleteTasks deletes tasks as DeleteTask does, reporting whether each
+	// existed at the index of its id.
+	DeleteTasks(ids []string, mode BatchMode) ([]bool, []error, error)
+
+	// PatchTasks applies each patch as PatchTask does, returning the patched
+	// tasks at the index of their patch, or nil where a patch failed.
+	PatchTasks(ps []*TaskPatch, mode BatchMode) ([]*Task, []error, error)
+
+	// ImportTasks creates many tasks in a single transaction, as CreateTasks
+	// does, but keeps the ID, times, completion, due date, priority, list
+	// and parent each task is given. An ID or time which is not set is
+	// generated as for a new task.
+	// Tasks whose ID is taken fail with ErrTaskExists.
+	ImportTasks(ts []*Task, mode BatchMode) ([]error, error)
 }
 
-// NewTaskID creates a new task ID.
-func NewTaskID() string {
-	return uuid.Must(uuid.NewV4()).String()
+// Transactor is implemented by repositories which can apply several
+// operations in a single transaction.
+type Transactor interface {
+	// WithTx runs fn with a repository whose operations all take place in
+	// one transaction, which is committed if fn returns nil and rolled back
+	// otherwise.
+	WithTx(fn func(repo TaskRepository) error) error
 }
@@ -0,0 +1,21 @@
+package tasks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestValidateText(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(ValidateText("text", "buy milk", true), (*FieldError)(nil))                          // Text is valid
+	is.Equal(ValidateText("text", " \t", true).Code, "required")                                  // Required text must not be blank
+	is.Equal(ValidateText("list", "", false), (*FieldError)(nil))                                 // Optional text may be empty
+	is.Equal(ValidateText("text", strings.Repeat("é", MaxTextLength), true), (*FieldError)(nil))  // Length is counted in characters
+	is.Equal(ValidateText("text", strings.Repeat("x", MaxTextLength+1), true).Code, "max_length") // Text must not be too long
+	is.Equal(ValidateText("text", "two\nlines", true).Code, "control_character")                  // Newlines are refused
+	is.Equal(ValidateText("text", "tab\tseparated", true), (*FieldError)(nil))                    // Tabs are allowed
+	is.Equal(ValidateText("items[0].text", "", true).Field, "items[0].text")                      // The field is reported
+}
@@ -0,0 +1,40 @@
+package taskhttp
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// backupResponse describes a backup by its name in the backup directory.
+// Where the directory is on the server is not disclosed.
+type backupResponse struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *Handler) adminBackupsCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := middleware.GetReqID(r.Context())
+
+		location, err := h.backups.Backup(r.Context())
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to create backup: %w", err))
+			return
+		}
+
+		h.logger.Info("created backup",
+			zap.String("request_id", requestID),
+			zap.String("location", location),
+		)
+
+		respond(w, r, http.StatusCreated, &backupResponse{
+			Name:      filepath.Base(location),
+			CreatedAt: time.Now().UTC(),
+		})
+	}
+}
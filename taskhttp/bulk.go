@@ -0,0 +1,126 @@
+package taskhttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+// maxBulkItems is the largest number of items accepted by a bulk request.
+const maxBulkItems = 1000
+
+// tooManyItems creates the error reported for a bulk request with more than
+// maxBulkItems items in field.
+func tooManyItems(field string) error {
+	return tasks.Invalid(&tasks.FieldError{
+		Field:   field,
+		Code:    "max_items",
+		Message: fmt.Sprintf("at most %d items may be sent at once", maxBulkItems),
+	})
+}
+
+var batchModes = map[string]tasks.BatchMode{
+	"atomic":      tasks.BatchAtomic,
+	"best-effort": tasks.BatchBestEffort,
+}
+
+// bulkMode reads the batch mode from the mode query parameter, defaulting to
+// atomic.
+func bulkMode(r *http.Request) (string, tasks.BatchMode, error) {
+	name := r.URL.Query().Get("mode")
+	if name == "" {
+		name = "atomic"
+	}
+
+	mode, ok := batchModes[name]
+	if !ok {
+		return "", 0, malformedQuery([]*tasks.FieldError{{
+			Field:   "mode",
+			Code:    "enum",
+			Message: fmt.Sprintf("must be one of atomic or best-effort, got %q", name),
+		}})
+	}
+
+	return name, mode, nil
+}
+
+type bulkItem struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Task   interface{} `json:"task,omitempty" openapi:"Task"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Mode      string      `json:"mode"`
+	Succeeded int         `json:"succeeded"`
+	Failed    int         `json:"failed"`
+	Items     []*bulkItem `json:"items"`
+}
+
+// respondBulk responds with the result of each item of a batch. Items which
+// succeeded are given okStatus and, if task is not nil, the task returned by
+// it. If the batch was aborted, items which would have succeeded are reported
+// as not applied.
+func (h *Handler) respondBulk(w http.ResponseWriter, r *http.Request, mode string, okStatus int, errs []error, batchErr error, task func(i int) interface{}) {
+	aborted := batchErr == tasks.ErrBatchAborted
+	res := &bulkResponse{
+		Mode:  mode,
+		Items: make([]*bulkItem, len(errs)),
+	}
+
+	for i, err := range errs {
+		item := &bulkItem{Index: i}
+		res.Items[i] = item
+
+		switch {
+		case err == nil && aborted:
+			item.Status = http.StatusFailedDependency
+			item.Error = "not applied because another item failed"
+		case err == nil:
+			item.Status = okStatus
+			if task != nil {
+				item.Task = task(i)
+			}
+		case tasks.KindOf(err) != tasks.KindInternal:
+			var e *tasks.Error
+			errors.As(err, &e)
+			item.Status = problemStatus(e.Kind)
+			item.Error = e.Message
+		default:
+			h.logger.Error("bulk item failed",
+				zap.String("request_id", middleware.GetReqID(r.Context())),
+				zap.Int("index", i),
+				zap.Error(err),
+			)
+			item.Status = http.StatusInternalServerError
+			item.Error = "internal server error"
+		}
+
+		if item.Status == okStatus {
+			res.Succeeded++
+		} else {
+			res.Failed++
+		}
+	}
+
+	code := okStatus
+	if code == http.StatusNoContent {
+		// The response still has a body describing each item.
+		code = http.StatusOK
+	}
+
+	switch {
+	case aborted:
+		code = http.StatusConflict
+	case res.Failed > 0:
+		code = http.StatusMultiStatus
+	}
+
+	respond(w, r, code, res)
+}
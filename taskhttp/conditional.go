@@ -0,0 +1,80 @@
+package taskhttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// cacheControl lets clients, but not shared caches, store responses as long
+// as they revalidate them before every use. Revalidating is cheap, as
+// unchanged responses are answered with 304 Not Modified.
+const cacheControl = "private, no-cache"
+
+// respondConditional responds with data like respond, but validated by a
+// strong ETag of the response body and, if it is not zero, a Last-Modified
+// time. If the request's preconditions show the client already has the
+// response, 304 Not Modified is sent without a body instead.
+func respondConditional(w http.ResponseWriter, r *http.Request, code int, data interface{}, modified time.Time) {
+	enc, out, err := encodeResponse(r, data)
+	if err != nil {
+		respondProblem(w, r, err)
+		return
+	}
+
+	// Each representation has its own ETag, as the tag is derived from the
+	// encoded body.
+	sum := sha256.Sum256(out)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Content-Type", enc.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheControl)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(code)
+	w.Write(out)
+}
+
+// notModified evaluates the If-None-Match and If-Modified-Since preconditions
+// of a GET or HEAD request as described in RFC 7232 section 6.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			// If-None-Match uses the weak comparison function.
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+
+		// If-Modified-Since is ignored when If-None-Match is present.
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		// HTTP dates only have a resolution of seconds.
+		return !modified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
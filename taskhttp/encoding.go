@@ -0,0 +1,460 @@
+package taskhttp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"example.com/tasks"
+	"example.com/tasks/markdown"
+)
+
+// encoder encodes response bodies in one media type.
+type encoder struct {
+	// format names the encoder in the format query parameter.
+	format string
+
+	// contentType is sent as the Content-Type of the responses it encodes.
+	contentType string
+
+	// mediaTypes are the media types which select the encoder when they are
+	// accepted.
+	mediaTypes []string
+
+	// shape is the shape a response must have to be encoded by the
+	// encoder.
+	shape shape
+
+	encode func(v interface{}) ([]byte, error)
+}
+
+// encoders are the encoders responses are negotiated between. When a client
+// accepts several of them equally, the first is preferred.
+var encoders = []*encoder{
+	{
+		format:      "json",
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode:      json.Marshal,
+	},
+	{
+		format:      "yaml",
+		contentType: "application/yaml",
+		mediaTypes:  []string{"application/yaml", "application/x-yaml", "text/yaml"},
+		encode:      encodeYAML,
+	},
+	{
+		format:      "msgpack",
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:      encodeMsgpack,
+	},
+	{
+		format:      "csv",
+		contentType: "text/csv; charset=utf-8; header=present",
+		mediaTypes:  []string{"text/csv"},
+		shape:       shapeList,
+		encode:      encodeCSV,
+	},
+	{
+		format:      "markdown",
+		contentType: markdownType + "; charset=utf-8",
+		mediaTypes:  []string{markdownType},
+		shape:       shapeTaskList,
+		encode:      encodeMarkdown,
+	},
+}
+
+// shape is the shape of a response, which limits the encoders it may be
+// encoded by. Each shape is also of the shapes before it.
+type shape int
+
+const (
+	shapeAny shape = iota
+	shapeList
+	shapeTaskList
+)
+
+func (s shape) String() string {
+	switch s {
+	case shapeList:
+		return "lists"
+	case shapeTaskList:
+		return "lists of tasks"
+	default:
+		return "anything"
+	}
+}
+
+// shapeOf returns the shape of the response data.
+func shapeOf(data interface{}) shape {
+	switch data.(type) {
+	case *taskListResponse:
+		return shapeTaskList
+	case lister:
+		return shapeList
+	default:
+		return shapeAny
+	}
+}
+
+// lister is implemented by list responses, whose items may also be encoded as
+// the rows of a table.
+type lister interface {
+	listItems() interface{}
+
+	// listColumns names the members of the items which are written as the
+	// columns of a table, in order.
+	listColumns() []string
+}
+
+func (res *taskListResponse) listItems() interface{}     { return res.Items }
+func (res *webhookListResponse) listItems() interface{}  { return res.Items }
+func (res *deliveryListResponse) listItems() interface{} { return res.Items }
+
+func (res *taskListResponse) listColumns() []string {
+	if res.columns == nil {
+		return jsonColumns(reflect.TypeOf(taskResource{}))
+	}
+	return res.columns
+}
+
+func (res *webhookListResponse) listColumns() []string {
+	return jsonColumns(reflect.TypeOf(webhookResource{}))
+}
+
+func (res *deliveryListResponse) listColumns() []string {
+	return jsonColumns(reflect.TypeOf(deliveryResource{}))
+}
+
+// respond encodes data in the representation negotiated for r and sends it
+// with code.
+func respond(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
+	enc, out, err := encodeResponse(r, data)
+	if err != nil {
+		respondProblem(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", enc.contentType)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(code)
+	w.Write(out)
+}
+
+// encodeResponse encodes data with the encoder negotiated for r.
+func encodeResponse(r *http.Request, data interface{}) (*encoder, []byte, error) {
+	enc, err := negotiate(r, shapeOf(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := enc.encode(data)
+	if err != nil {
+		panic(enc.format + " marshal error on response, this is a bug")
+	}
+	return enc, out, nil
+}
+
+// acceptable rejects requests for which no response could be encoded before
+// they are handled, so that nothing is changed by requests whose response
+// would be refused. Only GET responses may be lists, which are taken to be
+// of tasks until the response shows otherwise.
+func acceptable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := shapeAny
+		if r.Method == http.MethodGet {
+			s = shapeTaskList
+		}
+		if _, err := negotiate(r, s); err != nil {
+			respondProblem(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// negotiate chooses the encoder for the response to r. The format query
+// parameter takes precedence over the Accept header. s is the shape of the
+// response.
+func negotiate(r *http.Request, s shape) (*encoder, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, enc := range encoders {
+			if enc.format != format {
+				continue
+			}
+			if enc.shape > s {
+				return nil, notAcceptable(s, "%s can only be sent for %s", format, enc.shape)
+			}
+			return enc, nil
+		}
+
+		return nil, malformedQuery([]*tasks.FieldError{{
+			Field:   "format",
+			Code:    "enum",
+			Message: fmt.Sprintf("must be one of %s, got %q", strings.Join(formats(), ", "), format),
+		}})
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return encoders[0], nil
+	}
+	ranges := parseAccept(accept)
+
+	var best *encoder
+	bestQ, bestPos := 0.0, 0
+	for _, enc := range encoders {
+		if enc.shape > s {
+			continue
+		}
+		for _, mt := range enc.mediaTypes {
+			q, pos := quality(ranges, mt)
+			if q > bestQ || (q == bestQ && q > 0 && pos < bestPos) {
+				best, bestQ, bestPos = enc, q, pos
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, notAcceptable(s, "cannot send any of %s", accept)
+	}
+	return best, nil
+}
+
+// notAcceptable creates the error reported when no encoder is acceptable,
+// listing the media types which could have been sent.
+func notAcceptable(s shape, format string, args ...interface{}) error {
+	var available []string
+	for _, enc := range encoders {
+		if enc.shape <= s {
+			available = append(available, enc.mediaTypes[0])
+		}
+	}
+
+	return tasks.NewError(tasks.KindNotAcceptable,
+		fmt.Sprintf(format, args...)+"; available are "+strings.Join(available, ", "))
+}
+
+// formats returns the names of every encoder.
+func formats() []string {
+	names := make([]string, len(encoders))
+	for i, enc := range encoders {
+		names[i] = enc.format
+	}
+	return names
+}
+
+// mediaRange is one member of an Accept header.
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses an Accept header as described in RFC 7231 section
+// 5.3.2. Members which cannot be parsed are ignored.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, member := range strings.Split(header, ",") {
+		params := strings.Split(member, ";")
+		parts := strings.SplitN(strings.ToLower(strings.TrimSpace(params[0])), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+
+		mr := mediaRange{typ: parts[0], subtype: parts[1], q: 1}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q >= 0 && q <= 1 {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the quality the most specific of ranges which matches
+// mediaType gives it, and that range's position, or zero if none match.
+func quality(ranges []mediaRange, mediaType string) (float64, int) {
+	parts := strings.SplitN(mediaType, "/", 2)
+
+	q, pos, specificity := 0.0, 0, 0
+	for i, mr := range ranges {
+		s := 0
+		switch {
+		case mr.typ == parts[0] && mr.subtype == parts[1]:
+			s = 3
+		case mr.typ == parts[0] && mr.subtype == "*":
+			s = 2
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 1
+		}
+		if s > specificity {
+			q, pos, specificity = mr.q, i, s
+		}
+	}
+	return q, pos
+}
+
+// generic converts v to the maps, slices, strings, numbers, booleans and nils
+// it is encoded as in JSON, so that other encodings follow the names and
+// omissions of its JSON encoding. Whole numbers are kept as int64 or uint64.
+func generic(v interface{}) (interface{}, error) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+
+	var g interface{}
+	if err := dec.Decode(&g); err != nil {
+		return nil, err
+	}
+	return numbers(g), nil
+}
+
+// numbers replaces the json.Numbers within v with the number they hold.
+func numbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = numbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = numbers(v[k])
+		}
+	}
+	return v
+}
+
+func encodeYAML(v interface{}) ([]byte, error) {
+	g, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(g)
+}
+
+// encodeCSV encodes the items of a list response as a table, with a header
+// row naming the columns. The columns are the same for every page of a list,
+// even an empty one, whichever members the items happen to have; they are the
+// members of the list's resources, except their links. Strings are written as
+// is, nulls and missing members as empty cells and anything else as JSON.
+func encodeCSV(v interface{}) ([]byte, error) {
+	l, ok := v.(lister)
+	if !ok {
+		return nil, fmt.Errorf("failed to encode csv: %T is not a list", v)
+	}
+
+	items, err := json.Marshal(l.listItems())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode csv: %w", err)
+	}
+
+	rows, err := decodeRows(items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode csv: %w", err)
+	}
+
+	// Links cannot be followed from a table, so they are left out.
+	var columns []string
+	for _, column := range l.listColumns() {
+		if column != "_links" {
+			columns = append(columns, column)
+		}
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = cell(row[column])
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+
+	return buf.Bytes(), cw.Error()
+}
+
+// decodeRows decodes a JSON array of objects.
+func decodeRows(data []byte) ([]map[string]json.RawMessage, error) {
+	var rows []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// cell formats a JSON value as a CSV cell. Strings which spreadsheets would
+// take for formulas are prefixed with a quote, so that opening an export
+// cannot run one a client wrote into a task.
+func cell(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return string(raw)
+	}
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// encodeMarkdown encodes the tasks of a task list response as a Markdown task
+// list. Only the members of the tasks which are sent are written, so a
+// projection may leave tasks without text or lists.
+func encodeMarkdown(v interface{}) ([]byte, error) {
+	res, ok := v.(*taskListResponse)
+	if !ok {
+		return nil, fmt.Errorf("failed to encode markdown: %T is not a list of tasks", v)
+	}
+
+	items, err := json.Marshal(res.Items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode markdown: %w", err)
+	}
+	var resources []*taskResource
+	if err := json.Unmarshal(items, &resources); err != nil {
+		return nil, fmt.Errorf("failed to encode markdown: %w", err)
+	}
+
+	ts := make([]*tasks.Task, len(resources))
+	for i, tr := range resources {
+		ts[i] = &tasks.Task{ID: tr.ID, Text: tr.Text, IsComplete: tr.IsComplete, List: tr.List, ParentID: tr.ParentID}
+	}
+
+	var buf bytes.Buffer
+	if err := markdown.Write(&buf, ts); err != nil {
+		return nil, fmt.Errorf("failed to encode markdown: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,216 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/events"
+)
+
+// defaultHeartbeat is how often a comment is sent on an idle event stream,
+// so that proxies and clients do not close it.
+const defaultHeartbeat = 15 * time.Second
+
+// eventParams are the query parameters understood by eventsStream.
+var eventParams = map[string]bool{
+	"types":         true,
+	"is_complete":   true,
+	"text_contains": true,
+	"list":          true,
+	"parent_id":     true,
+	"last_event_id": true,
+}
+
+// eventFilter selects the events sent on a stream.
+type eventFilter struct {
+	types  map[events.Type]bool
+	filter tasks.Filter
+}
+
+// match reports whether e should be sent. Deleted events only carry the ID
+// of the task, so they are matched by type alone.
+func (f *eventFilter) match(e events.Event) bool {
+	if len(f.types) > 0 && !f.types[e.Type] {
+		return false
+	}
+	return e.Type == events.Deleted || f.filter.Match(e.Task)
+}
+
+func (h *Handler) eventsStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, epoch, lastID, errs := eventOptions(r)
+		if len(errs) > 0 {
+			h.respondError(w, r, malformedQuery(errs))
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			h.respondError(w, r, fmt.Errorf("failed to stream events: response writer cannot flush"))
+			return
+		}
+
+		sub := h.events.Subscribe(epoch, lastID)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if !sub.Resumed() {
+			// Some events the client missed are gone, so it has to fetch the
+			// tasks again to catch up. The ID lets it resume from here.
+			fmt.Fprintf(w, "id: %s\nevent: reset\ndata: {}\n\n", EventID(h.events.Epoch(), sub.Start()))
+		}
+		for _, e := range sub.Backlog() {
+			if f.match(e) {
+				writeEvent(w, h.events.Epoch(), e)
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(h.heartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case e, ok := <-sub.Events():
+				if !ok {
+					// The client fell too far behind. It may reconnect and
+					// resume from the last event it received.
+					h.logger.Warn("event stream dropped",
+						zap.String("request_id", middleware.GetReqID(r.Context())),
+					)
+					return
+				}
+				if !f.match(e) {
+					continue
+				}
+				writeEvent(w, h.events.Epoch(), e)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// EventID formats the ID of the event with sequence number seq in a broker's
+// epoch, as the event stream and webhook deliveries give it. The epoch tells
+// apart the IDs of brokers which restarted numbering, such as before and
+// after a restart of the server.
+func EventID(epoch string, seq uint64) string {
+	return epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// EventData returns what the event stream and webhook deliveries carry for e:
+// the task's resource, without links, or only the ID of a deleted task.
+func EventData(e events.Event) interface{} {
+	if e.Type == events.Deleted {
+		return struct {
+			ID string `json:"id"`
+		}{e.Task.ID}
+	}
+	return newTaskResource(e.Task)
+}
+
+// writeEvent writes e, which was published in epoch, in the
+// text/event-stream format.
+func writeEvent(w http.ResponseWriter, epoch string, e events.Event) {
+	out, err := json.Marshal(EventData(e))
+	if err != nil {
+		panic("json marshal error on event, this is a bug")
+	}
+
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", EventID(epoch, e.ID), e.Type, out)
+}
+
+// eventOptions parses the filter, and the epoch and sequence number of the
+// last event received, from a request for the event stream.
+func eventOptions(r *http.Request) (*eventFilter, string, uint64, []*tasks.FieldError) {
+	q := r.URL.Query()
+	f := &eventFilter{types: make(map[events.Type]bool)}
+	var errs []*tasks.FieldError
+	reject := func(param, code, format string, args ...interface{}) {
+		errs = append(errs, &tasks.FieldError{
+			Field:   param,
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for name := range q {
+		if !eventParams[name] {
+			reject(name, "unknown", "unknown query parameter %q", name)
+		}
+	}
+
+	if s := q.Get("types"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			switch typ := events.Type(t); typ {
+			case events.Created, events.Updated, events.Deleted:
+				f.types[typ] = true
+			default:
+				reject("types", "enum", "types must be created, updated or deleted, got %q", t)
+			}
+		}
+	}
+
+	if s := q.Get("is_complete"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			reject("is_complete", "type", "is_complete must be a boolean, got %q", s)
+		}
+		f.filter.IsComplete = &b
+	}
+
+	f.filter.TextContains = q.Get("text_contains")
+
+	// As when listing tasks, an empty list or parent ID selects the tasks on
+	// no list or which are not subtasks.
+	if _, ok := q["list"]; ok {
+		list := q.Get("list")
+		f.filter.List = &list
+	}
+	if _, ok := q["parent_id"]; ok {
+		parentID := q.Get("parent_id")
+		if parentID != "" && !tasks.ValidTaskID(parentID) {
+			reject("parent_id", "format", "parent_id must be a task ID, got %q", parentID)
+		}
+		f.filter.ParentID = &parentID
+	}
+
+	// Reconnecting clients send the Last-Event-ID header, but clients may
+	// also give the ID in the query when first connecting. IDs without an
+	// epoch are treated as from another epoch, so the stream is reset.
+	var epoch string
+	var lastID uint64
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = q.Get("last_event_id")
+	}
+	if s != "" {
+		seq := s
+		if i := strings.LastIndex(s, "-"); i >= 0 {
+			epoch, seq = s[:i], s[i+1:]
+		}
+		id, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			reject("last_event_id", "format", "last event id must be an event id, got %q", s)
+		}
+		lastID = id
+	}
+
+	return f, epoch, lastID, errs
+}
@@ -1,12 +1,32 @@
 package taskhttp
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 
 	"example.com/tasks"
+	"example.com/tasks/events"
+)
+
+// Backuper creates backups of the underlying data store, returning a
+// description of where the backup was written.
+type Backuper interface {
+	Backup(ctx context.Context) (string, error)
+}
+
+var (
+	// rootDeprecatedAt is when serving the API from the root, rather than
+	// under a version prefix, was deprecated.
+	rootDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+	// DefaultRootSunset is when the API will stop being served from the root,
+	// unless another time is set with WithRootSunset.
+	DefaultRootSunset = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
 )
 
 // Handler is an HTTP handler for the tasks API.
@@ -14,18 +34,132 @@ type Handler struct {
 	router chi.Router
 	logger *zap.Logger
 	repo   tasks.TaskRepository
+
+	rootSunset time.Time
+
+	// trustedProxies are the addresses of the proxies whose X-Forwarded-*
+	// headers links follow.
+	trustedProxies []*net.IPNet
+
+	adminToken string
+	backups    Backuper
+	strict     bool
+
+	webhooks  tasks.WebhookStore
+	events    *events.Broker
+	heartbeat time.Duration
+
+	idempotency    tasks.IdempotencyStore
+	idempotencyTTL time.Duration
+
+	// expanders load the related resources which can be embedded in task
+	// representations with the expand query parameter, keyed by name.
+	expanders map[string]expander
+
+	// linkRoutes are the keys of the routes of the first version of the API,
+	// such as "GET /{id}", which resources may link to.
+	linkRoutes map[string]bool
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithRootSunset sets the time announced in the Sunset header of responses to
+// the deprecated routes at the root, after which only the versioned routes
+// will be served.
+func WithRootSunset(t time.Time) Option {
+	return func(h *Handler) {
+		h.rootSunset = t
+	}
+}
+
+// WithTrustedProxies makes links follow the X-Forwarded-Proto,
+// X-Forwarded-Host and X-Forwarded-Prefix headers of requests from proxies
+// with addresses in nets. The headers are ignored on other requests, so that
+// clients cannot have links made to hosts of their choosing.
+func WithTrustedProxies(nets ...*net.IPNet) Option {
+	return func(h *Handler) {
+		h.trustedProxies = nets
+	}
+}
+
+// WithAdminToken sets the bearer token required by the administrative
+// endpoints. Administrative endpoints are not served unless a token is set.
+func WithAdminToken(token string) Option {
+	return func(h *Handler) {
+		h.adminToken = token
+	}
+}
+
+// WithBackups enables the administrative endpoint for triggering backups.
+func WithBackups(b Backuper) Option {
+	return func(h *Handler) {
+		h.backups = b
+	}
+}
+
+// WithStrictDecoding rejects request bodies with fields the endpoint does not
+// know, rather than ignoring them.
+func WithStrictDecoding(strict bool) Option {
+	return func(h *Handler) {
+		h.strict = strict
+	}
+}
+
+// WithIdempotency stores the responses to POST requests made with an
+// Idempotency-Key header in store for ttl, so that retries are not repeated.
+func WithIdempotency(store tasks.IdempotencyStore, ttl time.Duration) Option {
+	return func(h *Handler) {
+		h.idempotency = store
+		h.idempotencyTTL = ttl
+	}
+}
+
+// WithWebhooks serves the endpoints for managing the webhooks in store and
+// their delivery logs. They are administrative endpoints, so they require
+// the admin token and are not served unless it is set.
+func WithWebhooks(store tasks.WebhookStore) Option {
+	return func(h *Handler) {
+		h.webhooks = store
+	}
+}
+
+// WithEvents serves the changes published to b as an event stream, sending a
+// heartbeat on idle streams every heartbeat, or every 15 seconds if it is
+// zero. Changes are only published if the repository is wrapped by
+// events.Publish.
+func WithEvents(b *events.Broker, heartbeat time.Duration) Option {
+	return func(h *Handler) {
+		h.events = b
+		h.heartbeat = heartbeat
+		if h.heartbeat <= 0 {
+			h.heartbeat = defaultHeartbeat
+		}
+	}
 }
 
 // New creates a new Handler
-func New(logger *zap.Logger, tr tasks.TaskRepository) *Handler {
+func New(logger *zap.Logger, tr tasks.TaskRepository, opts ...Option) *Handler {
 	h := &Handler{
-		router: chi.NewRouter(),
-		logger: logger,
-		repo:   tr,
+		router:     chi.NewRouter(),
+		logger:     logger,
+		repo:       tr,
+		rootSunset: DefaultRootSunset,
+		expanders:  make(map[string]expander),
+	}
+	h.expanders["subtasks"] = (*Handler).subtasks
+
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	h.routes()
 
+	h.linkRoutes = make(map[string]bool)
+	for _, key := range h.routeKeys() {
+		h.linkRoutes[key] = true
+	}
+
 	return h
 }
 
@@ -1,17 +1,27 @@
 package taskhttp
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/matryer/is"
 	"go.uber.org/zap"
 
 	"example.com/tasks"
+	"example.com/tasks/events"
 	"example.com/tasks/mock"
 )
 
@@ -152,3 +162,1405 @@ func TestTasksDelete(t *testing.T) {
 	is.Equal(rr.Code, http.StatusNoContent) // Status should equal 204
 	is.Equal(len(rr.Body.String()), 0)      // Non-empty response body
 }
+
+type backuperFunc func(ctx context.Context) (string, error)
+
+func (f backuperFunc) Backup(ctx context.Context) (string, error) { return f(ctx) }
+
+func TestAdminBackupsCreate(t *testing.T) {
+	is := is.New(t)
+
+	var called bool
+	h := New(zap.NewNop(), mock.New(),
+		WithAdminToken("secret"),
+		WithBackups(backuperFunc(func(ctx context.Context) (string, error) {
+			called = true
+			return "backups/tasks.db", nil
+		})),
+	)
+
+	req, err := http.NewRequest(http.MethodPost, "/admin/backups", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusUnauthorized) // Status should equal 401
+	is.True(!called)                           // Backup should not run without a token
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusCreated)                            // Status should equal 201
+	is.True(called)                                                  // Backup should run
+	is.True(strings.Contains(rr.Body.String(), `"name":"tasks.db"`)) // Body -> name
+	is.True(!strings.Contains(rr.Body.String(), "backups/"))         // Body -> not the path on the server
+}
+
+func TestTasksBulkCreate(t *testing.T) {
+	is := is.New(t)
+
+	data := bytes.NewBuffer([]byte(`{"items": [{"text": "one"}, {"text": "two"}]}`))
+
+	req, err := http.NewRequest(http.MethodPost, "/bulk", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := callWithNewHandler(t, req)
+	is.Equal(rr.Code, http.StatusCreated)                        // Status should equal 201
+	is.True(strings.Contains(rr.Body.String(), `"text":"one"`))  // Body -> first item created
+	is.True(strings.Contains(rr.Body.String(), `"text":"two"`))  // Body -> second item created
+	is.True(strings.Contains(rr.Body.String(), `"succeeded":2`)) // Body -> both succeeded
+}
+
+func TestTasksBulkUpdate(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	missing := tasks.NewTaskID()
+	existing := func() *tasks.Task {
+		return &tasks.Task{
+			ID:        id,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+			Text:      "changeme",
+		}
+	}
+	body := `{"items": [{"id": "` + id + `", "text": "testing"}, {"id": "` + missing + `", "text": "testing"}]}`
+
+	// In atomic mode, the missing task aborts the whole batch.
+	req, err := http.NewRequest(http.MethodPatch, "/bulk", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := existing()
+	rr := callWithNewHandler(t, req, task)
+	is.Equal(rr.Code, http.StatusConflict)                      // Status should equal 409
+	is.True(strings.Contains(rr.Body.String(), `"status":424`)) // Body -> existing task not applied
+	is.True(strings.Contains(rr.Body.String(), `"status":404`)) // Body -> missing task not found
+	is.Equal(task.Text, "changeme")                             // Task should not be updated
+
+	// In best-effort mode, the existing task is updated regardless.
+	req, err = http.NewRequest(http.MethodPatch, "/bulk?mode=best-effort", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task = existing()
+	rr = callWithNewHandler(t, req, task)
+	is.Equal(rr.Code, http.StatusMultiStatus)                   // Status should equal 207
+	is.True(strings.Contains(rr.Body.String(), `"status":200`)) // Body -> existing task updated
+	is.True(strings.Contains(rr.Body.String(), `"status":404`)) // Body -> missing task not found
+	is.Equal(task.Text, "testing")                              // Task should be updated
+
+	// Each item is a merge patch, so fields it leaves out are unchanged.
+	req, err = http.NewRequest(http.MethodPatch, "/bulk", strings.NewReader(`{"items": [{"id": "`+id+`", "text": "renamed"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task = existing()
+	task.IsComplete = true
+	rr = callWithNewHandler(t, req, task)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+	is.Equal(task.Text, "renamed")   // Task should be updated
+	is.True(task.IsComplete)         // Completion should be unchanged
+}
+
+func TestTasksBulkDelete(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	data := bytes.NewBuffer([]byte(`{"ids": ["` + id + `"]}`))
+
+	req, err := http.NewRequest(http.MethodDelete, "/bulk", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := callWithNewHandler(t, req)
+	is.Equal(rr.Code, http.StatusOK)                            // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"status":204`)) // Body -> item deleted
+}
+
+func TestMalformedTaskID(t *testing.T) {
+	is := is.New(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		req, err := http.NewRequest(method, "/not-a-task-id", strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := callWithNewHandler(t, req)
+		is.Equal(rr.Code, http.StatusBadRequest) // Status should equal 400
+	}
+}
+
+func TestTasksListPagination(t *testing.T) {
+	is := is.New(t)
+
+	created := time.Now().UTC()
+	var ts []*tasks.Task
+	for i := 0; i < 3; i++ {
+		ts = append(ts, &tasks.Task{
+			ID:        tasks.NewTaskID(),
+			CreatedAt: created.Add(time.Duration(i) * time.Second),
+			UpdatedAt: created,
+			Text:      "testing",
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/?limit=2&count=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := callWithNewHandler(t, req, ts...)
+	is.Equal(rr.Code, http.StatusOK)                                    // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"length":2`))           // Body -> length is the page size
+	is.True(strings.Contains(rr.Body.String(), `"total":3`))            // Body -> total is the count
+	is.True(strings.Contains(rr.Body.String(), `"next_cursor":"`))      // Body -> has a next cursor
+	is.True(!strings.Contains(rr.Body.String(), `"prev_cursor":"`))     // Body -> has no previous cursor
+	is.True(strings.Contains(rr.Header().Get("Link"), `rel="next"`))    // Link -> next page
+	is.True(!strings.Contains(rr.Body.String(), `"id":"`+ts[2].ID+`"`)) // Body -> last task is on the next page
+
+	req, err = http.NewRequest(http.MethodGet, "/?cursor=garbage", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callWithNewHandler(t, req, ts...)
+	is.Equal(rr.Code, http.StatusBadRequest) // Status should equal 400
+}
+
+func TestTasksListFilter(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now().UTC()
+	done := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now, Text: "send invoice", IsComplete: true}
+	old := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now.Add(-48 * time.Hour), Text: "send invoice"}
+	match := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now, Text: "send Invoice"}
+	other := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now, Text: "walk the dog"}
+
+	q := url.Values{}
+	q.Set("is_complete", "false")
+	q.Set("updated_after", now.Add(-24*time.Hour).Format(time.RFC3339))
+	q.Set("text_contains", "invoice")
+	q.Set("sort", "-updated_at,created_at")
+
+	req, err := http.NewRequest(http.MethodGet, "/?"+q.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := callWithNewHandler(t, req, done, old, match, other)
+	is.Equal(rr.Code, http.StatusOK)                                   // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"id":"`+match.ID+`"`)) // Body -> matching task
+	is.True(strings.Contains(rr.Body.String(), `"length":1`))          // Body -> only the matching task
+
+	// The + of an offset which is not escaped in the query decodes to a
+	// space.
+	after := now.Add(-24 * time.Hour).In(time.FixedZone("", 2*60*60)).Format(time.RFC3339)
+	req, err = http.NewRequest(http.MethodGet, "/?is_complete=false&text_contains=invoice&updated_after="+after, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = callWithNewHandler(t, req, done, old, match, other)
+	is.Equal(rr.Code, http.StatusOK)                          // Unescaped offsets are accepted
+	is.True(strings.Contains(rr.Body.String(), `"length":1`)) // Body -> only the matching task
+
+	for _, query := range []string{"?colour=red", "?sort=text", "?created_after=yesterday", "?is_complete=maybe"} {
+		req, err := http.NewRequest(http.MethodGet, "/"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := callWithNewHandler(t, req)
+		is.Equal(rr.Code, http.StatusBadRequest) // Status should equal 400
+	}
+}
+
+func TestTasksProjection(t *testing.T) {
+	is := is.New(t)
+
+	task := &tasks.Task{
+		ID:        tasks.NewTaskID(),
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+		Text:      "testing",
+	}
+
+	for _, target := range []string{"/" + task.ID, "/"} {
+		req := httptest.NewRequest(http.MethodGet, target+"?fields=id,text", nil)
+		rr := callWithNewHandler(t, req, task)
+		is.Equal(rr.Code, http.StatusOK)                                  // Status should equal 200
+		is.True(strings.Contains(rr.Body.String(), `"text":"testing"`))   // Body -> text is included
+		is.True(!strings.Contains(rr.Body.String(), `"created_at"`))      // Body -> created_at is excluded
+		is.True(!strings.Contains(rr.Body.String(), `"is_complete"`))     // Body -> is_complete is excluded
+		is.True(strings.Contains(rr.Body.String(), `"id":"`+task.ID+`"`)) // Body -> id is included
+	}
+
+	for _, q := range []string{"fields=id,nope", "expand=nope"} {
+		req := httptest.NewRequest(http.MethodGet, "/"+task.ID+"?"+q, nil)
+		rr := callWithNewHandler(t, req, task)
+		is.Equal(rr.Code, http.StatusBadRequest)            // Status should equal 400
+		is.True(strings.Contains(rr.Body.String(), "nope")) // Body -> names the unknown parameter
+	}
+
+	child := &tasks.Task{ID: tasks.NewTaskID(), Text: "child of testing", ParentID: task.ID}
+	other := &tasks.Task{ID: tasks.NewTaskID(), Text: "unrelated"}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+task.ID+"?fields=id&expand=subtasks", nil)
+	rr := callWithNewHandler(t, req, task, child, other)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+
+	var res struct {
+		Text     *string `json:"text"`
+		Subtasks []struct {
+			ID   string `json:"id"`
+			Text string `json:"text"`
+		} `json:"subtasks"`
+	}
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &res)) // Error from Unmarshal
+	is.Equal(len(res.Subtasks), 1)                  // Only subtasks are embedded
+	is.Equal(res.Subtasks[0].ID, child.ID)          // Body -> subtasks are embedded
+	is.Equal(res.Text, nil)                         // Body -> text is excluded
+}
+
+func TestTasksCreateValidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		strict      bool
+		code        int
+		contains    string
+	}{
+		{"malformed json", "application/json", `{"text": `, false, http.StatusBadRequest, "malformed json"},
+		{"not an object", "application/json", `["testing"]`, false, http.StatusBadRequest, "must be an object"},
+		{"empty body", "", ``, false, http.StatusBadRequest, "request body is empty"},
+		{"wrong content type", "text/plain", `{"text": "testing"}`, false, http.StatusUnsupportedMediaType, "application/json"},
+		{"json with charset", "application/json; charset=utf-8", `{"text": "testing"}`, false, http.StatusCreated, `"text":"testing"`},
+		{"missing text", "", `{}`, false, http.StatusUnprocessableEntity, `"code":"required"`},
+		{"blank text", "", `{"text": "  "}`, false, http.StatusUnprocessableEntity, `"code":"required"`},
+		{"wrong type", "", `{"text": 7}`, false, http.StatusUnprocessableEntity, `"code":"type"`},
+		{"too long", "", `{"text": "` + strings.Repeat("x", tasks.MaxTextLength+1) + `"}`, false, http.StatusUnprocessableEntity, `"code":"max_length"`},
+		{"longest", "", `{"text": "` + strings.Repeat("é", tasks.MaxTextLength) + `"}`, false, http.StatusCreated, `"text":"é`},
+		{"control character", "", `{"text": "one\ntwo"}`, false, http.StatusUnprocessableEntity, `"code":"control_character"`},
+		{"unknown field", "", `{"text": "testing", "due": "tomorrow"}`, false, http.StatusCreated, `"text":"testing"`},
+		{"unknown field strict", "", `{"text": "testing", "due": "tomorrow"}`, true, http.StatusUnprocessableEntity, `{"name":"due","code":"unknown"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rr := httptest.NewRecorder()
+			New(zap.NewNop(), mock.New(), WithStrictDecoding(tt.strict)).ServeHTTP(rr, req)
+			is.Equal(rr.Code, tt.code)                               // Status should match
+			is.True(strings.Contains(rr.Body.String(), tt.contains)) // Body -> explains the outcome
+		})
+	}
+}
+
+func TestTasksBulkValidation(t *testing.T) {
+	is := is.New(t)
+
+	body := `{"items": [{"text": "one"}, {"text": ""}, {"text": "three", "extra": true}]}`
+	req := httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(body))
+
+	rr := httptest.NewRecorder()
+	New(zap.NewNop(), mock.New(), WithStrictDecoding(true)).ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                                        // Status should equal 422
+	is.True(strings.Contains(rr.Body.String(), `{"name":"items[2].extra","code":"unknown"`)) // Body -> nested unknown field
+
+	rr = callWithNewHandler(t, httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(body)))
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                                        // Status should equal 422
+	is.True(strings.Contains(rr.Body.String(), `{"name":"items[1].text","code":"required"`)) // Body -> names the item
+
+	rr = callWithNewHandler(t, httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(`{"items": [null]}`)))
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                               // Status should equal 422
+	is.True(strings.Contains(rr.Body.String(), `{"name":"items[0]","code":"type"`)) // Body -> null items are refused
+}
+
+func TestTasksUpdateValidation(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	task := &tasks.Task{ID: id, CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Text: "changeme"}
+
+	req := httptest.NewRequest(http.MethodPatch, "/"+id, strings.NewReader(`{"is_complete": "yes"}`))
+	rr := callWithNewHandler(t, req, task)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                                  // Status should equal 422
+	is.True(strings.Contains(rr.Body.String(), `{"name":"is_complete","code":"type"`)) // Body -> names the field
+
+	// Text may be left out of an update, which leaves it unchanged.
+	req = httptest.NewRequest(http.MethodPatch, "/"+id, strings.NewReader(`{"is_complete": true}`))
+	rr = callWithNewHandler(t, req, task)
+	is.Equal(rr.Code, http.StatusOK)                                 // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"text":"changeme"`)) // Body -> text is unchanged
+}
+
+func TestProblemResponses(t *testing.T) {
+	is := is.New(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+tasks.NewTaskID(), nil)
+	rr := httptest.NewRecorder()
+	middleware.RequestID(New(zap.NewNop(), mock.New())).ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusNotFound)                                // Status should equal 404
+	is.Equal(rr.Header().Get("Content-Type"), "application/problem+json") // Content-Type is problem+json
+
+	var p struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+	}
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &p))
+	is.Equal(p.Type, problemTypeBase+"not-found") // Type names the kind
+	is.Equal(p.Title, "Not found")                // Title describes the kind
+	is.Equal(p.Status, http.StatusNotFound)       // Status repeats the code
+	is.Equal(p.Detail, "task not found")          // Detail describes the occurrence
+	is.True(p.Instance != "")                     // Instance is the request ID
+
+	rr = callWithNewHandler(t, httptest.NewRequest(http.MethodGet, "/?limit=zero", nil))
+	is.Equal(rr.Code, http.StatusBadRequest)                                                       // Status should equal 400
+	is.True(strings.Contains(rr.Body.String(), `"type":"`+problemTypeBase+`malformed"`))           // Type is malformed
+	is.True(strings.Contains(rr.Body.String(), `"invalid_params":[{"name":"limit","code":"type"`)) // Body -> names the parameter
+
+	rr = callWithNewHandler(t, httptest.NewRequest(http.MethodGet, "/nope/nope", nil))
+	is.Equal(rr.Code, http.StatusNotFound)                                // Status should equal 404
+	is.Equal(rr.Header().Get("Content-Type"), "application/problem+json") // Unknown routes are problems too
+}
+
+func TestProblemHidesInternalErrors(t *testing.T) {
+	is := is.New(t)
+
+	h := New(zap.NewNop(), mock.New())
+	rr := httptest.NewRecorder()
+	h.respondError(rr, httptest.NewRequest(http.MethodGet, "/", nil), errors.New("disk on fire"))
+	is.Equal(rr.Code, http.StatusInternalServerError)            // Status should equal 500
+	is.True(!strings.Contains(rr.Body.String(), "disk on fire")) // Body -> cause is not leaked
+}
+
+func TestTaskListsAndParents(t *testing.T) {
+	is := is.New(t)
+
+	repo := mock.New()
+	h := New(zap.NewNop(), repo)
+	call := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := call(http.MethodPost, "/", `{"text": "Buy milk", "list": "Groceries"}`)
+	is.Equal(rr.Code, http.StatusCreated) // Status should equal 201
+	var parent taskResource
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &parent)) // Error from Unmarshal
+	is.Equal(parent.List, "Groceries")                 // List is set on create
+
+	rr = call(http.MethodPost, "/", `{"text": "Check the fridge", "parent_id": "`+parent.ID+`"}`)
+	is.Equal(rr.Code, http.StatusCreated) // Status should equal 201
+	var child taskResource
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &child)) // Error from Unmarshal
+	is.Equal(child.ParentID, parent.ID)               // Parent is set on create
+
+	rr = call(http.MethodPost, "/", `{"text": "testing", "parent_id": "nope"}`)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity) // Parent must be a task ID
+
+	rr = call(http.MethodPatch, "/"+child.ID, `{"list": "Groceries"}`)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+	stored, err := repo.RetrieveTask(child.ID)
+	is.NoErr(err)                        // Error from RetrieveTask
+	is.Equal(stored.List, "Groceries")   // List is patched
+	is.Equal(stored.ParentID, parent.ID) // Parent is kept
+
+	rr = call(http.MethodPatch, "/"+child.ID, `{"parent_id": "`+child.ID+`"}`)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity) // A task cannot be its own parent
+
+	rr = call(http.MethodGet, "/?list=Groceries&parent_id=", "")
+	is.Equal(rr.Code, http.StatusOK)                                    // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"length":1`))           // Body -> only tasks which are not subtasks
+	is.True(strings.Contains(rr.Body.String(), `"id":"`+parent.ID+`"`)) // Body -> the parent
+
+	rr = call(http.MethodGet, "/?parent_id="+parent.ID, "")
+	is.True(strings.Contains(rr.Body.String(), `"length":1`))          // Body -> only the subtasks
+	is.True(strings.Contains(rr.Body.String(), `"id":"`+child.ID+`"`)) // Body -> the child
+
+	rr = call(http.MethodPut, "/"+child.ID, `{"text": "Check the fridge"}`)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+	stored, err = repo.RetrieveTask(child.ID)
+	is.NoErr(err)                                       // Error from RetrieveTask
+	is.True(stored.List == "" && stored.ParentID == "") // Replacing clears the list and parent
+}
+
+func TestTasksPatch(t *testing.T) {
+	id := tasks.NewTaskID()
+	existing := func() *tasks.Task {
+		return &tasks.Task{
+			ID:         id,
+			CreatedAt:  time.Now().UTC(),
+			UpdatedAt:  time.Now().UTC(),
+			Text:       "changeme",
+			IsComplete: true,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        int
+		text        string
+		isComplete  bool
+	}{
+		{"merge keeps absent fields", mergePatchType, `{"text": "testing"}`, http.StatusOK, "testing", true},
+		{"merge sets false", mergePatchType, `{"is_complete": false}`, http.StatusOK, "changeme", false},
+		{"merge resets null", mergePatchType, `{"is_complete": null}`, http.StatusOK, "changeme", false},
+		{"merge cannot remove text", mergePatchType, `{"text": null}`, http.StatusUnprocessableEntity, "changeme", true},
+		{"merge rejects read only", mergePatchType, `{"id": "other", "text": "testing"}`, http.StatusUnprocessableEntity, "changeme", true},
+		{"merge accepts unchanged read only", mergePatchType, `{"id": "` + id + `", "text": "testing"}`, http.StatusOK, "testing", true},
+		{"merge rejects wrong type", mergePatchType, `{"is_complete": "no"}`, http.StatusUnprocessableEntity, "changeme", true},
+		{"merge must be object", mergePatchType, `[]`, http.StatusBadRequest, "changeme", true},
+		{"plain json is merge", "", `{"text": "testing"}`, http.StatusOK, "testing", true},
+		{"json patch", jsonPatchType, `[{"op": "test", "path": "/text", "value": "changeme"}, {"op": "replace", "path": "/text", "value": "testing"}, {"op": "remove", "path": "/is_complete"}]`, http.StatusOK, "testing", false},
+		{"json patch move", jsonPatchType, `[{"op": "add", "path": "/draft", "value": "testing"}, {"op": "move", "from": "/draft", "path": "/text"}]`, http.StatusOK, "testing", true},
+		{"json patch failed test", jsonPatchType, `[{"op": "replace", "path": "/text", "value": "testing"}, {"op": "test", "path": "/text", "value": "changeme"}]`, http.StatusConflict, "changeme", true},
+		{"json patch missing path", jsonPatchType, `[{"op": "remove", "path": "/nope"}]`, http.StatusConflict, "changeme", true},
+		{"json patch unknown op", jsonPatchType, `[{"op": "frobnicate", "path": "/text"}]`, http.StatusBadRequest, "changeme", true},
+		{"json patch missing value", jsonPatchType, `[{"op": "add", "path": "/text"}]`, http.StatusBadRequest, "changeme", true},
+		{"unsupported type", "text/plain", `text=testing`, http.StatusUnsupportedMediaType, "changeme", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			req := httptest.NewRequest(http.MethodPatch, "/"+id, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			task := existing()
+			rr := callWithNewHandler(t, req, task)
+			is.Equal(rr.Code, tt.code)               // Status should match
+			is.Equal(task.Text, tt.text)             // Text should match
+			is.Equal(task.IsComplete, tt.isComplete) // Completion should match
+
+			if tt.code == http.StatusUnsupportedMediaType {
+				is.True(strings.Contains(rr.Header().Get("Accept-Patch"), jsonPatchType)) // Accepted types are advertised
+			}
+		})
+	}
+}
+
+func TestTasksReplace(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	repo := mock.New()
+	h := New(zap.NewNop(), repo)
+
+	req := httptest.NewRequest(http.MethodPut, "/"+id, strings.NewReader(`{"text": "offline", "is_complete": true}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusCreated)                             // Status should equal 201
+	is.Equal(rr.Header().Get("Location"), "/"+id)                     // Location is the task
+	is.True(strings.Contains(rr.Body.String(), `"id":"`+id+`"`))      // Body -> client's id is kept
+	is.True(strings.Contains(rr.Body.String(), `"is_complete":true`)) // Body -> completion is set
+
+	created, err := repo.RetrieveTask(id)
+	is.NoErr(err) // Error from RetrieveTask
+	createdAt := created.CreatedAt
+
+	req = httptest.NewRequest(http.MethodPut, "/"+id, strings.NewReader(`{"id": "`+id+`", "text": "synced"}`))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK)                                   // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"text":"synced"`))     // Body -> text is replaced
+	is.True(strings.Contains(rr.Body.String(), `"is_complete":false`)) // Body -> absent fields are reset
+
+	replaced, err := repo.RetrieveTask(id)
+	is.NoErr(err)                           // Error from RetrieveTask
+	is.Equal(replaced.CreatedAt, createdAt) // CreatedAt is preserved
+
+	for _, tt := range []struct {
+		target string
+		body   string
+		code   int
+	}{
+		{"/" + id, `{"id": "` + tasks.NewTaskID() + `", "text": "synced"}`, http.StatusUnprocessableEntity},
+		{"/" + id, `{"is_complete": true}`, http.StatusUnprocessableEntity},
+		{"/not-a-task-id", `{"text": "synced"}`, http.StatusBadRequest},
+		{"/" + strings.ToUpper(id), `{"text": "synced"}`, http.StatusBadRequest},
+	} {
+		req = httptest.NewRequest(http.MethodPut, tt.target, strings.NewReader(tt.body))
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		is.Equal(rr.Code, tt.code) // Status should match
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	updated := time.Date(2020, 5, 1, 12, 0, 0, 500, time.UTC)
+	repo := mock.New(&tasks.Task{ID: id, CreatedAt: updated, UpdatedAt: updated, Text: "testing"})
+	h := New(zap.NewNop(), repo)
+
+	get := func(target string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/" + id)
+	etag := rr.Header().Get("ETag")
+	is.Equal(rr.Code, http.StatusOK)                                            // Status should equal 200
+	is.True(strings.HasPrefix(etag, `"`))                                       // ETag is strong
+	is.Equal(rr.Header().Get("Last-Modified"), "Fri, 01 May 2020 12:00:00 GMT") // Last-Modified is UpdatedAt
+	is.Equal(rr.Header().Get("Cache-Control"), cacheControl)                    // Cache-Control is set
+
+	rr = get("/"+id, "If-None-Match", `"stale", `+etag)
+	is.Equal(rr.Code, http.StatusNotModified) // Matching ETag is not modified
+	is.Equal(rr.Body.Len(), 0)                // Body is empty
+	is.Equal(rr.Header().Get("ETag"), etag)   // ETag is repeated
+
+	rr = get("/"+id, "If-None-Match", "W/"+etag)
+	is.Equal(rr.Code, http.StatusNotModified) // Weak comparison is used
+
+	rr = get("/"+id+"?fields=id", "If-None-Match", etag)
+	is.Equal(rr.Code, http.StatusOK) // A different representation has a different ETag
+
+	rr = get("/"+id, "If-Modified-Since", "Fri, 01 May 2020 12:00:00 GMT")
+	is.Equal(rr.Code, http.StatusNotModified) // Unmodified since is not modified
+
+	rr = get("/"+id, "If-Modified-Since", "Fri, 01 May 2020 11:59:59 GMT")
+	is.Equal(rr.Code, http.StatusOK) // Modified since is sent
+
+	rr = get("/"+id, "If-None-Match", `"stale"`, "If-Modified-Since", "Fri, 01 May 2020 12:00:00 GMT")
+	is.Equal(rr.Code, http.StatusOK) // If-Modified-Since is ignored with If-None-Match
+
+	rr = get("/")
+	listETag := rr.Header().Get("ETag")
+	is.Equal(rr.Code, http.StatusOK)                                           // Status should equal 200
+	is.Equal(rr.Header().Get("Last-Modified"), "")                             // Collections are validated by ETag alone
+	is.Equal(get("/", "If-None-Match", listETag).Code, http.StatusNotModified) // Unchanged listing is not modified
+
+	is.NoErr(repo.CreateTask(&tasks.Task{Text: "another"}))           // Error from CreateTask
+	is.Equal(get("/", "If-None-Match", listETag).Code, http.StatusOK) // Changed listing is sent
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	is := is.New(t)
+
+	repo := mock.New()
+	h := New(zap.NewNop(), repo, WithIdempotency(repo, time.Hour))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	first := post("abc", `{"text": "testing"}`)
+	is.Equal(first.Code, http.StatusCreated) // Status should equal 201
+
+	replay := post("abc", `{"text": "testing"}`)
+	is.Equal(replay.Code, http.StatusCreated)                    // Replay has the same status
+	is.Equal(replay.Body.String(), first.Body.String())          // Replay has the same body
+	is.Equal(replay.Header().Get("Idempotent-Replayed"), "true") // Replay is marked
+
+	page, err := repo.ListTasks(tasks.ListOptions{})
+	is.NoErr(err)                // Error from ListTasks
+	is.Equal(len(page.Tasks), 1) // Only one task is created
+
+	reused := post("abc", `{"text": "different"}`)
+	is.Equal(reused.Code, http.StatusUnprocessableEntity)              // Reusing a key for another request is rejected
+	is.True(strings.Contains(reused.Body.String(), `"code":"reused"`)) // Body -> explains the reuse
+
+	// Simulate a request with the key which is still being served.
+	body := `{"text": "slow"}`
+	fingerprint := requestFingerprint(httptest.NewRequest(http.MethodPost, "/", nil), []byte(body))
+	_, err = repo.ReserveIdempotencyKey("def", fingerprint, time.Now().Add(time.Hour))
+	is.NoErr(err) // Error from ReserveIdempotencyKey
+
+	inFlight := post("def", body)
+	is.Equal(inFlight.Code, http.StatusConflict)        // Concurrent duplicates conflict
+	is.Equal(inFlight.Header().Get("Retry-After"), "1") // Client is told to retry
+
+	malformed := post("bad\x01key", body)
+	is.Equal(malformed.Code, http.StatusBadRequest)                                  // Malformed keys are rejected
+	is.True(strings.Contains(malformed.Body.String(), `"detail":"invalid headers"`)) // Body -> blames the headers
+
+	// Only server errors are forgotten; client errors are replayed like any
+	// other response.
+	failed := post("ghi", `{"text": ""}`)
+	is.Equal(failed.Code, http.StatusUnprocessableEntity)                             // Status should equal 422
+	is.Equal(post("ghi", `{"text": ""}`).Header().Get("Idempotent-Replayed"), "true") // Client errors are replayed
+}
+
+// txRepository mimics a database with a single connection: while a
+// transaction is open, tasks can only be listed through it.
+type txRepository struct {
+	*mock.Repository
+	open *bool
+	tx   bool
+}
+
+func (r txRepository) ListTasks(opts tasks.ListOptions) (*tasks.TaskPage, error) {
+	if *r.open && !r.tx {
+		return nil, errors.New("listed outside of the open transaction")
+	}
+	return r.Repository.ListTasks(opts)
+}
+
+func (r txRepository) WithTx(fn func(repo tasks.TaskRepository) error) error {
+	return r.Repository.WithTx(func(repo tasks.TaskRepository) error {
+		*r.open = true
+		defer func() { *r.open = false }()
+		return fn(txRepository{Repository: r.Repository, open: r.open, tx: true})
+	})
+}
+
+func TestTasksBatch(t *testing.T) {
+	is := is.New(t)
+
+	existing := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Text: "delete me"}
+	repo := mock.New(existing)
+	h := New(zap.NewNop(), repo)
+
+	batch := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+		return rr
+	}
+
+	var res struct {
+		Operations []struct {
+			Status int `json:"status"`
+			Body   struct {
+				ID         string `json:"id"`
+				Text       string `json:"text"`
+				IsComplete bool   `json:"is_complete"`
+			} `json:"body"`
+		} `json:"operations"`
+	}
+
+	rr := batch(`{"operations": [
+		{"method": "POST", "path": "/", "body": {"text": "A"}},
+		{"method": "PATCH", "path": "/${0.id}", "headers": {"Content-Type": "application/merge-patch+json"}, "body": {"is_complete": true}},
+		{"method": "DELETE", "path": "/` + existing.ID + `"}
+	]}`)
+	is.Equal(rr.Code, http.StatusOK)                               // Status should equal 200
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &res))                // Error from Unmarshal
+	is.Equal(len(res.Operations), 3)                               // Every operation is reported
+	is.Equal(res.Operations[0].Status, http.StatusCreated)         // Create succeeded
+	is.Equal(res.Operations[1].Status, http.StatusOK)              // Update succeeded
+	is.Equal(res.Operations[1].Body.ID, res.Operations[0].Body.ID) // Reference was resolved
+	is.True(res.Operations[1].Body.IsComplete)                     // Update was applied
+	is.Equal(res.Operations[2].Status, http.StatusNoContent)       // Delete succeeded
+
+	_, err := repo.RetrieveTask(existing.ID)
+	is.Equal(err, tasks.ErrTaskNotFound) // Deleted task is gone
+
+	created := res.Operations[0].Body.ID
+	rr = batch(`{"operations": [
+		{"method": "PATCH", "path": "/` + created + `", "body": {"text": "B"}},
+		{"method": "POST", "path": "/", "body": {"text": ""}},
+		{"method": "DELETE", "path": "/` + created + `"}
+	]}`)
+	is.Equal(rr.Code, http.StatusConflict)                             // Status should equal 409
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &res))                    // Error from Unmarshal
+	is.Equal(res.Operations[0].Status, http.StatusFailedDependency)    // Earlier operations are rolled back
+	is.Equal(res.Operations[1].Status, http.StatusUnprocessableEntity) // Failed operation is reported
+	is.Equal(res.Operations[2].Status, http.StatusFailedDependency)    // Later operations are not attempted
+
+	task, err := repo.RetrieveTask(created)
+	is.NoErr(err)            // Error from RetrieveTask
+	is.Equal(task.Text, "A") // Update was rolled back
+
+	rr = batch(`{"operations": [{"method": "PATCH", "path": "/${3.id}", "body": {}}]}`)
+	is.Equal(rr.Code, http.StatusConflict)                                          // Unresolved references fail the batch
+	is.True(strings.Contains(rr.Body.String(), "does not refer to a task created")) // Body -> explains the reference
+
+	rr = batch(`{"operations": [{"method": "POST", "path": "/batch"}, {"method": "TRACE", "path": "x"}]}`)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                                          // Invalid operations are rejected
+	is.True(strings.Contains(rr.Body.String(), `"name":"operations[0].path","code":"nested"`)) // Batches cannot nest
+	is.True(strings.Contains(rr.Body.String(), `"name":"operations[1].method"`))               // Methods are checked
+
+	rr = batch(`{"operations": [{"method": "POST", "path": "/batch#x"}, {"method": "POST", "path": "/%62atch/"}, {"method": "POST", "path": "/x/../batch"}]}`)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                                          // Disguised batches are rejected
+	is.True(strings.Contains(rr.Body.String(), `"name":"operations[0].path","code":"format"`)) // Fragments are refused
+	is.True(strings.Contains(rr.Body.String(), `"name":"operations[1].path","code":"nested"`)) // Escaped paths are decoded
+	is.True(strings.Contains(rr.Body.String(), `"name":"operations[2].path","code":"nested"`)) // Dot segments are resolved
+
+	h = New(zap.NewNop(), txRepository{Repository: mock.New(), open: new(bool)})
+	rr = batch(`{"operations": [
+		{"method": "POST", "path": "/", "body": {"text": "parent"}},
+		{"method": "POST", "path": "/", "body": {"text": "child", "parent_id": "${0.id}"}},
+		{"method": "GET", "path": "/${0.id}?expand=subtasks"}
+	]}`)
+	is.Equal(rr.Code, http.StatusOK)                              // Expansions are served within the batch
+	is.True(strings.Contains(rr.Body.String(), `"text":"child"`)) // Subtasks are read from the transaction
+}
+
+func TestEventsStream(t *testing.T) {
+	is := is.New(t)
+
+	b := events.NewBroker(0)
+	srv := httptest.NewServer(New(zap.NewNop(), events.Publish(mock.New(), b), WithEvents(b, 10*time.Millisecond)))
+	defer srv.Close()
+
+	create := func(text string) {
+		res, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(`{"text": "`+text+`"}`))
+		is.NoErr(err) // Error from Post
+		res.Body.Close()
+	}
+
+	connect := func(query, lastID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/events"+query, nil)
+		is.NoErr(err) // Error from NewRequest
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+
+		res, err := http.DefaultClient.Do(req.WithContext(ctx))
+		is.NoErr(err)                                                 // Error from Do
+		is.Equal(res.StatusCode, http.StatusOK)                       // Status should equal 200
+		is.Equal(res.Header.Get("Content-Type"), "text/event-stream") // Content-Type is an event stream
+		return bufio.NewReader(res.Body), func() { cancel(); res.Body.Close() }
+	}
+
+	// next reads the next event from the stream, skipping heartbeats.
+	next := func(r *bufio.Reader) string {
+		var event []string
+		for {
+			line, err := r.ReadString('\n')
+			is.NoErr(err) // Error from ReadString
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && len(event) > 0:
+				return strings.Join(event, "\n")
+			case line != "" && !strings.HasPrefix(line, ":"):
+				event = append(event, line)
+			}
+		}
+	}
+
+	stream, closeStream := connect("?types=created&text_contains=keep", "")
+	create("drop")
+	create("keep")
+	event := next(stream)
+	is.True(strings.HasPrefix(event, "id: "+b.Epoch()+"-2\nevent: created\n")) // Filtered events are skipped
+	is.True(strings.Contains(event, `"text":"keep"`))                          // Data is the task
+	closeStream()
+
+	stream, closeStream = connect("", b.Epoch()+"-1")
+	is.True(strings.HasPrefix(next(stream), "id: "+b.Epoch()+"-2\n")) // Stream resumes after Last-Event-ID
+	create("live")
+	is.True(strings.HasPrefix(next(stream), "id: "+b.Epoch()+"-3\n")) // New events follow the backlog
+	closeStream()
+
+	stream, closeStream = connect("", b.Epoch()+"-99")
+	is.Equal(next(stream), "id: "+b.Epoch()+"-3\nevent: reset\ndata: {}") // Unresumable streams are reset
+	closeStream()
+
+	stream, closeStream = connect("", "1")
+	is.Equal(next(stream), "id: "+b.Epoch()+"-3\nevent: reset\ndata: {}") // IDs from another epoch reset the stream
+	closeStream()
+
+	stream, closeStream = connect("?list=Work", "")
+	res, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(`{"text": "home", "list": "Home"}`))
+	is.NoErr(err) // Error from Post
+	res.Body.Close()
+	res, err = http.Post(srv.URL+"/", "application/json", strings.NewReader(`{"text": "work", "list": "Work"}`))
+	is.NoErr(err) // Error from Post
+	res.Body.Close()
+	is.True(strings.Contains(next(stream), `"text":"work"`)) // Events are filtered by list
+	closeStream()
+
+	res, err = http.Get(srv.URL + "/events?parent_id=nope")
+	is.NoErr(err)                                   // Error from Get
+	is.Equal(res.StatusCode, http.StatusBadRequest) // Parent IDs are checked
+	res.Body.Close()
+
+	res, err = http.Get(srv.URL + "/events?types=renamed")
+	is.NoErr(err)                                   // Error from Get
+	is.Equal(res.StatusCode, http.StatusBadRequest) // Unknown event types are rejected
+	res.Body.Close()
+}
+
+func TestWebhooks(t *testing.T) {
+	is := is.New(t)
+
+	store := mock.New()
+	h := New(zap.NewNop(), store, WithWebhooks(store), WithAdminToken("secret"), WithIdempotency(store, time.Hour))
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	is.Equal(rr.Code, http.StatusUnauthorized) // Webhooks require the admin token
+
+	rr = httptest.NewRecorder()
+	New(zap.NewNop(), store, WithWebhooks(store)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+	is.True(rr.Code != http.StatusOK) // Webhooks are not served without an admin token
+
+	rr = call(http.MethodPost, "/webhooks", `{"url": "ftp://example.com", "secret": "short", "events": ["renamed"]}`)
+	is.Equal(rr.Code, http.StatusUnprocessableEntity)                           // Invalid webhooks are rejected
+	is.True(strings.Contains(rr.Body.String(), `"name":"url","code":"format"`)) // URL must be http(s)
+	is.True(strings.Contains(rr.Body.String(), `"name":"secret"`))              // Secret must be long enough
+	is.True(strings.Contains(rr.Body.String(), `"name":"events[0]"`))           // Events must be known
+
+	rr = call(http.MethodPost, "/webhooks", `{"url": "https://example.com/hook", "events": ["created"]}`)
+	is.Equal(rr.Code, http.StatusCreated) // Status should equal 201
+
+	var created struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &created))            // Error from Unmarshal
+	is.Equal(len(created.Secret), 64)                              // Secret is generated and shown once
+	is.Equal(rr.Header().Get("Location"), "/webhooks/"+created.ID) // Location is the webhook
+
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url": "https://example.com/retried"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Idempotency-Key", "webhook")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	first := create()
+	is.Equal(first.Code, http.StatusCreated)              // Status should equal 201
+	is.Equal(create().Body.String(), first.Body.String()) // Retries replay the first response
+	hooks, err := store.ListWebhooks()
+	is.NoErr(err)           // Error from ListWebhooks
+	is.Equal(len(hooks), 2) // Retries do not create another webhook
+
+	rr = call(http.MethodGet, "/webhooks", "")
+	is.Equal(rr.Code, http.StatusOK)                             // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), created.ID))      // Webhook is listed
+	is.True(!strings.Contains(rr.Body.String(), created.Secret)) // Secret is not listed
+
+	_, err = store.RecordWebhookResult(created.ID, false, 1)
+	is.NoErr(err) // Error from RecordWebhookResult
+
+	rr = call(http.MethodGet, "/webhooks/"+created.ID, "")
+	is.True(strings.Contains(rr.Body.String(), `"disabled":true,"failures":1`)) // Failing webhook is disabled
+
+	rr = call(http.MethodPatch, "/webhooks/"+created.ID, `{"disabled": false, "events": []}`)
+	is.Equal(rr.Code, http.StatusOK)                                                         // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"events":[],"disabled":false,"failures":0`)) // Webhook is enabled again
+
+	is.NoErr(store.LogWebhookDelivery(&tasks.WebhookDelivery{
+		ID:         "d1",
+		WebhookID:  created.ID,
+		EventID:    7,
+		EventType:  "created",
+		Attempt:    1,
+		StatusCode: http.StatusBadGateway,
+		Error:      "unexpected status 502",
+		CreatedAt:  time.Now(),
+	})) // Error from LogWebhookDelivery
+
+	rr = call(http.MethodGet, "/webhooks/"+created.ID+"/deliveries", "")
+	is.Equal(rr.Code, http.StatusOK)                                 // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"status_code":502`)) // Attempts are listed
+
+	rr = call(http.MethodDelete, "/webhooks/"+created.ID, "")
+	is.Equal(rr.Code, http.StatusNoContent) // Status should equal 204
+
+	rr = call(http.MethodGet, "/webhooks/"+created.ID+"/deliveries", "")
+	is.Equal(rr.Code, http.StatusNotFound) // Deleted webhook is gone
+}
+
+// newFullHandler creates a handler serving every optional route.
+func newFullHandler() *Handler {
+	repo := mock.New()
+	return New(zap.NewNop(), repo,
+		WithAdminToken("secret"),
+		WithBackups(backuperFunc(func(ctx context.Context) (string, error) { return "", nil })),
+		WithIdempotency(repo, time.Hour),
+		WithWebhooks(repo),
+		WithEvents(events.NewBroker(0), 0),
+	)
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	served := make(map[string]bool)
+	for _, key := range newFullHandler().routeKeys() {
+		served[key] = true
+		if _, ok := operationDocs[key]; !ok {
+			t.Errorf("route %q is not documented in operationDocs", key)
+		}
+	}
+
+	for key := range operationDocs {
+		if !served[key] {
+			t.Errorf("operationDocs documents %q, which is not served", key)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	is := is.New(t)
+
+	rr := httptest.NewRecorder()
+	newFullHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+				Required   []string               `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	is.NoErr(json.Unmarshal(rr.Body.Bytes(), &doc)) // Error from Unmarshal
+	is.Equal(doc.OpenAPI, "3.1.0")                  // Document is OpenAPI 3.1
+
+	is.True(doc.Paths["/{id}"]["patch"] != nil)                          // Routes are documented
+	is.True(doc.Paths["/webhooks/{webhookID}/deliveries"]["get"] != nil) // Mounted routes are documented by their full path
+
+	task := doc.Components.Schemas["Task"]
+	is.Equal(len(task.Properties), 11)                                                         // Task schema is derived from its type
+	is.Equal(task.Required, []string{"id", "created_at", "updated_at", "text", "is_complete"}) // Encoded members are required
+
+	rr = callWithNewHandler(t, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	is.True(!strings.Contains(rr.Body.String(), "/webhooks")) // Routes which are not served are not documented
+}
+
+func TestVersioning(t *testing.T) {
+	is := is.New(t)
+
+	task := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Text: "testing"}
+	sunset := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	other := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Text: "other"}
+	h := New(zap.NewNop(), mock.New(task, other), WithRootSunset(sunset))
+
+	call := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := call("/v1/" + task.ID)
+	is.Equal(rr.Code, http.StatusOK)                     // Status should equal 200
+	is.Equal(rr.Header().Get("Deprecation"), "")         // Versioned routes are not deprecated
+	is.True(strings.Contains(rr.Body.String(), task.ID)) // Body -> task
+
+	rr = call("/" + task.ID)
+	is.Equal(rr.Code, http.StatusOK)                                                                  // Root routes are still served
+	is.Equal(rr.Header().Get("Deprecation"), fmt.Sprintf("@%d", rootDeprecatedAt.Unix()))             // Root routes are deprecated
+	is.Equal(rr.Header().Get("Sunset"), "Tue, 01 Jan 2030 00:00:00 GMT")                              // Sunset is announced
+	is.Equal(rr.Header().Get("Link"), `<http://example.com/v1/`+task.ID+`>; rel="successor-version"`) // Successor is linked
+
+	rr = call("/?limit=1")
+	is.True(strings.Contains(rr.Header().Get("Link"), `rel="successor-version", <http://example.com/?`)) // Successor is linked along with pages
+
+	rr = call("/v1/?limit=1")
+	is.True(strings.HasPrefix(rr.Header().Get("Link"), `<http://example.com/v1/?`)) // Pages are linked under the version
+
+	rr = call("/v1/openapi.json")
+	is.True(strings.Contains(rr.Body.String(), `"servers":[{"url":"/v1"}]`)) // Document is relative to the version
+
+	rr = call("/v1/nope/nope")
+	is.Equal(rr.Code, http.StatusNotFound)                                // Unknown versioned routes are not found
+	is.Equal(rr.Header().Get("Content-Type"), "application/problem+json") // Not found is a problem
+}
+
+func TestContentNegotiation(t *testing.T) {
+	is := is.New(t)
+
+	created := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	task := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: created, UpdatedAt: created, Text: "testing, with a comma"}
+	repo := mock.New(task)
+	h := New(zap.NewNop(), repo)
+
+	call := func(method, target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"text":"new"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := call(http.MethodGet, "/v1/"+task.ID, "")
+	is.Equal(rr.Header().Get("Content-Type"), "application/json") // JSON is the default
+	is.Equal(rr.Header().Get("Vary"), "Accept")                   // Responses vary by Accept
+
+	rr = call(http.MethodGet, "/v1/"+task.ID, "application/yaml")
+	is.Equal(rr.Code, http.StatusOK)                                             // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "application/yaml")                // YAML is accepted
+	is.True(strings.Contains(rr.Body.String(), "is_complete: false\n"))          // Body -> YAML members
+	is.True(strings.Contains(rr.Body.String(), "text: testing, with a comma\n")) // Body -> JSON names are kept
+
+	rr = call(http.MethodGet, "/v1/"+task.ID, "application/yaml;q=0.5, application/msgpack")
+	is.Equal(rr.Header().Get("Content-Type"), "application/msgpack") // Higher quality wins
+	is.Equal(rr.Body.Bytes()[0], byte(0x86))                         // Body -> map of six members, links included
+
+	rr = call(http.MethodGet, "/v1/"+task.ID, "text/html, */*;q=0.1")
+	is.Equal(rr.Header().Get("Content-Type"), "application/json") // Wildcards fall back to JSON
+
+	rr = call(http.MethodGet, "/v1/?format=csv", "application/json")
+	is.Equal(rr.Code, http.StatusOK)                                                     // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "text/csv; charset=utf-8; header=present") // Format overrides Accept
+	is.Equal(rr.Body.String(), "id,created_at,updated_at,text,is_complete,due_at,priority,completed_at,list,parent_id\n"+
+		task.ID+",2020-03-01T12:00:00Z,2020-03-01T12:00:00Z,\"testing, with a comma\",false,,,,,\n") // Body -> table of tasks
+
+	rr = call(http.MethodGet, "/v1/?format=csv&list=none", "")
+	is.Equal(rr.Body.String(), "id,created_at,updated_at,text,is_complete,due_at,priority,completed_at,list,parent_id\n") // Empty pages have a header
+
+	rr = call(http.MethodGet, "/v1/?format=csv&fields=text,id", "")
+	is.Equal(rr.Body.String(), "text,id\n\"testing, with a comma\","+task.ID+"\n") // Projections select the columns
+
+	rr = call(http.MethodGet, "/v1/"+task.ID, "text/csv")
+	is.Equal(rr.Code, http.StatusNotAcceptable)                           // Tasks are not tables
+	is.Equal(rr.Header().Get("Content-Type"), "application/problem+json") // Problems are always JSON
+
+	rr = call(http.MethodPost, "/v1/", "text/html")
+	is.Equal(rr.Code, http.StatusNotAcceptable) // Nothing acceptable
+	ts, _ := repo.ListTasks(tasks.ListOptions{Limit: 10})
+	is.Equal(len(ts.Tasks), 1) // Task is not created when the response would be refused
+
+	rr = call(http.MethodGet, "/v1/?format=xml", "")
+	is.Equal(rr.Code, http.StatusBadRequest) // Unknown formats are malformed
+
+	formula := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: created, UpdatedAt: created, Text: "=HYPERLINK(\"x\")", List: "formulas"}
+	is.NoErr(repo.CreateTask(formula)) // Error from CreateTask
+	rr = call(http.MethodGet, "/v1/?format=csv&fields=text&list=formulas", "")
+	is.Equal(rr.Body.String(), "text\n\"'=HYPERLINK(\"\"x\"\")\"\n") // Formulas are neutralised
+}
+
+func TestEncodeMsgpack(t *testing.T) {
+	is := is.New(t)
+
+	out, err := encodeMsgpack(map[string]interface{}{
+		"b": []interface{}{true, nil, "x"},
+		"a": 1,
+		"c": -1,
+		"d": 1.5,
+		"e": 300,
+	})
+	is.NoErr(err) // Error from encodeMsgpack
+	is.Equal(out, []byte{
+		0x85,
+		0xa1, 'a', 0x01,
+		0xa1, 'b', 0x93, 0xc3, 0xc0, 0xa1, 'x',
+		0xa1, 'c', 0xff,
+		0xa1, 'd', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa1, 'e', 0xcd, 0x01, 0x2c,
+	}) // Values are encoded in their smallest formats, with sorted keys
+}
+
+func TestTasksImport(t *testing.T) {
+	is := is.New(t)
+
+	existing := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC(), Text: "existing"}
+	repo := mock.New(existing)
+	h := New(zap.NewNop(), repo, WithIdempotency(repo, time.Hour))
+
+	call := func(target, contentType, body string) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		var lines []map[string]interface{}
+		dec := json.NewDecoder(rr.Body)
+		for dec.More() {
+			var line map[string]interface{}
+			is.NoErr(dec.Decode(&line)) // Report lines are JSON
+			lines = append(lines, line)
+		}
+		return rr, lines
+	}
+	count := func() int {
+		page, err := repo.ListTasks(tasks.ListOptions{Limit: 100})
+		is.NoErr(err) // Error from ListTasks
+		return len(page.Tasks)
+	}
+
+	id := tasks.NewTaskID()
+	csvBody := "\ufeffTitle,Done,created_at,id\n" +
+		"first,yes,2019-05-01T10:00:00Z,\n" +
+		",false,,\n" +
+		"second,true,2019-05-02T10:00:00Z," + id + "\n" +
+		"dup,false,," + existing.ID + "\n" +
+		"short,false\n" +
+		"third,0,,\n"
+
+	rr, lines := call("/v1/import?map=text:Title,is_complete:Done&batch_size=2", "text/csv", csvBody)
+	is.Equal(rr.Code, http.StatusOK)                                                                                      // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "application/x-ndjson")                                                     // Report is NDJSON
+	is.Equal(len(lines), 5)                                                                                               // Four failed rows and a summary
+	is.Equal(lines[0]["row"], 1.0)                                                                                        // Row 1 is reported
+	is.Equal(lines[0]["invalid_params"].([]interface{})[0].(map[string]interface{})["name"], "Done")                      // Problems name the column
+	is.Equal(lines[1]["row"], 2.0)                                                                                        // Missing text is reported
+	is.Equal(lines[2]["row"], 4.0)                                                                                        // Taken IDs are reported
+	is.Equal(lines[2]["status"], float64(http.StatusConflict))                                                            // Taken IDs conflict
+	is.Equal(lines[3]["row"], 5.0)                                                                                        // Short rows are reported
+	is.Equal(lines[3]["status"], float64(http.StatusBadRequest))                                                          // Short rows are malformed
+	is.Equal(lines[4]["summary"], map[string]interface{}{"rows": 6.0, "succeeded": 2.0, "failed": 4.0, "dry_run": false}) // Summary counts rows
+	is.Equal(count(), 3)                                                                                                  // Two tasks were imported
+
+	imported, err := repo.RetrieveTask(id)
+	is.NoErr(err)                                                                     // Task is imported with its ID
+	is.True(imported.IsComplete)                                                      // Completion is kept
+	is.Equal(imported.CreatedAt, time.Date(2019, time.May, 2, 10, 0, 0, 0, time.UTC)) // Creation time is kept
+
+	ndjson := `{"text":"from json","is_complete":true}` + "\n\n" + `not json` + "\n" + `{"text":"` + strings.Repeat("x", 1001) + `"}` + "\n"
+	rr, lines = call("/v1/import?dry_run=true", "application/x-ndjson", ndjson)
+	is.Equal(rr.Code, http.StatusOK)                                         // Status should equal 200
+	is.Equal(len(lines), 3)                                                  // Two failed rows and a summary
+	is.Equal(lines[0]["row"], 3.0)                                           // NDJSON rows are numbered by line
+	is.Equal(lines[1]["row"], 4.0)                                           // Long text is reported
+	is.Equal(lines[2]["summary"].(map[string]interface{})["succeeded"], 1.0) // Valid rows are counted
+	is.Equal(count(), 3)                                                     // Dry runs import nothing
+
+	rr, _ = call("/v1/import?map=text:Summary", "text/csv", "Title\nfirst\n")
+	is.Equal(rr.Code, http.StatusUnprocessableEntity) // Mapped columns must be present
+
+	rr, _ = call("/v1/import", "application/json", "{}")
+	is.Equal(rr.Code, http.StatusUnsupportedMediaType) // Only CSV and NDJSON are imported
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader("Title\nretried\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Idempotency-Key", "import")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusBadRequest)                            // Imports refuse idempotency keys
+	is.True(strings.Contains(rr.Body.String(), `"code":"unsupported"`)) // Body -> explains the refusal
+	is.Equal(count(), 3)                                                // Nothing is imported
+}
+
+func TestTodoTxt(t *testing.T) {
+	is := is.New(t)
+
+	completed := time.Date(2020, time.March, 2, 0, 0, 0, 0, time.UTC)
+	existing := &tasks.Task{
+		ID:          tasks.NewTaskID(),
+		CreatedAt:   time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:   completed,
+		Text:        "pay rent +home",
+		IsComplete:  true,
+		Priority:    "B",
+		CompletedAt: &completed,
+	}
+	repo := mock.New(existing)
+	h := New(zap.NewNop(), repo, WithIdempotency(repo, time.Hour))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/todo.txt", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK)                                                                // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "text/plain; charset=utf-8")                          // Export is plain text
+	is.Equal(rr.Body.String(), "x 2020-03-02 2020-03-01 pay rent +home pri:B id:"+existing.ID+"\n") // Task is exported as a line
+
+	id := tasks.NewTaskID()
+	body := "(A) 2020-03-01 call mom @phone id:" + id + "\n\n" +
+		"x 2020-03-02 pay rent +home pri:B id:" + existing.ID + "\n" +
+		"(C) \n"
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/todo.txt?batch_size=1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(rr.Body)
+	for dec.More() {
+		var line map[string]interface{}
+		is.NoErr(dec.Decode(&line)) // Report lines are JSON
+		lines = append(lines, line)
+	}
+	is.Equal(len(lines), 3)                                                                                               // Two failed lines and a summary
+	is.Equal(lines[0]["row"], 3.0)                                                                                        // Lines are numbered from 1
+	is.Equal(lines[0]["status"], float64(http.StatusConflict))                                                            // Taken IDs conflict
+	is.Equal(lines[1]["row"], 4.0)                                                                                        // Lines without text are reported
+	is.Equal(lines[2]["summary"], map[string]interface{}{"rows": 3.0, "succeeded": 1.0, "failed": 2.0, "dry_run": false}) // Summary counts lines
+
+	imported, err := repo.RetrieveTask(id)
+	is.NoErr(err)                                                                      // Task is imported with its ID
+	is.Equal(imported.Text, "call mom @phone")                                         // Tags stay in the text
+	is.Equal(imported.Priority, "A")                                                   // Priority is kept
+	is.Equal(imported.CreatedAt, time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)) // Creation date is kept
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/todo.txt?map=text:Title", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusBadRequest) // Lines have no columns to map
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/todo.txt", strings.NewReader("retried\n"))
+	req.Header.Set("Idempotency-Key", "import")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusBadRequest)                            // Imports refuse idempotency keys
+	is.True(strings.Contains(rr.Body.String(), `"code":"unsupported"`)) // Body -> explains the refusal
+}
+
+func TestMarkdown(t *testing.T) {
+	is := is.New(t)
+
+	repo := mock.New()
+	h := New(zap.NewNop(), repo)
+
+	body := "# Groceries\n\n" +
+		"- [ ] Buy milk\n" +
+		"  - [x] Check the fridge\n" +
+		"- [ ] \n"
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/markdown")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+
+	var lines []map[string]interface{}
+	dec := json.NewDecoder(rr.Body)
+	for dec.More() {
+		var line map[string]interface{}
+		is.NoErr(dec.Decode(&line)) // Report lines are JSON
+		lines = append(lines, line)
+	}
+	is.Equal(len(lines), 2)                                                                                               // A failed line and a summary
+	is.Equal(lines[0]["row"], 5.0)                                                                                        // Items are numbered by line
+	is.Equal(lines[1]["summary"], map[string]interface{}{"rows": 3.0, "succeeded": 2.0, "failed": 1.0, "dry_run": false}) // Summary counts items
+
+	page, err := repo.ListTasks(tasks.ListOptions{Limit: 10})
+	is.NoErr(err) // Error from ListTasks
+	var parent, child *tasks.Task
+	for _, t := range page.Tasks {
+		if t.Text == "Buy milk" {
+			parent = t
+		} else {
+			child = t
+		}
+	}
+	is.True(parent != nil && child != nil) // Items are imported
+	is.Equal(parent.List, "Groceries")     // Heading names the list
+	is.Equal(child.ParentID, parent.ID)    // Indented items are subtasks
+	is.True(child.IsComplete)              // Checked items are complete
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/", nil)
+	req.Header.Set("Accept", "text/markdown")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK)                                          // Status should equal 200
+	is.Equal(rr.Header().Get("Content-Type"), "text/markdown; charset=utf-8") // Tasks are listed as Markdown
+	is.Equal(rr.Body.String(), "## Groceries\n\n"+
+		"- [ ] Buy milk <!-- id:"+parent.ID+" -->\n"+
+		"  - [x] Check the fridge <!-- id:"+child.ID+" -->\n") // Subtasks are nested under their lists and parents
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/"+parent.ID+"?format=markdown", nil)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusNotAcceptable) // Only lists of tasks are Markdown
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/import?map=text:Title", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/markdown")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusBadRequest) // Items have no columns to map
+}
+
+func TestLinks(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now().UTC()
+	first := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now, UpdatedAt: now, Text: "first"}
+	second := &tasks.Task{ID: tasks.NewTaskID(), CreatedAt: now.Add(time.Second), UpdatedAt: now, Text: "second"}
+	_, proxies, _ := net.ParseCIDR("192.0.2.0/24")
+	h := New(zap.NewNop(), mock.New(first, second), WithTrustedProxies(proxies))
+
+	get := func(h http.Handler, target string, headers ...string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		is.Equal(rr.Code, http.StatusOK) // Status should equal 200
+
+		var body map[string]interface{}
+		is.NoErr(json.Unmarshal(rr.Body.Bytes(), &body)) // Body is JSON
+		return body
+	}
+	href := func(body map[string]interface{}, rel string) string {
+		l, _ := body["_links"].(map[string]interface{})[rel].(map[string]interface{})
+		s, _ := l["href"].(string)
+		return s
+	}
+
+	body := get(h, "/v1/"+first.ID)
+	is.Equal(href(body, "self"), "http://example.com/v1/"+first.ID)                                          // Tasks link to themselves
+	is.Equal(href(body, "update"), "http://example.com/v1/"+first.ID)                                        // Tasks link to their update
+	is.Equal(body["_links"].(map[string]interface{})["delete"].(map[string]interface{})["method"], "DELETE") // Links name methods other than GET
+	is.Equal(href(body, "collection"), "http://example.com/v1/")                                             // Tasks link to their collection
+
+	body = get(h, "/v1/?limit=1")
+	is.Equal(href(body, "self"), "http://example.com/v1/?limit=1")                                                       // Lists link to themselves
+	is.True(strings.HasPrefix(href(body, "next"), "http://example.com/v1/?cursor="))                                     // Lists link to the next page
+	is.Equal(href(body["items"].([]interface{})[0].(map[string]interface{}), "self"), "http://example.com/v1/"+first.ID) // Items link to themselves
+
+	body = get(h, href(body, "next"))
+	is.Equal(body["items"].([]interface{})[0].(map[string]interface{})["id"], second.ID) // Next link is followed
+	is.True(href(body, "next") == "")                                                    // Last page has no next link
+	is.True(href(body, "prev") != "")                                                    // Later pages link back
+
+	body = get(h, "/"+first.ID)
+	is.Equal(href(body, "self"), "http://example.com/"+first.ID) // Links follow the routes they are served from
+
+	body = get(h, "/v1/"+first.ID+"?fields=text", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "api.example.org, proxy", "X-Forwarded-Prefix", "/tasks/")
+	is.Equal(href(body, "self"), "https://api.example.org/tasks/v1/"+first.ID) // Links follow proxies
+	is.Equal(len(body), 2)                                                     // Links are kept with the fields selected
+
+	body = get(New(zap.NewNop(), mock.New(first)), "/v1/"+first.ID, "X-Forwarded-Host", "evil.example.org")
+	is.Equal(href(body, "self"), "http://example.com/v1/"+first.ID) // Untrusted forwarding headers are ignored
+
+	mux := chi.NewRouter()
+	mux.Mount("/api", h)
+	body = get(mux, "/api/v1/"+first.ID)
+	is.Equal(href(body, "self"), "http://example.com/api/v1/"+first.ID) // Links follow the mount prefix
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(`{"operations": [{"method": "GET", "path": "/`+first.ID+`"}]}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	is.Equal(rr.Code, http.StatusOK)                                                    // Status should equal 200
+	is.True(strings.Contains(rr.Body.String(), `"http://example.com/v1/`+first.ID+`"`)) // Operations link where the batch is served
+}
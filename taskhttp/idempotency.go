@@ -0,0 +1,182 @@
+package taskhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+// maxIdempotencyKeyLength is the length of the longest idempotency key
+// accepted.
+const maxIdempotencyKeyLength = 255
+
+// idempotent makes POST requests carrying an Idempotency-Key header safe to
+// retry. The response to the first request with a key is stored, and replayed
+// for any repeats until the key expires. A key may only be reused for the
+// same request, and not while the first request is still in flight.
+func (h *Handler) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if h.idempotency == nil || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !validIdempotencyKey(key) {
+			h.respondError(w, r, malformedHeader([]*tasks.FieldError{{
+				Field:   "Idempotency-Key",
+				Code:    "format",
+				Message: fmt.Sprintf("must be 1 to %d printable ASCII characters", maxIdempotencyKeyLength),
+			}}))
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to read request: %w", err))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(r, body)
+
+		existing, err := h.idempotency.ReserveIdempotencyKey(key, fingerprint, time.Now().Add(h.idempotencyTTL))
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		switch {
+		case existing == nil:
+			h.recordResponse(w, r, next, key)
+		case existing.Fingerprint != fingerprint:
+			h.respondError(w, r, tasks.Invalid(&tasks.FieldError{
+				Field:   "Idempotency-Key",
+				Code:    "reused",
+				Message: "was already used for a different request",
+			}))
+		case existing.InFlight():
+			w.Header().Set("Retry-After", "1")
+			h.respondError(w, r, tasks.NewError(tasks.KindConflict, "a request with this idempotency key is still in progress"))
+		default:
+			for name, values := range existing.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			w.Write(existing.Body)
+		}
+	})
+}
+
+// refuseIdempotencyKey rejects requests carrying an Idempotency-Key header,
+// for routes whose requests are too large to be stored and replayed, such as
+// imports, which are streamed. Retrying them is made safe by other means,
+// such as keeping the IDs of imported tasks. Without an idempotency store,
+// keys are ignored, as on every other route.
+func (h *Handler) refuseIdempotencyKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.idempotency != nil && r.Header.Get("Idempotency-Key") != "" {
+			h.respondError(w, r, malformedHeader([]*tasks.FieldError{{
+				Field:   "Idempotency-Key",
+				Code:    "unsupported",
+				Message: "is not supported on imports, give the IDs of tasks to make retries safe",
+			}}))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordResponse serves the first request with an idempotency key and stores
+// its response. Responses to requests which failed through no fault of the
+// client's are not stored, so that they can be retried with the same key.
+func (h *Handler) recordResponse(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	rw := &recordingWriter{ResponseWriter: w}
+
+	stored := false
+	defer func() {
+		// If next panicked, the key must not be left in flight.
+		if !stored {
+			if err := h.idempotency.ReleaseIdempotencyKey(key); err != nil {
+				h.logger.Error("failed to release idempotency key",
+					zap.String("request_id", middleware.GetReqID(r.Context())),
+					zap.Error(err),
+				)
+			}
+		}
+	}()
+
+	next.ServeHTTP(rw, r)
+
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	if rw.status >= http.StatusInternalServerError {
+		return
+	}
+
+	if err := h.idempotency.CompleteIdempotencyKey(key, rw.status, w.Header(), rw.body.Bytes()); err != nil {
+		h.logger.Error("failed to store idempotent response",
+			zap.String("request_id", middleware.GetReqID(r.Context())),
+			zap.Error(err),
+		)
+		return
+	}
+	stored = true
+}
+
+// recordingWriter records the status and body written through it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// requestFingerprint identifies a request by its method, URI and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s %s\n", r.Method, r.URL.RequestURI())
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7E {
+			return false
+		}
+	}
+
+	return true
+}
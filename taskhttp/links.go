@@ -0,0 +1,172 @@
+package taskhttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// link is a link from a resource to another, as in HAL. Links which are not
+// followed with GET name the method they are followed with.
+type link struct {
+	Href   string `json:"href"`
+	Method string `json:"method,omitempty"`
+}
+
+// links are the links of a resource, keyed by relation.
+type links map[string]*link
+
+// taskRels are the relations linked from every task, and the keys of the
+// routes they link to.
+var taskRels = []struct{ rel, key string }{
+	{"self", "GET /{id}"},
+	{"update", "PATCH /{id}"},
+	{"delete", "DELETE /{id}"},
+	{"collection", "GET /"},
+}
+
+type linkBaseKey struct{}
+
+// withLinkBase returns a copy of ctx in which links are made under base,
+// whichever router serves the request, such as for the operations of a batch.
+func withLinkBase(ctx context.Context, base string) context.Context {
+	return context.WithValue(ctx, linkBaseKey{}, base)
+}
+
+// linkBase returns the URL the routes of the version of the API serving r
+// are found under, e.g. "https://example.com/v1". It is the origin of r
+// followed by the patterns the routers r passed through were mounted at.
+func linkBase(r *http.Request) string {
+	if base, ok := r.Context().Value(linkBaseKey{}).(string); ok {
+		return base
+	}
+
+	// The last pattern is that of the route itself; those before it are
+	// where each router was mounted.
+	var mount string
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePatterns) > 0 {
+		for _, p := range rctx.RoutePatterns[:len(rctx.RoutePatterns)-1] {
+			mount += strings.TrimSuffix(strings.TrimSuffix(p, "*"), "/")
+		}
+	}
+
+	return origin(r) + mount
+}
+
+// origin returns the URL the handler serving r is found under, e.g.
+// "https://example.com". The scheme, host and path prefix are those a trusted
+// proxy forwarding r reports, if any.
+func origin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !fromTrustedProxy(r) {
+		return scheme + "://" + r.Host
+	}
+
+	if proto := forwarded(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if fh := forwarded(r, "X-Forwarded-Host"); fh != "" && !strings.ContainsAny(fh, "/\\@ ") {
+		host = fh
+	}
+
+	prefix := strings.TrimRight(forwarded(r, "X-Forwarded-Prefix"), "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return scheme + "://" + host + prefix
+}
+
+// forwarded returns the value a header set by proxies was given by the first
+// of them. Later proxies append their own values.
+func forwarded(r *http.Request, name string) string {
+	v := r.Header.Get(name)
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// href returns the URL of the route with key, such as "GET /{id}", in the
+// version of the API serving r, with the parameters of its pattern replaced
+// by params in turn. It returns an empty string if there is no such route.
+func (h *Handler) href(r *http.Request, key string, params ...string) string {
+	if !h.linkRoutes[key] {
+		return ""
+	}
+
+	var i int
+	path := routeParam.ReplaceAllStringFunc(key[strings.IndexByte(key, ' ')+1:], func(string) string {
+		var p string
+		if i < len(params) {
+			p = url.PathEscape(params[i])
+		}
+		i++
+		return p
+	})
+
+	return linkBase(r) + path
+}
+
+// link adds a link with relation rel to the route with key to ls, if there
+// is such a route.
+func (h *Handler) link(r *http.Request, ls links, rel, key string, params ...string) {
+	href := h.href(r, key, params...)
+	if href == "" {
+		return
+	}
+
+	l := &link{Href: href}
+	if method := key[:strings.IndexByte(key, ' ')]; method != http.MethodGet {
+		l.Method = method
+	}
+	ls[rel] = l
+}
+
+// linkTask sets the links of the representation of a task and returns it.
+func (h *Handler) linkTask(r *http.Request, res *taskResource) *taskResource {
+	res.Links = make(links, len(taskRels))
+	for _, tr := range taskRels {
+		h.link(r, res.Links, tr.rel, tr.key, res.ID)
+	}
+	return res
+}
+
+// linkPage returns the links of a page of tasks: itself, the collection and
+// the pages either side of it which there are cursors for.
+func (h *Handler) linkPage(r *http.Request, next, prev string) links {
+	ls := make(links)
+	h.link(r, ls, "collection", "GET /")
+
+	if ls["collection"] == nil {
+		return ls
+	}
+	page := func(cursor string) *link {
+		q := r.URL.Query()
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		href := ls["collection"].Href
+		if len(q) > 0 {
+			href += "?" + q.Encode()
+		}
+		return &link{Href: href}
+	}
+
+	ls["self"] = page("")
+	if next != "" {
+		ls["next"] = page(next)
+	}
+	if prev != "" {
+		ls["prev"] = page(prev)
+	}
+	return ls
+}
@@ -0,0 +1,34 @@
+package taskhttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"example.com/tasks"
+	"example.com/tasks/markdown"
+)
+
+const markdownType = "text/markdown"
+
+// markdownRows reads the rows of a Markdown import, a task for each task list
+// item. Rows are numbered by line.
+type markdownRows struct {
+	reader *markdown.Reader
+}
+
+func (rows *markdownRows) next() (*importRow, error) {
+	t, err := rows.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == bufio.ErrTooLong {
+		return nil, tasks.NewError(tasks.KindMalformed,
+			fmt.Sprintf("line %d is too long", rows.reader.Line()+1))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read markdown: %w", err)
+	}
+
+	return &importRow{number: rows.reader.Line(), task: t}, nil
+}
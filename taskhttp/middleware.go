@@ -1,11 +1,19 @@
 package taskhttp
 
 import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/felixge/httpsnoop"
+	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
+
+	"example.com/tasks"
 )
 
 func logAccess(logger *zap.Logger) func(http.Handler) http.Handler {
@@ -27,3 +35,80 @@ func logAccess(logger *zap.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// requireBearerToken rejects requests which do not carry token in their
+// Authorization header.
+func requireBearerToken(token string) func(http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, expected) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tasks"`)
+				respondProblem(w, r, tasks.NewError(tasks.KindUnauthorized, "a valid bearer token is required"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validateTaskID rejects requests whose {id} URL parameter could not be a task
+// ID, before they reach the repository.
+func validateTaskID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tasks.ValidTaskID(chi.URLParam(r, "id")) {
+			respondProblem(w, r, tasks.NewError(tasks.KindMalformed, "malformed task id"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecated marks the responses to routes which are deprecated in favour of
+// the same routes under successor, with the Deprecation (RFC 9745) and Sunset
+// (RFC 8594) headers and a link to the successor.
+func deprecated(successor string, deprecatedAt, sunset time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecatedAt.Unix()))
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			w.Header().Add("Link", fmt.Sprintf(`<%s%s%s>; rel="successor-version"`, origin(r), successor, r.URL.EscapedPath()))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type trustedProxyKey struct{}
+
+// trustProxies marks the requests made by the handler's trusted proxies, whose
+// forwarding headers links follow. It must come before middleware which
+// replaces the remote address with that of the client a proxy reports.
+func (h *Handler) trustProxies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			for _, n := range h.trustedProxies {
+				if n.Contains(ip) {
+					r = r.WithContext(context.WithValue(r.Context(), trustedProxyKey{}, true))
+					break
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromTrustedProxy reports whether r was made by a trusted proxy.
+func fromTrustedProxy(r *http.Request) bool {
+	trusted, _ := r.Context().Value(trustedProxyKey{}).(bool)
+	return trusted
+}
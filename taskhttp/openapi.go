@@ -0,0 +1,393 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the document
+// served at /openapi.json follows.
+const openAPIVersion = "3.1.0"
+
+// operationDoc documents a route. The schemas of bodies are derived from the
+// Go types of the values given for them.
+type operationDoc struct {
+	summary     string
+	description string
+
+	// params are the query and header parameters of the route. Path
+	// parameters are taken from its pattern.
+	params []*paramDoc
+
+	// request maps each media type accepted for the request body to a value
+	// of the type it is decoded into.
+	request map[string]interface{}
+
+	responses map[int]*responseDoc
+}
+
+type paramDoc struct {
+	name        string
+	in          string
+	description string
+
+	// value is a value of the type of the parameter.
+	value interface{}
+}
+
+type responseDoc struct {
+	description string
+
+	// mediaType is that of the body. If it is empty, the body is sent in
+	// whichever representation is negotiated, JSON by default.
+	mediaType string
+
+	// body is a value of the type of the body, or nil if there is none.
+	body interface{}
+}
+
+// queryParam and headerParam document parameters.
+func queryParam(name, description string, value interface{}) *paramDoc {
+	return &paramDoc{name: name, in: "query", description: description, value: value}
+}
+
+func headerParam(name, description string) *paramDoc {
+	return &paramDoc{name: name, in: "header", description: description, value: ""}
+}
+
+// response documents a successful response with body, and problemResponse
+// documents an error reported as a problem.
+func response(description string, body interface{}) *responseDoc {
+	return &responseDoc{description: description, body: body}
+}
+
+func problemResponse(description string) *responseDoc {
+	return &responseDoc{description: description, mediaType: "application/problem+json", body: &problem{}}
+}
+
+// pathParams describes the parameters which may appear in route patterns.
+var pathParams = map[string]string{
+	"id":        "The ID of a task.",
+	"webhookID": "The ID of a webhook.",
+}
+
+// The structure of an OpenAPI document. Only the parts which are used are
+// modeled.
+type (
+	openAPIDocument struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       openAPIInfo                             `json:"info"`
+		Servers    []openAPIServer                         `json:"servers"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+	}
+
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+
+	openAPIServer struct {
+		URL string `json:"url"`
+	}
+
+	openAPIComponents struct {
+		Schemas map[string]schema `json:"schemas"`
+	}
+
+	openAPIOperation struct {
+		Summary     string                      `json:"summary"`
+		Description string                      `json:"description,omitempty"`
+		Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+		Responses   map[string]*openAPIResponse `json:"responses"`
+	}
+
+	openAPIParameter struct {
+		Name        string `json:"name"`
+		In          string `json:"in"`
+		Description string `json:"description,omitempty"`
+		Required    bool   `json:"required,omitempty"`
+		Schema      schema `json:"schema"`
+	}
+
+	openAPIRequestBody struct {
+		Required bool                         `json:"required"`
+		Content  map[string]*openAPIMediaType `json:"content"`
+	}
+
+	openAPIResponse struct {
+		Description string                       `json:"description"`
+		Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+	}
+
+	openAPIMediaType struct {
+		Schema schema `json:"schema"`
+	}
+
+	// schema is a JSON Schema.
+	schema map[string]interface{}
+)
+
+// routeParam matches the path parameters of a route pattern.
+var routeParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// normalizeRoute converts a route as reported by chi.Walk to the pattern it
+// matches, e.g. "/webhooks/*/{webhookID}" to "/webhooks/{webhookID}".
+func normalizeRoute(route string) string {
+	route = strings.Replace(route, "/*/", "/", -1)
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+	return route
+}
+
+// routeKeys returns the method and pattern of every route of the first
+// version of the API, such as "GET /{id}".
+func (h *Handler) routeKeys() []string {
+	r := chi.NewRouter()
+	h.v1Routes(r)
+
+	var keys []string
+	chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		keys = append(keys, method+" "+normalizeRoute(route))
+		return nil
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// openAPI describes the routes of the first version of the API. Routes without
+// documentation are left out.
+func (h *Handler) openAPI() *openAPIDocument {
+	b := &schemaBuilder{components: make(map[string]schema)}
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: "Tasks API", Version: "1.0.0"},
+		Servers: []openAPIServer{{URL: "/v1"}},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+
+	// Tasks are referred to by name from fields holding projections of them.
+	b.schema(reflect.TypeOf(taskResource{}), false)
+
+	for _, key := range h.routeKeys() {
+		od, ok := operationDocs[key]
+		if !ok {
+			continue
+		}
+
+		parts := strings.SplitN(key, " ", 2)
+		method, pattern := strings.ToLower(parts[0]), parts[1]
+
+		op := &openAPIOperation{
+			Summary:     od.summary,
+			Description: od.description,
+			Responses:   make(map[string]*openAPIResponse),
+		}
+
+		for _, m := range routeParam.FindAllStringSubmatch(pattern, -1) {
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:        m[1],
+				In:          "path",
+				Description: pathParams[m[1]],
+				Required:    true,
+				Schema:      schema{"type": "string"},
+			})
+		}
+
+		for _, p := range od.params {
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:        p.name,
+				In:          p.in,
+				Description: p.description,
+				Schema:      b.schema(reflect.TypeOf(p.value), true),
+			})
+		}
+
+		if len(od.request) > 0 {
+			op.RequestBody = &openAPIRequestBody{Required: true, Content: make(map[string]*openAPIMediaType)}
+			for mt, v := range od.request {
+				op.RequestBody.Content[mt] = &openAPIMediaType{Schema: b.schema(reflect.TypeOf(v), true)}
+			}
+		}
+
+		negotiated := false
+		for status, rd := range od.responses {
+			res := &openAPIResponse{Description: rd.description}
+			if rd.body != nil {
+				s := b.schema(reflect.TypeOf(rd.body), false)
+				res.Content = map[string]*openAPIMediaType{rd.mediaType: {Schema: s}}
+				if rd.mediaType == "" {
+					// Bodies without a media type of their own are sent in
+					// whichever representation is negotiated.
+					res.Content = negotiatedContent(rd.body, s)
+					negotiated = true
+				}
+			}
+			op.Responses[strconv.Itoa(status)] = res
+		}
+		if negotiated {
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:        "format",
+				In:          "query",
+				Description: "The representation of the response, overriding the Accept header: " + strings.Join(formats(), ", ") + ".",
+				Schema:      schema{"type": "string", "enum": formats()},
+			})
+		}
+
+		if doc.Paths[pattern] == nil {
+			doc.Paths[pattern] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[pattern][method] = op
+	}
+
+	doc.Components.Schemas = b.components
+	return doc
+}
+
+// negotiatedContent describes the representations a body of schema s may be
+// sent in. Tables and task lists of list items are described as strings.
+func negotiatedContent(body interface{}, s schema) map[string]*openAPIMediaType {
+	content := make(map[string]*openAPIMediaType)
+	for _, enc := range encoders {
+		switch {
+		case enc.shape == shapeAny:
+			content[enc.mediaTypes[0]] = &openAPIMediaType{Schema: s}
+		case enc.shape <= shapeOf(body):
+			content[enc.mediaTypes[0]] = &openAPIMediaType{Schema: schema{"type": "string"}}
+		}
+	}
+	return content
+}
+
+func (h *Handler) openAPIDocument() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondConditional(w, r, http.StatusOK, h.openAPI(), time.Time{})
+	}
+}
+
+// schemaBuilder derives JSON Schemas from Go types, as encoding/json would
+// encode them. Named struct types are added to components and referred to.
+//
+// Members which are always encoded are required in responses. Request
+// bodies are decoded with every member optional, so nothing is required in
+// them; the rules for their fields are reported by validation instead.
+type schemaBuilder struct {
+	components map[string]schema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type, request bool) schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": b.schema(t.Elem(), request)}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": b.schema(t.Elem(), request)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t, request)
+		}
+
+		name := schemaName(t)
+		if _, ok := b.components[name]; !ok {
+			// The name is claimed first, in case the type refers to itself.
+			b.components[name] = nil
+			b.components[name] = b.object(t, request)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	}
+
+	// Interfaces may hold anything.
+	return schema{}
+}
+
+// object derives the schema of a struct from its exported fields.
+func (b *schemaBuilder) object(t reflect.Type, request bool) schema {
+	properties := make(map[string]schema)
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, opts := f.Name, ""
+		if tag := f.Tag.Get("json"); tag != "" {
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				opts = parts[1]
+			}
+		}
+
+		s := b.schema(f.Type, request)
+		if ref := f.Tag.Get("openapi"); ref != "" {
+			// The field holds an interface{} which is documented as a
+			// particular schema, or a slice of them.
+			s = schema{"$ref": "#/components/schemas/" + ref}
+			if f.Type.Kind() == reflect.Slice {
+				s = schema{"type": "array", "items": s}
+			}
+		}
+		properties[name] = s
+
+		if !request && !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// schemaName names the component for a named struct type, e.g. "Task" for
+// taskResource.
+func schemaName(t reflect.Type) string {
+	name := []rune(strings.TrimSuffix(t.Name(), "Resource"))
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,308 @@
+package taskhttp
+
+import (
+	"net/http"
+	"time"
+)
+
+// Parameters shared by several routes.
+var (
+	fieldsParam = queryParam("fields", "Comma separated fields to include in each task.", "")
+	expandParam = queryParam("expand", "Comma separated related resources to embed in each task: subtasks.", "")
+
+	modeParam = queryParam("mode", "How failing items are handled: atomic (the default) or best-effort.", "")
+
+	idempotencyKeyParam = headerParam("Idempotency-Key", "A unique key which makes retries of the request return the first response instead of repeating it.")
+	ifNoneMatchParam    = headerParam("If-None-Match", "Respond with 304 Not Modified if the representation has one of these ETags.")
+	ifModifiedParam     = headerParam("If-Modified-Since", "Respond with 304 Not Modified if the task has not changed since this time.")
+	adminTokenParam     = headerParam("Authorization", "Bearer followed by the admin token.")
+)
+
+// Responses shared by several routes.
+var (
+	unchanged = &responseDoc{description: "The representation has not changed."}
+	noContent = &responseDoc{description: "The request succeeded."}
+
+	malformed    = problemResponse("The request could not be understood.")
+	invalid      = problemResponse("The request broke the rules for its fields.")
+	unsupported  = problemResponse("The body has an unsupported media type.")
+	taskNotFound = problemResponse("There is no such task.")
+	hookNotFound = problemResponse("There is no such webhook.")
+	conflict     = problemResponse("The request conflicts with the current state.")
+	unauthorized = problemResponse("The admin token is missing or wrong.")
+)
+
+// operationDocs documents every route, keyed by method and pattern. A test
+// checks that each route served is documented here.
+var operationDocs = map[string]*operationDoc{
+	"GET /": {
+		summary:     "List tasks",
+		description: "Lists a page of tasks. Further pages are linked by the Link header and the next_cursor and prev_cursor members.",
+		params: []*paramDoc{
+			queryParam("limit", "The maximum number of tasks to list, at most 100.", 0),
+			queryParam("cursor", "Continues the listing from a cursor of a previous page.", ""),
+			queryParam("count", "Whether to count the tasks matching the filter.", false),
+			queryParam("sort", "Comma separated fields to sort by, each descending if prefixed by -.", ""),
+			queryParam("is_complete", "Lists only complete or incomplete tasks.", false),
+			queryParam("created_after", "Lists only tasks created after this time.", time.Time{}),
+			queryParam("created_before", "Lists only tasks created before this time.", time.Time{}),
+			queryParam("updated_after", "Lists only tasks updated after this time.", time.Time{}),
+			queryParam("updated_before", "Lists only tasks updated before this time.", time.Time{}),
+			queryParam("text_contains", "Lists only tasks whose text contains this, ignoring case.", ""),
+			queryParam("list", "Lists only tasks on this list, or on no list if it is empty.", ""),
+			queryParam("parent_id", "Lists only subtasks of the task with this ID, or tasks which are not subtasks if it is empty.", ""),
+			fieldsParam,
+			expandParam,
+			ifNoneMatchParam,
+		},
+		responses: map[int]*responseDoc{
+			http.StatusOK:          response("A page of tasks.", &taskListResponse{}),
+			http.StatusNotModified: unchanged,
+			http.StatusBadRequest:  malformed,
+		},
+	},
+	"POST /": {
+		summary: "Create a task",
+		params:  []*paramDoc{idempotencyKeyParam},
+		request: map[string]interface{}{"application/json": &createTaskRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusCreated:              response("The created task.", &taskResource{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusConflict:             conflict,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"POST /bulk": {
+		summary: "Create many tasks",
+		params:  []*paramDoc{modeParam, idempotencyKeyParam},
+		request: map[string]interface{}{"application/json": &bulkCreateRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusCreated:              response("Every task was created.", &bulkResponse{}),
+			http.StatusMultiStatus:          response("Some tasks were not created.", &bulkResponse{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusConflict:             conflict,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"PATCH /bulk": {
+		summary: "Update many tasks",
+		params:  []*paramDoc{modeParam},
+		request: map[string]interface{}{"application/json": &bulkUpdateRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("Every task was updated.", &bulkResponse{}),
+			http.StatusMultiStatus:          response("Some tasks were not updated.", &bulkResponse{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"DELETE /bulk": {
+		summary: "Delete many tasks",
+		params:  []*paramDoc{modeParam},
+		request: map[string]interface{}{"application/json": &bulkDeleteRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("Every task was deleted.", &bulkResponse{}),
+			http.StatusMultiStatus:          response("Some tasks were not deleted.", &bulkResponse{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"POST /batch": {
+		summary:     "Apply operations in one transaction",
+		description: "Serves each operation against the other routes, in order, in one transaction. Paths and bodies may refer to the ID returned by operation N as ${N.id}.",
+		params:      []*paramDoc{idempotencyKeyParam},
+		request:     map[string]interface{}{"application/json": &batchRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("Every operation succeeded.", &batchResponse{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusConflict:             response("An operation failed, and the batch was rolled back.", &batchResponse{}),
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"POST /import": {
+		summary:     "Import tasks",
+		description: "Imports tasks from CSV with a header row, from NDJSON, or from the items of a Markdown task list, in batches of one transaction each. The IDs, times, completion, priority, list and parent of tasks are kept when given; Markdown items are subtasks of the items they are indented under, and on the list named by the heading above them. The response lists each row which was not imported, followed by a summary, as NDJSON. Idempotency keys are refused; importing tasks with their IDs makes retries safe.",
+		params: []*paramDoc{
+			queryParam("dry_run", "Whether to only check the rows, without importing them.", false),
+			queryParam("batch_size", "The number of rows imported in each transaction, at most 5000.", 0),
+			queryParam("map", "Comma separated field:column pairs, naming the column or member a field is read from when it is not the field's name. Not supported for Markdown.", ""),
+		},
+		request: map[string]interface{}{csvType: "", ndjsonType: "", markdownType: ""},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   {description: "The rows which were not imported, and a summary.", mediaType: ndjsonType, body: ""},
+			http.StatusBadRequest:           malformed,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"GET /todo.txt": {
+		summary:     "Export tasks as todo.txt",
+		description: "Exports every task as a line of todo.txt. The ID of each task is written as an id:ID extra, and the priority of a completed task as a pri:P extra, so that importing the file again keeps them.",
+		responses: map[int]*responseDoc{
+			http.StatusOK: {description: "The tasks, a line each.", mediaType: todoType, body: ""},
+		},
+	},
+	"POST /todo.txt": {
+		summary:     "Import tasks from todo.txt",
+		description: "Imports a task from each line of todo.txt which is not blank, in batches of one transaction each. Completion marks, priorities, dates and id:ID extras are kept. The response lists each line which was not imported, followed by a summary, as NDJSON. Idempotency keys are refused; id:ID extras make retries safe.",
+		params: []*paramDoc{
+			queryParam("dry_run", "Whether to only check the lines, without importing them.", false),
+			queryParam("batch_size", "The number of lines imported in each transaction, at most 5000.", 0),
+		},
+		request: map[string]interface{}{todoType: ""},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   {description: "The lines which were not imported, and a summary.", mediaType: ndjsonType, body: ""},
+			http.StatusBadRequest:           malformed,
+			http.StatusUnsupportedMediaType: unsupported,
+		},
+	},
+	"GET /{id}": {
+		summary: "Retrieve a task",
+		params:  []*paramDoc{fieldsParam, expandParam, ifNoneMatchParam, ifModifiedParam},
+		responses: map[int]*responseDoc{
+			http.StatusOK:          response("The task.", &taskResource{}),
+			http.StatusNotModified: unchanged,
+			http.StatusBadRequest:  malformed,
+			http.StatusNotFound:    taskNotFound,
+		},
+	},
+	"PUT /{id}": {
+		summary:     "Replace or create a task",
+		description: "Replaces the task, or creates it with this ID if it does not exist.",
+		request:     map[string]interface{}{"application/json": &replaceTaskRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("The replaced task.", &taskResource{}),
+			http.StatusCreated:              response("The created task.", &taskResource{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"PATCH /{id}": {
+		summary: "Update a task",
+		request: map[string]interface{}{
+			mergePatchType:     map[string]interface{}{},
+			jsonPatchType:      []*patchOperation{},
+			"application/json": map[string]interface{}{},
+		},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("The updated task.", &taskResource{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusNotFound:             taskNotFound,
+			http.StatusConflict:             problemResponse("A JSON Patch operation could not be applied."),
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"DELETE /{id}": {
+		summary: "Delete a task",
+		responses: map[int]*responseDoc{
+			http.StatusNoContent:  noContent,
+			http.StatusBadRequest: malformed,
+			http.StatusNotFound:   taskNotFound,
+		},
+	},
+	"GET /events": {
+		summary:     "Stream changes to tasks",
+		description: "Streams created, updated and deleted events as Server-Sent Events. A reset event means events were missed and tasks should be fetched again.",
+		params: []*paramDoc{
+			queryParam("types", "Comma separated types of event to stream.", ""),
+			queryParam("is_complete", "Streams only events for complete or incomplete tasks.", false),
+			queryParam("text_contains", "Streams only events for tasks whose text contains this, ignoring case.", ""),
+			queryParam("list", "Streams only events for tasks on this list, or on no list if it is empty.", ""),
+			queryParam("parent_id", "Streams only events for subtasks of the task with this ID, or tasks which are not subtasks if it is empty.", ""),
+			queryParam("last_event_id", "Resumes the stream after this event, such as kx3b9q1c-42.", ""),
+			headerParam("Last-Event-ID", "Resumes the stream after this event."),
+		},
+		responses: map[int]*responseDoc{
+			http.StatusOK:         {description: "The event stream.", mediaType: "text/event-stream", body: ""},
+			http.StatusBadRequest: malformed,
+		},
+	},
+	"GET /webhooks": {
+		summary: "List webhooks",
+		params:  []*paramDoc{adminTokenParam},
+		responses: map[int]*responseDoc{
+			http.StatusOK:           response("Every webhook.", &webhookListResponse{}),
+			http.StatusUnauthorized: unauthorized,
+		},
+	},
+	"POST /webhooks": {
+		summary:     "Create a webhook",
+		description: "A secret for signing deliveries is generated if none is given. The secret is only included in this response.",
+		params:      []*paramDoc{adminTokenParam, idempotencyKeyParam},
+		request:     map[string]interface{}{"application/json": &createWebhookRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusCreated:              response("The created webhook.", &webhookResource{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusUnauthorized:         unauthorized,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"GET /webhooks/{webhookID}": {
+		summary: "Retrieve a webhook",
+		params:  []*paramDoc{adminTokenParam},
+		responses: map[int]*responseDoc{
+			http.StatusOK:           response("The webhook.", &webhookResource{}),
+			http.StatusUnauthorized: unauthorized,
+			http.StatusNotFound:     hookNotFound,
+		},
+	},
+	"PATCH /webhooks/{webhookID}": {
+		summary:     "Update a webhook",
+		description: "Changes the members given. Enabling a webhook resets its count of failures.",
+		params:      []*paramDoc{adminTokenParam},
+		request:     map[string]interface{}{"application/json": &updateWebhookRequest{}},
+		responses: map[int]*responseDoc{
+			http.StatusOK:                   response("The updated webhook.", &webhookResource{}),
+			http.StatusBadRequest:           malformed,
+			http.StatusUnauthorized:         unauthorized,
+			http.StatusNotFound:             hookNotFound,
+			http.StatusUnsupportedMediaType: unsupported,
+			http.StatusUnprocessableEntity:  invalid,
+		},
+	},
+	"DELETE /webhooks/{webhookID}": {
+		summary: "Delete a webhook",
+		params:  []*paramDoc{adminTokenParam},
+		responses: map[int]*responseDoc{
+			http.StatusNoContent:    noContent,
+			http.StatusUnauthorized: unauthorized,
+			http.StatusNotFound:     hookNotFound,
+		},
+	},
+	"GET /webhooks/{webhookID}/deliveries": {
+		summary: "List delivery attempts of a webhook",
+		params: []*paramDoc{
+			queryParam("limit", "The maximum number of attempts to list, at most 100.", 0),
+			adminTokenParam,
+		},
+		responses: map[int]*responseDoc{
+			http.StatusOK:           response("The most recent attempts, newest first.", &deliveryListResponse{}),
+			http.StatusBadRequest:   malformed,
+			http.StatusUnauthorized: unauthorized,
+			http.StatusNotFound:     hookNotFound,
+		},
+	},
+	"POST /admin/backups": {
+		summary: "Create a backup",
+		params:  []*paramDoc{adminTokenParam},
+		responses: map[int]*responseDoc{
+			http.StatusCreated:      response("The backup was written.", &backupResponse{}),
+			http.StatusUnauthorized: unauthorized,
+		},
+	},
+	"GET /openapi.json": {
+		summary: "Describe the API",
+		params:  []*paramDoc{ifNoneMatchParam},
+		responses: map[int]*responseDoc{
+			http.StatusOK:          response("This OpenAPI document.", map[string]interface{}{}),
+			http.StatusNotModified: unchanged,
+		},
+	},
+}
@@ -0,0 +1,482 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/tasks"
+)
+
+const (
+	// mergePatchType is the media type of RFC 7396 JSON Merge Patch documents.
+	mergePatchType = "application/merge-patch+json"
+
+	// jsonPatchType is the media type of RFC 6902 JSON Patch documents.
+	jsonPatchType = "application/json-patch+json"
+)
+
+// patchTypes are the media types accepted by tasksUpdate. Plain JSON, or a
+// body without a Content-Type, is taken to be a merge patch.
+var patchTypes = []string{mergePatchType, jsonPatchType, "application/json"}
+
+// readOnlyFields are the fields of a task's representation which cannot be
+// patched.
+var readOnlyFields = []string{"id", "created_at", "updated_at", "priority", "completed_at"}
+
+// parsePatch parses a patch document of media type mt into a function which
+// applies it to a task, for tasks.TaskRepository.PatchTask.
+func (h *Handler) parsePatch(mt string, data []byte) (func(*tasks.Task) error, error) {
+	var apply func(doc map[string]interface{}) (interface{}, error)
+
+	switch mt {
+	case jsonPatchType:
+		var ops []*patchOperation
+		if err := unmarshalJSON(data, &ops); err != nil {
+			return nil, err
+		}
+
+		for i, op := range ops {
+			if err := op.validate(); err != nil {
+				return nil, tasks.NewError(tasks.KindMalformed, fmt.Sprintf("operation %d: %s", i, err))
+			}
+		}
+
+		apply = func(doc map[string]interface{}) (interface{}, error) {
+			var v interface{} = doc
+			for i, op := range ops {
+				var err error
+				if v, err = op.apply(v); err != nil {
+					return nil, tasks.NewError(tasks.KindConflict, fmt.Sprintf("operation %d: %s", i, err))
+				}
+			}
+			return v, nil
+		}
+	default:
+		var patch interface{}
+		if err := unmarshalJSON(data, &patch); err != nil {
+			return nil, err
+		}
+
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return nil, tasks.NewError(tasks.KindMalformed, "merge patch must be an object")
+		}
+
+		apply = func(doc map[string]interface{}) (interface{}, error) {
+			return mergePatch(doc, patch), nil
+		}
+	}
+
+	return func(t *tasks.Task) error {
+		doc := taskDocument(t)
+		patched, err := apply(taskDocument(t))
+		if err != nil {
+			return err
+		}
+
+		return h.applyDocument(t, doc, patched)
+	}, nil
+}
+
+// taskDocument returns the JSON representation of t as generic JSON values.
+func taskDocument(t *tasks.Task) map[string]interface{} {
+	data, err := json.Marshal(newTaskResource(t))
+	if err != nil {
+		panic("json marshal error on task, this is a bug")
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		panic("json unmarshal error on task, this is a bug")
+	}
+
+	return doc
+}
+
+// applyDocument sets the fields of t from patched, a patched copy of its
+// representation doc. Read only fields must be left unchanged. Fields which
+// were removed take their default value, except for text which is required.
+func (h *Handler) applyDocument(t *tasks.Task, doc map[string]interface{}, patched interface{}) error {
+	obj, ok := patched.(map[string]interface{})
+	if !ok {
+		return tasks.Invalid(&tasks.FieldError{Field: "", Code: "type", Message: "task must be an object"})
+	}
+
+	var errs []*tasks.FieldError
+	for _, name := range readOnlyFields {
+		if !reflect.DeepEqual(obj[name], doc[name]) {
+			errs = append(errs, &tasks.FieldError{Field: name, Code: "read_only", Message: "cannot be changed"})
+		}
+	}
+
+	switch text := obj["text"].(type) {
+	case string:
+		if fieldErrs := validateText("text", text, true); len(fieldErrs) > 0 {
+			errs = append(errs, fieldErrs...)
+		} else {
+			t.Text = text
+		}
+	case nil:
+		errs = append(errs, &tasks.FieldError{Field: "text", Code: "required", Message: "is required"})
+	default:
+		errs = append(errs, &tasks.FieldError{Field: "text", Code: "type", Message: "must be a string"})
+	}
+
+	switch isComplete := obj["is_complete"].(type) {
+	case bool:
+		t.IsComplete = isComplete
+	case nil:
+		t.IsComplete = false
+	default:
+		errs = append(errs, &tasks.FieldError{Field: "is_complete", Code: "type", Message: "must be a boolean"})
+	}
+
+	switch dueAt := obj["due_at"].(type) {
+	case string:
+		if at, err := time.Parse(time.RFC3339, dueAt); err != nil {
+			errs = append(errs, &tasks.FieldError{Field: "due_at", Code: "format", Message: "must be an RFC 3339 time"})
+		} else {
+			at = at.UTC()
+			t.DueAt = &at
+		}
+	case nil:
+		t.DueAt = nil
+	default:
+		errs = append(errs, &tasks.FieldError{Field: "due_at", Code: "type", Message: "must be a string"})
+	}
+
+	switch list := obj["list"].(type) {
+	case string:
+		if fieldErrs := validateText("list", list, false); len(fieldErrs) > 0 {
+			errs = append(errs, fieldErrs...)
+		} else {
+			t.List = list
+		}
+	case nil:
+		t.List = ""
+	default:
+		errs = append(errs, &tasks.FieldError{Field: "list", Code: "type", Message: "must be a string"})
+	}
+
+	switch parentID := obj["parent_id"].(type) {
+	case string:
+		if fieldErrs := validateParent("parent_id", parentID, t.ID); len(fieldErrs) > 0 {
+			errs = append(errs, fieldErrs...)
+		} else {
+			t.ParentID = parentID
+		}
+	case nil:
+		t.ParentID = ""
+	default:
+		errs = append(errs, &tasks.FieldError{Field: "parent_id", Code: "type", Message: "must be a string"})
+	}
+
+	if h.strict {
+		known := jsonFields(reflect.TypeOf(taskResource{}))
+		for name := range obj {
+			if _, ok := known[name]; !ok {
+				errs = append(errs, &tasks.FieldError{Field: name, Code: "unknown", Message: "is not a known field"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return tasks.Invalid(errs...)
+	}
+
+	return nil
+}
+
+// mergePatch applies an RFC 7396 merge patch to target, returning the result.
+// Members of the patch which are null are removed from the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+
+	return t
+}
+
+// patchOperation is one operation of an RFC 6902 JSON Patch.
+type patchOperation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+
+	// Value is nil when the member is absent, and "null" when it is null.
+	Value json.RawMessage `json:"value"`
+
+	path, from []string
+	value      interface{}
+}
+
+// validate checks that the operation is well formed, and parses its pointers
+// and value.
+func (op *patchOperation) validate() error {
+	var err error
+	if op.path, err = parsePointer(op.Path); err != nil {
+		return fmt.Errorf("path: %w", err)
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return fmt.Errorf("%s requires a value", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &op.value); err != nil {
+			return fmt.Errorf("value: %w", err)
+		}
+	case "move", "copy":
+		if op.from, err = parsePointer(op.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+		if op.Op == "move" && isProperPrefix(op.from, op.path) {
+			return fmt.Errorf("cannot move %q into one of its children", op.From)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+
+	return nil
+}
+
+// apply applies the operation to doc, returning the result.
+func (op *patchOperation) apply(doc interface{}) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		return addValue(doc, op.path, copyValue(op.value))
+	case "remove":
+		return removeValue(doc, op.path)
+	case "replace":
+		doc, err := removeValue(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.path, copyValue(op.value))
+	case "move":
+		v, err := getValue(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = removeValue(doc, op.from); err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.path, v)
+	case "copy":
+		v, err := getValue(doc, op.from)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, op.path, copyValue(v))
+	case "test":
+		v, err := getValue(doc, op.path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, op.value) {
+			return nil, fmt.Errorf("test failed at %q", op.Path)
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer parses an RFC 6901 JSON Pointer into its reference tokens.
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("pointer %q must begin with /", s)
+	}
+
+	tokens := strings.Split(s[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(tok, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+func isProperPrefix(prefix, tokens []string) bool {
+	if len(prefix) >= len(tokens) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// arrayIndex parses tok as an index into an array of length n. The index n
+// itself, or "-", is only valid when appending.
+func arrayIndex(tok string, n int, appending bool) (int, error) {
+	if appending && tok == "-" {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || tok != strconv.Itoa(i) {
+		return 0, fmt.Errorf("%q is not an array index", tok)
+	}
+
+	if i > n || (i == n && !appending) {
+		return 0, fmt.Errorf("index %d is out of bounds", i)
+	}
+
+	return i, nil
+}
+
+// getValue returns the value in doc referenced by tokens.
+func getValue(doc interface{}, tokens []string) (interface{}, error) {
+	for _, tok := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[tok]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", tok)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q in a scalar", tok)
+		}
+	}
+
+	return doc, nil
+}
+
+// updateParent replaces the parent of the value referenced by tokens with the
+// result of fn, which is given the parent and the last token.
+func updateParent(doc interface{}, tokens []string, fn func(parent interface{}, tok string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	child, err := getValue(doc, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	if child, err = updateParent(child, tokens[1:], fn); err != nil {
+		return nil, err
+	}
+
+	// The child of an object or array may have been replaced, so it is put
+	// back.
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[tokens[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(tokens[0], len(node), false)
+		node[i] = child
+	}
+
+	return doc, nil
+}
+
+// addValue adds v to doc at tokens, replacing a member of an object or
+// inserting into an array.
+func addValue(doc interface{}, tokens []string, v interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return v, nil
+	}
+
+	return updateParent(doc, tokens, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[tok] = v
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(tok, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = v
+			return node, nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to a scalar", tok)
+		}
+	})
+}
+
+// removeValue removes the value referenced by tokens from doc, which must
+// exist.
+func removeValue(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	return updateParent(doc, tokens, func(parent interface{}, tok string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[tok]; !ok {
+				return nil, fmt.Errorf("member %q does not exist", tok)
+			}
+			delete(node, tok)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(tok, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from a scalar", tok)
+		}
+	})
+}
+
+// copyValue deep copies a generic JSON value, so that later operations on
+// the copy do not affect the original.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			c[k] = copyValue(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,129 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+// problemTypeBase is prefixed to the name of an error's kind to form the type
+// URI of the problem reported for it. The URIs resolve to documentation of
+// each problem type.
+const problemTypeBase = "https://github.com/aisola/go-tasks-api/blob/master/docs/problems.md#"
+
+// problemTypes describes how each kind of error is reported.
+var problemTypes = map[tasks.Kind]struct {
+	status int
+	title  string
+}{
+	tasks.KindInternal:      {http.StatusInternalServerError, "Internal server error"},
+	tasks.KindMalformed:     {http.StatusBadRequest, "Malformed request"},
+	tasks.KindInvalid:       {http.StatusUnprocessableEntity, "Validation failed"},
+	tasks.KindUnsupported:   {http.StatusUnsupportedMediaType, "Unsupported media type"},
+	tasks.KindUnauthorized:  {http.StatusUnauthorized, "Unauthorized"},
+	tasks.KindNotFound:      {http.StatusNotFound, "Not found"},
+	tasks.KindConflict:      {http.StatusConflict, "Conflict"},
+	tasks.KindNotAcceptable: {http.StatusNotAcceptable, "Not acceptable"},
+}
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// InvalidParams is an extension member listing each rejected field.
+	InvalidParams []*invalidParam `json:"invalid_params,omitempty"`
+}
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// problemStatus returns the status code errors of kind are reported with.
+func problemStatus(kind tasks.Kind) int {
+	return problemTypes[kind].status
+}
+
+// malformedQuery creates the error reported for problems with the query
+// parameters of a request.
+func malformedQuery(fields []*tasks.FieldError) error {
+	return &tasks.Error{
+		Kind:    tasks.KindMalformed,
+		Message: "invalid query parameters",
+		Fields:  fields,
+	}
+}
+
+// malformedHeader creates the error reported for problems with the headers
+// of a request.
+func malformedHeader(fields []*tasks.FieldError) error {
+	return &tasks.Error{
+		Kind:    tasks.KindMalformed,
+		Message: "invalid headers",
+		Fields:  fields,
+	}
+}
+
+// respondError logs err, along with fields, and reports it to the client as a
+// problem. Only the messages of *tasks.Error are shown to the client; the
+// details of internal errors are only logged.
+func (h *Handler) respondError(w http.ResponseWriter, r *http.Request, err error, fields ...zap.Field) {
+	fields = append([]zap.Field{
+		zap.String("request_id", middleware.GetReqID(r.Context())),
+		zap.Error(err),
+	}, fields...)
+
+	if tasks.KindOf(err) == tasks.KindInternal {
+		h.logger.Error("request failed", fields...)
+	} else {
+		h.logger.Warn("request failed", fields...)
+	}
+
+	respondProblem(w, r, err)
+}
+
+// respondProblem reports err to the client as a problem, without logging it.
+func respondProblem(w http.ResponseWriter, r *http.Request, err error) {
+	kind := tasks.KindOf(err)
+	pt := problemTypes[kind]
+
+	p := &problem{
+		Type:     problemTypeBase + kind.String(),
+		Title:    pt.title,
+		Status:   pt.status,
+		Instance: middleware.GetReqID(r.Context()),
+	}
+
+	var e *tasks.Error
+	if errors.As(err, &e) && kind != tasks.KindInternal {
+		p.Detail = e.Message
+		for _, f := range e.Fields {
+			p.InvalidParams = append(p.InvalidParams, &invalidParam{
+				Name:   f.Field,
+				Code:   f.Code,
+				Reason: f.Message,
+			})
+		}
+	}
+
+	// Problems are always sent as JSON, whatever the client accepts, as they
+	// may report that nothing it accepts can be sent.
+	out, err := json.Marshal(p)
+	if err != nil {
+		panic("json marshal error on response, this is a bug")
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(out)
+}
@@ -0,0 +1,170 @@
+package taskhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/tasks"
+)
+
+// expander loads a resource related to a task for embedding in the task's
+// representation, e.g. its subtasks. It is given the handler serving the
+// request, so that it reads from the same repository, which may be a
+// transaction within a batch.
+type expander func(h *Handler, r *http.Request, t *tasks.Task) (interface{}, error)
+
+// subtasks lists the subtasks of t, for embedding in its representation.
+func (h *Handler) subtasks(r *http.Request, t *tasks.Task) (interface{}, error) {
+	page, err := h.repo.ListTasks(tasks.ListOptions{Filter: tasks.Filter{ParentID: &t.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*taskResource, len(page.Tasks))
+	for i, s := range page.Tasks {
+		res[i] = h.linkTask(r, newTaskResource(s))
+	}
+	return res, nil
+}
+
+// projection selects which fields of a resource are included in a response
+// and which related resources are embedded in it, as requested with the fields
+// and expand query parameters.
+type projection struct {
+	// fields are the names of the fields to include, or nil for all of them.
+	fields []string
+	expand []string
+}
+
+// parseProjection parses the fields and expand query parameters for resources
+// of the same type as resource. Every problem with the parameters is returned,
+// rather than just the first.
+func (h *Handler) parseProjection(q url.Values, resource interface{}) (*projection, []*tasks.FieldError) {
+	p := &projection{}
+	var errs []*tasks.FieldError
+
+	if s := q.Get("fields"); s != "" {
+		known := jsonFields(reflect.TypeOf(resource))
+		for _, name := range strings.Split(s, ",") {
+			if _, ok := known[name]; !ok {
+				errs = append(errs, &tasks.FieldError{
+					Field:   "fields",
+					Code:    "unknown",
+					Message: fmt.Sprintf("unknown field %q", name),
+				})
+				continue
+			}
+			p.fields = append(p.fields, name)
+		}
+	}
+
+	if s := q.Get("expand"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			if _, ok := h.expanders[name]; !ok {
+				errs = append(errs, &tasks.FieldError{
+					Field:   "expand",
+					Code:    "unknown",
+					Message: fmt.Sprintf("cannot expand unknown resource %q", name),
+				})
+				continue
+			}
+			p.expand = append(p.expand, name)
+		}
+	}
+
+	return p, errs
+}
+
+// columns lists the members of the projections of resources of the same type
+// as resource, in order, for the columns of a table.
+func (p *projection) columns(resource interface{}) []string {
+	columns := p.fields
+	if columns == nil {
+		columns = jsonColumns(reflect.TypeOf(resource))
+	}
+	return append(append([]string(nil), columns...), p.expand...)
+}
+
+// apply projects a task's resource. When neither fields nor expansions were
+// requested the resource is returned as is.
+func (h *Handler) apply(r *http.Request, p *projection, t *tasks.Task, resource interface{}) (interface{}, error) {
+	if p.fields == nil && p.expand == nil {
+		return resource, nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(resource))
+	known := jsonFields(v.Type())
+
+	out := make(map[string]interface{})
+	if p.fields == nil {
+		for name, i := range known {
+			out[name] = v.Field(i).Interface()
+		}
+	} else {
+		for _, name := range p.fields {
+			out[name] = v.Field(known[name]).Interface()
+		}
+
+		// Links are kept whichever fields are selected, so that clients can
+		// always follow them.
+		if i, ok := known["_links"]; ok && !v.Field(i).IsNil() {
+			out["_links"] = v.Field(i).Interface()
+		}
+	}
+
+	for _, name := range p.expand {
+		related, err := h.expanders[name](h, r, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand %s: %w", name, err)
+		}
+		out[name] = related
+	}
+
+	return out, nil
+}
+
+var jsonFieldsCache sync.Map
+
+// jsonFields maps the JSON names of the fields of a struct type, or pointer to
+// one, to the fields' indexes.
+func jsonFields(t reflect.Type) map[string]int {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if cached, ok := jsonFieldsCache.Load(t); ok {
+		return cached.(map[string]int)
+	}
+
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || f.PkgPath != "" {
+			continue
+		}
+		fields[name] = i
+	}
+
+	jsonFieldsCache.Store(t, fields)
+	return fields
+}
+
+// jsonColumns lists the JSON names of the fields of a struct type, or pointer
+// to one, in the order the fields are declared.
+func jsonColumns(t reflect.Type) []string {
+	fields := jsonFields(t)
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return fields[names[i]] < fields[names[j]] })
+
+	return names
+}
@@ -0,0 +1,41 @@
+package taskhttp
+
+import (
+	"time"
+
+	"example.com/tasks"
+)
+
+// taskResource is the representation of a task in responses.
+type taskResource struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Text       string    `json:"text"`
+	IsComplete bool      `json:"is_complete"`
+
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	Priority    string     `json:"priority,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	List        string     `json:"list,omitempty"`
+	ParentID    string     `json:"parent_id,omitempty"`
+
+	// Links are set on the tasks of responses, but not of events.
+	Links links `json:"_links,omitempty"`
+}
+
+func newTaskResource(t *tasks.Task) *taskResource {
+	return &taskResource{
+		ID:         t.ID,
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+		Text:       t.Text,
+		IsComplete: t.IsComplete,
+
+		DueAt:       t.DueAt,
+		Priority:    t.Priority,
+		CompletedAt: t.CompletedAt,
+		List:        t.List,
+		ParentID:    t.ParentID,
+	}
+}
@@ -1,27 +1,113 @@
 package taskhttp
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+
+	"example.com/tasks"
 )
 
 func (h *Handler) routes() {
+	h.base(h.router)
+
+	// Each version of the API is mounted under its own prefix. Versions may
+	// represent tasks differently, but share the repository, the middleware
+	// and the reporting of errors; a new version is added by mounting its
+	// routes alongside the others.
+	h.router.Route("/v1", h.v1Routes)
+
+	// The API was first served from the root, which remains an alias of the
+	// first version until it is sunset.
+	h.router.Group(func(r chi.Router) {
+		r.Use(deprecated("/v1", rootDeprecatedAt, h.rootSunset))
+		h.v1Routes(r)
+	})
+}
+
+// base applies the middleware and fallbacks shared by every route.
+func (h *Handler) base(r chi.Router) {
 	// A good base middleware stack
-	h.router.Use(middleware.RequestID)
-	h.router.Use(middleware.RealIP)
-	h.router.Use(logAccess(h.logger.Named("access")))
-	h.router.Use(middleware.Recoverer)
-
-	// TODO: probably should make this timeout configurable
-	// Set a timeout value on the request context (ctx), that will signal
-	// through ctx.Done() that the request has timed out and further
-	// processing should be stopped.
-	h.router.Use(middleware.Timeout(2 * time.Second))
-
-	h.router.Get("/", h.tasksList())
-	h.router.Post("/", h.tasksCreate())
-	h.router.Get("/{id}", h.tasksRetrieve())
-	h.router.Patch("/{id}", h.tasksUpdate())
-	h.router.Delete("/{id}", h.tasksDelete())
+	r.Use(middleware.RequestID)
+	r.Use(h.trustProxies)
+	r.Use(middleware.RealIP)
+	r.Use(logAccess(h.logger.Named("access")))
+	r.Use(middleware.Recoverer)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		respondProblem(w, r, tasks.NewError(tasks.KindNotFound, "no such resource"))
+	})
+}
+
+// v1Routes registers the routes of the first version of the API on r.
+func (h *Handler) v1Routes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		// TODO: probably should make this timeout configurable
+		// Set a timeout value on the request context (ctx), that will signal
+		// through ctx.Done() that the request has timed out and further
+		// processing should be stopped.
+		r.Use(middleware.Timeout(2 * time.Second))
+		r.Use(acceptable)
+
+		r.Get("/openapi.json", h.openAPIDocument())
+
+		r.Get("/", h.tasksList())
+		r.With(h.idempotent).Post("/", h.tasksCreate())
+		r.With(h.idempotent).Post("/bulk", h.tasksBulkCreate())
+		r.Patch("/bulk", h.tasksBulkUpdate())
+		r.Delete("/bulk", h.tasksBulkDelete())
+
+		if txr, ok := h.repo.(tasks.Transactor); ok {
+			r.With(h.idempotent).Post("/batch", h.tasksBatch(txr, (*Handler).v1Routes))
+		}
+
+		// Webhooks have the server make requests on the caller's behalf, so
+		// managing them is administrative.
+		if h.webhooks != nil && h.adminToken != "" {
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Use(requireBearerToken(h.adminToken))
+
+				r.Get("/", h.webhooksList())
+				r.With(h.idempotent).Post("/", h.webhooksCreate())
+				r.Get("/{webhookID}", h.webhooksRetrieve())
+				r.Patch("/{webhookID}", h.webhooksUpdate())
+				r.Delete("/{webhookID}", h.webhooksDelete())
+				r.Get("/{webhookID}/deliveries", h.webhooksDeliveries())
+			})
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(validateTaskID)
+
+			r.Get("/{id}", h.tasksRetrieve())
+			r.Put("/{id}", h.tasksReplace())
+			r.Patch("/{id}", h.tasksUpdate())
+			r.Delete("/{id}", h.tasksDelete())
+		})
+	})
+
+	// The event stream stays open for as long as the client is connected, so
+	// it is not subject to the request timeout.
+	if h.events != nil {
+		r.Get("/events", h.eventsStream())
+	}
+
+	// Imports, exports and administrative routes may run for much longer
+	// than an API request, so they are not subject to the request timeout
+	// either.
+	r.With(h.refuseIdempotencyKey).Post("/import", h.tasksImport())
+	r.Get("/todo.txt", h.todoExport())
+	r.With(h.refuseIdempotencyKey).Post("/todo.txt", h.todoImport())
+
+	if h.adminToken != "" {
+		r.Group(func(r chi.Router) {
+			r.Use(requireBearerToken(h.adminToken))
+
+			if h.backups != nil {
+				r.Post("/admin/backups", h.adminBackupsCreate())
+			}
+		})
+	}
 }
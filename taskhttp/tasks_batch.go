@@ -0,0 +1,296 @@
+package taskhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+
+	"example.com/tasks"
+)
+
+// maxBatchOperations is the largest number of operations accepted by a batch.
+const maxBatchOperations = 100
+
+// batchMethods are the methods which batch operations may use.
+var batchMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// batchHeaders are the request headers which batch operations may set.
+var batchHeaders = map[string]bool{
+	"Content-Type":  true,
+	"If-None-Match": true,
+}
+
+// batchReference matches a reference to the ID of the task returned by an
+// earlier operation of the same batch, e.g. ${0.id}.
+var batchReference = regexp.MustCompile(`\$\{(\d+)\.id\}`)
+
+// errBatchFailed rolls back a batch in which an operation failed.
+var errBatchFailed = errors.New("batch operation failed")
+
+type batchOperation struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+type batchResult struct {
+	Index    int             `json:"index"`
+	Status   int             `json:"status"`
+	Location string          `json:"location,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+type batchRequest struct {
+	Operations []*batchOperation `json:"operations"`
+}
+
+type batchResponse struct {
+	Operations []*batchResult `json:"operations"`
+}
+
+func (h *Handler) tasksBatch(txr tasks.Transactor, routes func(h *Handler, r chi.Router)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req batchRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if errs := validateBatch(req.Operations); len(errs) > 0 {
+			h.respondError(w, r, tasks.Invalid(errs...))
+			return
+		}
+
+		results := make([]*batchResult, len(req.Operations))
+		ids := make([]string, len(req.Operations))
+		failed := -1
+
+		err := txr.WithTx(func(repo tasks.TaskRepository) error {
+			sub := h.withRepository(repo, routes)
+
+			for i, op := range req.Operations {
+				res := sub.serveOperation(r, op, ids[:i])
+				res.Index = i
+				results[i] = res
+
+				if res.Status >= http.StatusBadRequest {
+					failed = i
+					return errBatchFailed
+				}
+
+				var created struct {
+					ID string `json:"id"`
+				}
+				if json.Unmarshal(res.Body, &created) == nil {
+					ids[i] = created.ID
+				}
+			}
+
+			return nil
+		})
+		if err != nil && err != errBatchFailed {
+			h.respondError(w, r, fmt.Errorf("failed to apply batch: %w", err))
+			return
+		}
+
+		if failed < 0 {
+			respond(w, r, http.StatusOK, &batchResponse{Operations: results})
+			return
+		}
+
+		for i := range results {
+			switch {
+			case i < failed:
+				results[i] = &batchResult{
+					Index:  i,
+					Status: http.StatusFailedDependency,
+					Error:  "rolled back because another operation failed",
+				}
+			case i > failed:
+				results[i] = &batchResult{
+					Index:  i,
+					Status: http.StatusFailedDependency,
+					Error:  "not attempted because another operation failed",
+				}
+			}
+		}
+
+		respond(w, r, http.StatusConflict, &batchResponse{Operations: results})
+	}
+}
+
+// validateBatch checks that every operation can be served.
+func validateBatch(ops []*batchOperation) []*tasks.FieldError {
+	if len(ops) == 0 {
+		return []*tasks.FieldError{{Field: "operations", Code: "required", Message: "is required"}}
+	}
+
+	if len(ops) > maxBatchOperations {
+		return []*tasks.FieldError{{
+			Field:   "operations",
+			Code:    "max_items",
+			Message: fmt.Sprintf("at most %d operations may be sent at once", maxBatchOperations),
+		}}
+	}
+
+	var errs []*tasks.FieldError
+	for i, op := range ops {
+		field := fmt.Sprintf("operations[%d]", i)
+
+		if !batchMethods[op.Method] {
+			errs = append(errs, &tasks.FieldError{
+				Field:   field + ".method",
+				Code:    "enum",
+				Message: "must be one of DELETE, GET, PATCH, POST or PUT",
+			})
+		}
+
+		// The path is checked as it will be routed, so that escapes, dot
+		// segments and fragments cannot hide a nested batch.
+		u, err := url.Parse(op.Path)
+		switch {
+		case !strings.HasPrefix(op.Path, "/") || strings.HasPrefix(op.Path, "//"):
+			errs = append(errs, &tasks.FieldError{Field: field + ".path", Code: "format", Message: "must begin with a single /"})
+		case err != nil:
+			errs = append(errs, &tasks.FieldError{Field: field + ".path", Code: "format", Message: "must be a valid path"})
+		case u.Fragment != "":
+			errs = append(errs, &tasks.FieldError{Field: field + ".path", Code: "format", Message: "cannot have a fragment"})
+		case path.Clean(u.Path) == "/batch":
+			errs = append(errs, &tasks.FieldError{Field: field + ".path", Code: "nested", Message: "cannot be another batch"})
+		}
+
+		for name := range op.Headers {
+			if !batchHeaders[http.CanonicalHeaderKey(name)] {
+				errs = append(errs, &tasks.FieldError{
+					Field:   field + ".headers." + name,
+					Code:    "unknown",
+					Message: "cannot be set on a batch operation",
+				})
+			}
+		}
+	}
+
+	return errs
+}
+
+// withRepository returns a copy of the handler which serves the routes of a
+// version of the API using repo. Routes are registered on the copy, so that
+// they and the expansions they make read and write through repo. Routes which
+// would escape repo, such as administration, webhooks and stored idempotent
+// responses, or which never finish, such as the event stream, are left out.
+func (h *Handler) withRepository(repo tasks.TaskRepository, routes func(h *Handler, r chi.Router)) *Handler {
+	sub := *h
+	sub.router = chi.NewRouter()
+	sub.repo = repo
+	sub.adminToken = ""
+	sub.idempotency = nil
+	sub.events = nil
+	sub.webhooks = nil
+	sub.base(sub.router)
+	routes(&sub, sub.router)
+
+	return &sub
+}
+
+// serveOperation serves one operation of a batch made by r, after replacing
+// references to the ids of earlier operations.
+func (h *Handler) serveOperation(r *http.Request, op *batchOperation, ids []string) *batchResult {
+	var unresolved []string
+	resolve := func(s string) string {
+		return batchReference.ReplaceAllStringFunc(s, func(ref string) string {
+			i, _ := strconv.Atoi(batchReference.FindStringSubmatch(ref)[1])
+			if i >= len(ids) || ids[i] == "" {
+				unresolved = append(unresolved, ref)
+				return ref
+			}
+			return ids[i]
+		})
+	}
+
+	path := resolve(op.Path)
+	body := resolve(string(op.Body))
+	if len(unresolved) > 0 {
+		return &batchResult{
+			Status: http.StatusUnprocessableEntity,
+			Error:  fmt.Sprintf("%s does not refer to a task created earlier in the batch", unresolved[0]),
+		}
+	}
+
+	// The batch's route context must not leak into the operation, or it
+	// would be routed as though it were mounted below the batch. Links are
+	// made as though the operation were served where the batch was.
+	ctx := context.WithValue(withLinkBase(r.Context(), linkBase(r)), chi.RouteCtxKey, nil)
+	req, err := http.NewRequest(op.Method, path, strings.NewReader(body))
+	if err != nil {
+		return &batchResult{Status: http.StatusBadRequest, Error: "malformed operation: " + err.Error()}
+	}
+	req = req.WithContext(ctx)
+
+	for name, value := range op.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+
+	rw := &bufferedWriter{header: make(http.Header)}
+	h.ServeHTTP(rw, req)
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	res := &batchResult{
+		Status:   rw.status,
+		Location: rw.header.Get("Location"),
+	}
+	if out := bytes.TrimSpace(rw.body.Bytes()); len(out) > 0 {
+		if json.Valid(out) {
+			res.Body = out
+		} else {
+			res.Body, _ = json.Marshal(string(out))
+		}
+	}
+
+	return res
+}
+
+// bufferedWriter is an http.ResponseWriter which keeps the response in memory.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
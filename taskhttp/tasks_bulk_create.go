@@ -0,0 +1,65 @@
+package taskhttp
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/tasks"
+)
+
+type bulkCreateItem struct {
+	Text string `json:"text"`
+}
+
+type bulkCreateRequest struct {
+	Items []*bulkCreateItem `json:"items"`
+}
+
+func (h *Handler) tasksBulkCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modeName, mode, err := bulkMode(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		var req bulkCreateRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if len(req.Items) > maxBulkItems {
+			h.respondError(w, r, tooManyItems("items"))
+			return
+		}
+
+		var invalidFields []*tasks.FieldError
+		for i, item := range req.Items {
+			if item == nil {
+				invalidFields = append(invalidFields, &tasks.FieldError{Field: fmt.Sprintf("items[%d]", i), Code: "type", Message: "must be an object"})
+			} else {
+				invalidFields = append(invalidFields, validateText(fmt.Sprintf("items[%d].text", i), item.Text, true)...)
+			}
+		}
+		if len(invalidFields) > 0 {
+			h.respondError(w, r, tasks.Invalid(invalidFields...))
+			return
+		}
+
+		ts := make([]*tasks.Task, len(req.Items))
+		for i, item := range req.Items {
+			ts[i] = &tasks.Task{Text: item.Text}
+		}
+
+		errs, err := h.repo.CreateTasks(ts, mode)
+		if err != nil && err != tasks.ErrBatchAborted {
+			h.respondError(w, r, fmt.Errorf("failed to create tasks: %w", err))
+			return
+		}
+
+		h.respondBulk(w, r, modeName, http.StatusCreated, errs, err, func(i int) interface{} {
+			return h.linkTask(r, newTaskResource(ts[i]))
+		})
+	}
+}
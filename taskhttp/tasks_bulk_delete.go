@@ -0,0 +1,41 @@
+package taskhttp
+
+import (
+	"fmt"
+	"net/http"
+
+	"example.com/tasks"
+)
+
+type bulkDeleteRequest struct {
+	IDs []string `json:"ids"`
+}
+
+func (h *Handler) tasksBulkDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modeName, mode, err := bulkMode(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		var req bulkDeleteRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if len(req.IDs) > maxBulkItems {
+			h.respondError(w, r, tooManyItems("ids"))
+			return
+		}
+
+		_, errs, err := h.repo.DeleteTasks(req.IDs, mode)
+		if err != nil && err != tasks.ErrBatchAborted {
+			h.respondError(w, r, fmt.Errorf("failed to delete tasks: %w", err))
+			return
+		}
+
+		h.respondBulk(w, r, modeName, http.StatusNoContent, errs, err, nil)
+	}
+}
@@ -0,0 +1,91 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/tasks"
+)
+
+// bulkUpdateItem is a merge patch to the task with ID, as PATCH /{id}
+// applies. Fields which are absent are left unchanged.
+type bulkUpdateItem struct {
+	ID         string     `json:"id"`
+	Text       *string    `json:"text"`
+	IsComplete *bool      `json:"is_complete"`
+	DueAt      *time.Time `json:"due_at"`
+
+	// patch is the item as it was sent.
+	patch json.RawMessage
+}
+
+// UnmarshalJSON decodes an item, keeping it to be applied as a merge patch.
+func (i *bulkUpdateItem) UnmarshalJSON(data []byte) error {
+	type item bulkUpdateItem
+	if err := json.Unmarshal(data, (*item)(i)); err != nil {
+		return err
+	}
+
+	i.patch = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+type bulkUpdateRequest struct {
+	Items []*bulkUpdateItem `json:"items"`
+}
+
+func (h *Handler) tasksBulkUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modeName, mode, err := bulkMode(r)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		var req bulkUpdateRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		if len(req.Items) > maxBulkItems {
+			h.respondError(w, r, tooManyItems("items"))
+			return
+		}
+
+		var invalidFields []*tasks.FieldError
+		for i, item := range req.Items {
+			if item == nil {
+				invalidFields = append(invalidFields, &tasks.FieldError{Field: fmt.Sprintf("items[%d]", i), Code: "type", Message: "must be an object"})
+			} else if item.Text != nil {
+				invalidFields = append(invalidFields, validateText(fmt.Sprintf("items[%d].text", i), *item.Text, true)...)
+			}
+		}
+		if len(invalidFields) > 0 {
+			h.respondError(w, r, tasks.Invalid(invalidFields...))
+			return
+		}
+
+		ps := make([]*tasks.TaskPatch, len(req.Items))
+		for i, item := range req.Items {
+			patch, err := h.parsePatch(mergePatchType, item.patch)
+			if err != nil {
+				h.respondError(w, r, err)
+				return
+			}
+			ps[i] = &tasks.TaskPatch{ID: item.ID, Patch: patch}
+		}
+
+		updated, errs, err := h.repo.PatchTasks(ps, mode)
+		if err != nil && err != tasks.ErrBatchAborted {
+			h.respondError(w, r, fmt.Errorf("failed to update tasks: %w", err))
+			return
+		}
+
+		h.respondBulk(w, r, modeName, http.StatusOK, errs, err, func(i int) interface{} {
+			return h.linkTask(r, newTaskResource(updated[i]))
+		})
+	}
+}
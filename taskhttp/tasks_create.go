@@ -1,57 +1,45 @@
 package taskhttp
 
 import (
+	"fmt"
 	"net/http"
-	"time"
-
-	"github.com/go-chi/chi/middleware"
-	"go.uber.org/zap"
 
 	"example.com/tasks"
 )
 
+type createTaskRequest struct {
+	Text     string `json:"text"`
+	List     string `json:"list"`
+	ParentID string `json:"parent_id"`
+}
+
 func (h *Handler) tasksCreate() http.HandlerFunc {
-	type request struct {
-		Text string `json:"text"`
-	}
-	type response struct {
-		ID         string    `json:"id"`
-		CreatedAt  time.Time `json:"created_at"`
-		UpdatedAt  time.Time `json:"updated_at"`
-		Text       string    `json:"text"`
-		IsComplete bool      `json:"is_complete"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		requestID := middleware.GetReqID(r.Context())
-		var req request
-		if err := decode(r, &req); err != nil {
-			h.logger.Error("failed to decode request",
-				zap.String("request_id", requestID),
-				zap.Error(err),
-			)
-			internalServerError(w)
+		var req createTaskRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		errs := validateText("text", req.Text, true)
+		errs = append(errs, validateText("list", req.List, false)...)
+		errs = append(errs, validateParent("parent_id", req.ParentID, "")...)
+		if len(errs) > 0 {
+			h.respondError(w, r, tasks.Invalid(errs...))
 			return
 		}
 
 		task := &tasks.Task{
-			Text: req.Text,
+			Text:     req.Text,
+			List:     req.List,
+			ParentID: req.ParentID,
 		}
 
 		if err := h.repo.CreateTask(task); err != nil {
-			h.logger.Error("failed to create task",
-				zap.String("request_id", requestID),
-				zap.Error(err),
-			)
-			internalServerError(w)
+			h.respondError(w, r, fmt.Errorf("failed to create task: %w", err))
 			return
 		}
 
-		respondJSON(w, http.StatusCreated, &response{
-			ID:         task.ID,
-			CreatedAt:  task.CreatedAt,
-			UpdatedAt:  task.UpdatedAt,
-			Text:       task.Text,
-			IsComplete: task.IsComplete,
-		})
+		respond(w, r, http.StatusCreated, h.linkTask(r, newTaskResource(task)))
 	}
 }
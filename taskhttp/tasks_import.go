@@ -0,0 +1,618 @@
+package taskhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/markdown"
+)
+
+const (
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+
+	// defaultImportBatchSize and maxImportBatchSize bound the number of rows
+	// imported in each transaction.
+	defaultImportBatchSize = 500
+	maxImportBatchSize     = 5000
+
+	// maxImportLine is the longest line of an NDJSON import, in bytes.
+	maxImportLine = 1 << 20
+)
+
+// importFields are the fields of a task which may be imported. Each is read
+// from the column, or NDJSON member, of the same name unless it is mapped to
+// another.
+var importFields = map[string]bool{
+	"id":           true,
+	"text":         true,
+	"is_complete":  true,
+	"due_at":       true,
+	"priority":     true,
+	"created_at":   true,
+	"updated_at":   true,
+	"completed_at": true,
+	"list":         true,
+	"parent_id":    true,
+}
+
+// importParams are the query parameters of an import.
+var importParams = map[string]bool{
+	"dry_run":    true,
+	"batch_size": true,
+	"map":        true,
+}
+
+type importOptions struct {
+	dryRun    bool
+	batchSize int
+
+	// columns maps each field to the column it is read from.
+	columns map[string]string
+
+	// mapped are the fields mapped by the map parameter, whose columns must
+	// be present.
+	mapped map[string]bool
+}
+
+// parseImportOptions parses the query parameters of an import, which must be
+// among params. Every problem with the parameters is returned, rather than
+// just the first.
+func parseImportOptions(q url.Values, params map[string]bool) (*importOptions, []*tasks.FieldError) {
+	opts := &importOptions{
+		batchSize: defaultImportBatchSize,
+		columns:   make(map[string]string),
+		mapped:    make(map[string]bool),
+	}
+	for field := range importFields {
+		opts.columns[field] = field
+	}
+
+	var errs []*tasks.FieldError
+	reject := func(param, code, format string, args ...interface{}) {
+		errs = append(errs, &tasks.FieldError{
+			Field:   param,
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for name := range q {
+		if !params[name] {
+			reject(name, "unknown", "unknown query parameter %q", name)
+		}
+	}
+
+	if s := q.Get("dry_run"); s != "" {
+		dryRun, err := strconv.ParseBool(s)
+		if err != nil {
+			reject("dry_run", "type", "dry_run must be a boolean, got %q", s)
+		}
+		opts.dryRun = dryRun
+	}
+
+	if s := q.Get("batch_size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			reject("batch_size", "type", "batch_size must be a positive integer, got %q", s)
+		} else if size > maxImportBatchSize {
+			size = maxImportBatchSize
+		}
+		opts.batchSize = size
+	}
+
+	if s := q.Get("map"); s != "" {
+		for _, pair := range strings.Split(s, ",") {
+			parts := strings.SplitN(pair, ":", 2)
+			switch {
+			case len(parts) != 2 || parts[1] == "":
+				reject("map", "format", "must be comma separated field:column pairs, got %q", pair)
+			case !importFields[parts[0]]:
+				reject("map", "unknown", "cannot map unknown field %q", parts[0])
+			default:
+				opts.columns[parts[0]] = parts[1]
+				opts.mapped[parts[0]] = true
+			}
+		}
+	}
+
+	return opts, errs
+}
+
+// importRow is one row of an import. Rows are numbered from 1, excluding the
+// header of CSV; the rows of NDJSON and Markdown are numbered by line.
+type importRow struct {
+	number int
+
+	// values holds the value of each field present in the row. An empty
+	// value is treated as absent.
+	values map[string]string
+
+	// task is set instead of values by formats which parse rows into tasks
+	// themselves.
+	task *tasks.Task
+
+	// err is set if the row could not be parsed.
+	err error
+}
+
+// rowReader reads the rows of an import one at a time. It returns io.EOF
+// after the last row.
+type rowReader interface {
+	next() (*importRow, error)
+}
+
+type csvRows struct {
+	reader *csv.Reader
+	number int
+
+	// index maps each field to the index of its column.
+	index map[string]int
+}
+
+// newCSVRows reads the header of a CSV import and finds the column of each
+// field in it.
+func newCSVRows(body io.Reader, opts *importOptions) (*csvRows, error) {
+	cr := csv.NewReader(body)
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, tasks.NewError(tasks.KindMalformed, "csv has no header row")
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, tasks.NewError(tasks.KindMalformed, "malformed csv header: "+parseErr.Error())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+	cr.FieldsPerRecord = len(header)
+
+	// Spreadsheets often begin their exports with a byte order mark.
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	rows := &csvRows{reader: cr, index: make(map[string]int)}
+	for i, column := range header {
+		for field, c := range opts.columns {
+			if c == column {
+				rows.index[field] = i
+			}
+		}
+	}
+
+	var errs []*tasks.FieldError
+	for field := range opts.mapped {
+		if _, ok := rows.index[field]; !ok {
+			errs = append(errs, &tasks.FieldError{
+				Field:   "map",
+				Code:    "unknown",
+				Message: fmt.Sprintf("there is no column %q for %s", opts.columns[field], field),
+			})
+		}
+	}
+	if _, ok := rows.index["text"]; !ok && !opts.mapped["text"] {
+		errs = append(errs, &tasks.FieldError{
+			Field:   "text",
+			Code:    "required",
+			Message: "there is no text column",
+		})
+	}
+	if len(errs) > 0 {
+		return nil, tasks.Invalid(errs...)
+	}
+
+	return rows, nil
+}
+
+func (rows *csvRows) next() (*importRow, error) {
+	record, err := rows.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+
+	rows.number++
+	row := &importRow{number: rows.number, values: make(map[string]string)}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.err = tasks.NewError(tasks.KindMalformed, "malformed row: "+parseErr.Err.Error())
+		return row, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv: %w", err)
+	}
+
+	for field, i := range rows.index {
+		row.values[field] = record[i]
+	}
+	return row, nil
+}
+
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	number  int
+	columns map[string]string
+}
+
+func newNDJSONRows(body io.Reader, opts *importOptions) *ndjsonRows {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), maxImportLine)
+
+	return &ndjsonRows{scanner: sc, columns: opts.columns}
+}
+
+func (rows *ndjsonRows) next() (*importRow, error) {
+	for rows.scanner.Scan() {
+		rows.number++
+
+		line := bytes.TrimSpace(rows.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := &importRow{number: rows.number, values: make(map[string]string)}
+
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(line, &members); err != nil {
+			row.err = tasks.NewError(tasks.KindMalformed, "malformed row: each line must be a json object")
+			return row, nil
+		}
+
+		for field, column := range rows.columns {
+			if raw, ok := members[column]; ok {
+				row.values[field] = cell(raw)
+			}
+		}
+		return row, nil
+	}
+
+	if err := rows.scanner.Err(); err == bufio.ErrTooLong {
+		return nil, tasks.NewError(tasks.KindMalformed,
+			fmt.Sprintf("line %d is longer than %d bytes", rows.number+1, maxImportLine))
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read ndjson: %w", err)
+	}
+
+	return nil, io.EOF
+}
+
+// task converts the values of row to a task, reporting problems against the
+// columns they were read from.
+func (opts *importOptions) task(row *importRow) (*tasks.Task, []*tasks.FieldError) {
+	var errs []*tasks.FieldError
+	reject := func(field, code, format string, args ...interface{}) {
+		errs = append(errs, &tasks.FieldError{
+			Field:   opts.columns[field],
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	t := &tasks.Task{
+		ID:       row.values["id"],
+		Text:     row.values["text"],
+		List:     row.values["list"],
+		ParentID: row.values["parent_id"],
+	}
+
+	errs = append(errs, validateText(opts.columns["text"], t.Text, true)...)
+	errs = append(errs, validateText(opts.columns["list"], t.List, false)...)
+
+	if t.ID != "" && !tasks.ValidTaskID(t.ID) {
+		reject("id", "format", "must be a task ID, got %q", t.ID)
+	}
+	if t.ParentID != "" && !tasks.ValidTaskID(t.ParentID) {
+		reject("parent_id", "format", "must be a task ID, got %q", t.ParentID)
+	}
+
+	if s := row.values["is_complete"]; s != "" {
+		complete, err := strconv.ParseBool(s)
+		if err != nil {
+			reject("is_complete", "type", "must be a boolean, got %q", s)
+		}
+		t.IsComplete = complete
+	}
+
+	if s := row.values["priority"]; s != "" {
+		if !tasks.ValidPriority(s) {
+			reject("priority", "format", "must be a letter from A to Z, got %q", s)
+		}
+		t.Priority = s
+	}
+
+	for field, dst := range map[string]**time.Time{"due_at": &t.DueAt, "completed_at": &t.CompletedAt} {
+		if s := row.values[field]; s != "" {
+			at, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				reject(field, "format", "must be an RFC 3339 time, got %q", s)
+			}
+			at = at.UTC()
+			*dst = &at
+		}
+	}
+
+	for field, dst := range map[string]*time.Time{"created_at": &t.CreatedAt, "updated_at": &t.UpdatedAt} {
+		if s := row.values[field]; s != "" {
+			at, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				reject(field, "format", "must be an RFC 3339 time, got %q", s)
+			}
+			*dst = at.UTC()
+		}
+	}
+
+	return t, errs
+}
+
+// importRowResult reports a row which was not imported.
+type importRowResult struct {
+	Row           int             `json:"row"`
+	Status        int             `json:"status"`
+	Error         string          `json:"error"`
+	InvalidParams []*invalidParam `json:"invalid_params,omitempty"`
+}
+
+type importSummary struct {
+	Rows      int  `json:"rows"`
+	Succeeded int  `json:"succeeded"`
+	Failed    int  `json:"failed"`
+	DryRun    bool `json:"dry_run"`
+
+	// Error is set if the import stopped before the last row. Rows which
+	// were not reported as failed before then were imported.
+	Error string `json:"error,omitempty"`
+}
+
+type importSummaryLine struct {
+	Summary *importSummary `json:"summary"`
+}
+
+func (h *Handler) tasksImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, errs := parseImportOptions(r.URL.Query(), importParams)
+		if len(errs) > 0 {
+			h.respondError(w, r, malformedQuery(errs))
+			return
+		}
+
+		mt, err := bodyType(r, csvType, ndjsonType, markdownType)
+		if err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		var rows rowReader
+		switch mt {
+		case csvType:
+			if rows, err = newCSVRows(r.Body, opts); err != nil {
+				h.respondError(w, r, err)
+				return
+			}
+		case markdownType:
+			if len(opts.mapped) > 0 {
+				h.respondError(w, r, malformedQuery([]*tasks.FieldError{{
+					Field:   "map",
+					Code:    "unsupported",
+					Message: "cannot map the fields of " + markdownType,
+				}}))
+				return
+			}
+			rows = &markdownRows{reader: markdown.NewReader(r.Body)}
+		default:
+			rows = newNDJSONRows(r.Body, opts)
+		}
+
+		h.runImport(w, r, opts, rows)
+	}
+}
+
+// runImport imports rows and responds with the report of the rows which were
+// not imported, followed by the summary of the import.
+func (h *Handler) runImport(w http.ResponseWriter, r *http.Request, opts *importOptions, rows rowReader) {
+	// The report is kept on disk until the whole body has been read, as the
+	// body may not be readable once the response has begun. It may be as long
+	// as the body.
+	report, err := ioutil.TempFile("", "tasks-import-")
+	if err != nil {
+		h.respondError(w, r, fmt.Errorf("failed to create import report: %w", err))
+		return
+	}
+	defer os.Remove(report.Name())
+	defer report.Close()
+
+	buf := bufio.NewWriter(report)
+	imp := &importer{
+		h:       h,
+		r:       r,
+		opts:    opts,
+		report:  json.NewEncoder(buf),
+		summary: &importSummary{DryRun: opts.dryRun},
+	}
+	imp.run(rows)
+
+	if err := buf.Flush(); err != nil {
+		h.respondError(w, r, fmt.Errorf("failed to write import report: %w", err))
+		return
+	}
+	if _, err := report.Seek(0, io.SeekStart); err != nil {
+		h.respondError(w, r, fmt.Errorf("failed to read import report: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", ndjsonType)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, report)
+	json.NewEncoder(w).Encode(&importSummaryLine{Summary: imp.summary})
+}
+
+// importer imports rows in batches, writing a line to its report for each row
+// which is not imported.
+type importer struct {
+	h       *Handler
+	r       *http.Request
+	opts    *importOptions
+	report  *json.Encoder
+	summary *importSummary
+
+	batch   []*tasks.Task
+	numbers []int
+}
+
+// run imports every row, stopping early if a row cannot be read or a batch
+// cannot be stored.
+func (imp *importer) run(rows rowReader) {
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			imp.stop(err)
+			return
+		}
+		imp.summary.Rows++
+
+		if row.err != nil {
+			imp.fail(row.number, row.err)
+			continue
+		}
+
+		var (
+			t    *tasks.Task
+			errs []*tasks.FieldError
+		)
+		if row.task != nil {
+			t = row.task
+			errs = append(validateText("text", t.Text, true), validateText("list", t.List, false)...)
+		} else {
+			t, errs = imp.opts.task(row)
+		}
+		if len(errs) > 0 {
+			imp.fail(row.number, tasks.Invalid(errs...))
+			continue
+		}
+
+		if imp.opts.dryRun {
+			imp.check(row.number, t)
+			continue
+		}
+
+		imp.batch = append(imp.batch, t)
+		imp.numbers = append(imp.numbers, row.number)
+		if len(imp.batch) == imp.opts.batchSize && !imp.flush() {
+			return
+		}
+	}
+
+	imp.flush()
+}
+
+// check reports whether a task would be imported by a dry run. Only the IDs
+// of tasks which already exist are checked against the repository.
+func (imp *importer) check(number int, t *tasks.Task) {
+	if t.ID == "" {
+		imp.summary.Succeeded++
+		return
+	}
+
+	switch _, err := imp.h.repo.RetrieveTask(t.ID); {
+	case err == nil:
+		imp.fail(number, tasks.ErrTaskExists)
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		imp.summary.Succeeded++
+	default:
+		imp.fail(number, fmt.Errorf("failed to retrieve task: %w", err))
+	}
+}
+
+// flush imports the current batch in one transaction. It returns false if the
+// batch could not be stored, after stopping the import.
+func (imp *importer) flush() bool {
+	if len(imp.batch) == 0 {
+		return true
+	}
+	defer func() {
+		imp.batch, imp.numbers = imp.batch[:0], imp.numbers[:0]
+	}()
+
+	errs, err := imp.h.repo.ImportTasks(imp.batch, tasks.BatchBestEffort)
+	if err != nil {
+		for _, number := range imp.numbers {
+			imp.write(&importRowResult{Row: number, Status: http.StatusInternalServerError, Error: "internal server error"})
+			imp.summary.Failed++
+		}
+		imp.stop(fmt.Errorf("failed to import tasks: %w", err))
+		return false
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			imp.fail(imp.numbers[i], err)
+		} else {
+			imp.summary.Succeeded++
+		}
+	}
+	return true
+}
+
+// fail reports a row which was not imported because of err.
+func (imp *importer) fail(number int, err error) {
+	imp.summary.Failed++
+
+	res := &importRowResult{Row: number}
+	var e *tasks.Error
+	if errors.As(err, &e) && e.Kind != tasks.KindInternal {
+		res.Status = problemStatus(e.Kind)
+		res.Error = e.Message
+		for _, f := range e.Fields {
+			res.InvalidParams = append(res.InvalidParams, &invalidParam{Name: f.Field, Code: f.Code, Reason: f.Message})
+		}
+	} else {
+		imp.h.logger.Error("import row failed",
+			zap.String("request_id", middleware.GetReqID(imp.r.Context())),
+			zap.Int("row", number),
+			zap.Error(err),
+		)
+		res.Status = http.StatusInternalServerError
+		res.Error = "internal server error"
+	}
+
+	imp.write(res)
+}
+
+// stop records that the import stopped early because of err.
+func (imp *importer) stop(err error) {
+	var e *tasks.Error
+	if errors.As(err, &e) && e.Kind != tasks.KindInternal {
+		imp.summary.Error = e.Message
+		return
+	}
+
+	imp.h.logger.Error("import stopped",
+		zap.String("request_id", middleware.GetReqID(imp.r.Context())),
+		zap.Error(err),
+	)
+	imp.summary.Error = "internal server error"
+}
+
+func (imp *importer) write(res *importRowResult) {
+	// Write errors are found when the report is flushed.
+	imp.report.Encode(res)
+}
@@ -1,53 +1,245 @@
 package taskhttp
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
+
+	"example.com/tasks"
 )
 
+const (
+	// defaultPageSize is the number of tasks listed when no limit is given.
+	defaultPageSize = 50
+
+	// maxPageSize is the largest number of tasks listed at once, whatever
+	// limit is given.
+	maxPageSize = 100
+)
+
+type taskListResponse struct {
+	Length     int           `json:"length"`
+	Total      *int          `json:"total,omitempty"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	PrevCursor string        `json:"prev_cursor,omitempty"`
+	Items      []interface{} `json:"items" openapi:"Task"`
+	Links      links         `json:"_links,omitempty"`
+
+	// columns are the members of the items written as the columns of a
+	// table, or nil for every member of a task.
+	columns []string
+}
+
 func (h *Handler) tasksList() http.HandlerFunc {
-	type responseTask struct {
-		ID         string    `json:"id"`
-		CreatedAt  time.Time `json:"created_at"`
-		UpdatedAt  time.Time `json:"updated_at"`
-		Text       string    `json:"text"`
-		IsComplete bool      `json:"is_complete"`
-	}
-	type response struct {
-		Length int             `json:"length"`
-		Items  []*responseTask `json:"items"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		requestID := middleware.GetReqID(r.Context())
-		ts, err := h.repo.ListTasks()
+		opts, errs := listOptions(r.URL.Query())
+		p, projectionErrs := h.parseProjection(r.URL.Query(), &taskResource{})
+		if errs = append(errs, projectionErrs...); len(errs) > 0 {
+			h.respondError(w, r, malformedQuery(errs))
+			return
+		}
+
+		page, err := h.repo.ListTasks(opts)
 		if err != nil {
-			h.logger.Error("failed to find task",
-				zap.String("request_id", requestID),
-				zap.Error(err),
-			)
-			internalServerError(w)
+			h.respondError(w, r, fmt.Errorf("failed to list tasks: %w", err))
 			return
 		}
 
-		l := len(ts)
-		res := &response{
-			Length: l,
-			Items:  make([]*responseTask, l),
+		l := len(page.Tasks)
+		res := &taskListResponse{
+			Length:  l,
+			Items:   make([]interface{}, l),
+			columns: p.columns(&taskResource{}),
+		}
+
+		if opts.Count {
+			res.Total = &page.Total
 		}
 
-		for i, t := range ts {
-			res.Items[i] = &responseTask{
-				ID:         t.ID,
-				CreatedAt:  t.CreatedAt,
-				UpdatedAt:  t.UpdatedAt,
-				Text:       t.Text,
-				IsComplete: t.IsComplete,
+		for i, t := range page.Tasks {
+			if res.Items[i], err = h.apply(r, p, t, h.linkTask(r, newTaskResource(t))); err != nil {
+				h.respondError(w, r, fmt.Errorf("failed to project task: %w", err), zap.String("task_id", t.ID))
+				return
 			}
 		}
 
-		respondJSON(w, http.StatusOK, res)
+		if page.Next != nil {
+			res.NextCursor = page.Next.Encode()
+		}
+		if page.Prev != nil {
+			res.PrevCursor = page.Prev.Encode()
+		}
+		res.Links = h.linkPage(r, res.NextCursor, res.PrevCursor)
+
+		// The Link header repeats the links to the pages either side, which
+		// are made under the same base as those in the body.
+		var links []string
+		for _, rel := range []string{"next", "prev"} {
+			if l := res.Links[rel]; l != nil {
+				links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, l.Href, rel))
+			}
+		}
+
+		if len(links) > 0 {
+			// Links set by middleware, such as to a successor version, are
+			// kept in the same header.
+			if prev := w.Header().Get("Link"); prev != "" {
+				links = append([]string{prev}, links...)
+			}
+			w.Header().Set("Link", strings.Join(links, ", "))
+		}
+
+		// Tasks may have been deleted since any of those listed were last
+		// modified, so only the ETag validates the collection.
+		respondConditional(w, r, http.StatusOK, res, time.Time{})
+	}
+}
+
+// listParams are the query parameters understood by tasksList.
+var listParams = map[string]bool{
+	"limit":          true,
+	"cursor":         true,
+	"count":          true,
+	"fields":         true,
+	"expand":         true,
+	"sort":           true,
+	"is_complete":    true,
+	"created_after":  true,
+	"created_before": true,
+	"updated_after":  true,
+	"updated_before": true,
+	"text_contains":  true,
+	"list":           true,
+	"parent_id":      true,
+	"format":         true,
+}
+
+// listOptions parses the query parameters of a listing. Every problem with
+// the parameters is returned, rather than just the first.
+func listOptions(q url.Values) (tasks.ListOptions, []*tasks.FieldError) {
+	opts := tasks.ListOptions{Limit: defaultPageSize}
+	var errs []*tasks.FieldError
+	reject := func(param, code, format string, args ...interface{}) {
+		errs = append(errs, &tasks.FieldError{
+			Field:   param,
+			Code:    code,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for name := range q {
+		if !listParams[name] {
+			reject(name, "unknown", "unknown query parameter %q", name)
+		}
 	}
+
+	if s := q.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			reject("limit", "type", "limit must be a positive integer, got %q", s)
+		} else if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		opts.Limit = limit
+	}
+
+	if s := q.Get("count"); s != "" {
+		count, err := strconv.ParseBool(s)
+		if err != nil {
+			reject("count", "type", "count must be a boolean, got %q", s)
+		}
+		opts.Count = count
+	}
+
+	if s := q.Get("sort"); s != "" {
+		seen := make(map[tasks.SortField]bool)
+		for _, field := range strings.Split(s, ",") {
+			key := tasks.SortKey{Field: tasks.SortField(strings.TrimPrefix(field, "-"))}
+			key.Desc = strings.HasPrefix(field, "-")
+
+			switch {
+			case !tasks.SortFields[key.Field]:
+				reject("sort", "unknown", "cannot sort by unknown field %q", key.Field)
+			case seen[key.Field]:
+				reject("sort", "duplicate", "cannot sort by %q more than once", key.Field)
+			default:
+				seen[key.Field] = true
+				opts.Sort = append(opts.Sort, key)
+			}
+		}
+	}
+
+	// The cursor has to match the sort, so it is decoded after it.
+	if s := q.Get("cursor"); s != "" {
+		c, err := tasks.DecodeCursor(s, opts.Sort)
+		if err != nil {
+			reject("cursor", "invalid", "%s", err)
+		}
+		opts.Cursor = c
+	}
+
+	if s := q.Get("is_complete"); s != "" {
+		isComplete, err := strconv.ParseBool(s)
+		if err != nil {
+			reject("is_complete", "type", "is_complete must be a boolean, got %q", s)
+		}
+		opts.Filter.IsComplete = &isComplete
+	}
+
+	times := []struct {
+		name string
+		dest *time.Time
+	}{
+		{"created_after", &opts.Filter.CreatedAfter},
+		{"created_before", &opts.Filter.CreatedBefore},
+		{"updated_after", &opts.Filter.UpdatedAfter},
+		{"updated_before", &opts.Filter.UpdatedBefore},
+	}
+	for _, t := range times {
+		if s := q.Get(t.name); s != "" {
+			v, err := parseQueryTime(s)
+			if err != nil {
+				reject(t.name, "type", "%s must be an RFC 3339 timestamp, got %q", t.name, s)
+			}
+			*t.dest = v
+		}
+	}
+
+	opts.Filter.TextContains = q.Get("text_contains")
+
+	// An empty list or parent ID is a filter too, for the tasks on no list
+	// or which are not subtasks, so they are told apart from absent ones.
+	if _, ok := q["list"]; ok {
+		list := q.Get("list")
+		opts.Filter.List = &list
+	}
+	if _, ok := q["parent_id"]; ok {
+		parentID := q.Get("parent_id")
+		if parentID != "" && !tasks.ValidTaskID(parentID) {
+			reject("parent_id", "format", "parent_id must be a task ID, got %q", parentID)
+		}
+		opts.Filter.ParentID = &parentID
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Field != errs[j].Field {
+			return errs[i].Field < errs[j].Field
+		}
+		return errs[i].Message < errs[j].Message
+	})
+	return opts, errs
+}
+
+// parseQueryTime parses an RFC 3339 time from a query parameter. A + in a
+// query decodes to a space unless it is escaped as %2B, and RFC 3339 times
+// have no spaces, so a space is taken to be the + of an offset such as
+// +02:00 which was not escaped.
+func parseQueryTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.Replace(s, " ", "+", 1))
 }
@@ -0,0 +1,70 @@
+package taskhttp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+type replaceTaskRequest struct {
+	// ID may be given for symmetry with the task's representation, but
+	// must then match the ID in the path.
+	ID         string     `json:"id"`
+	Text       string     `json:"text"`
+	IsComplete bool       `json:"is_complete"`
+	DueAt      *time.Time `json:"due_at"`
+	List       string     `json:"list"`
+	ParentID   string     `json:"parent_id"`
+}
+
+func (h *Handler) tasksReplace() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id  = chi.URLParam(r, "id")
+			req replaceTaskRequest
+		)
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err, zap.String("task_id", id))
+			return
+		}
+
+		errs := validateText("text", req.Text, true)
+		errs = append(errs, validateText("list", req.List, false)...)
+		errs = append(errs, validateParent("parent_id", req.ParentID, id)...)
+		if req.ID != "" && req.ID != id {
+			errs = append(errs, &tasks.FieldError{Field: "id", Code: "mismatch", Message: "must match the id in the path"})
+		}
+		if len(errs) > 0 {
+			h.respondError(w, r, tasks.Invalid(errs...), zap.String("task_id", id))
+			return
+		}
+
+		task := &tasks.Task{
+			ID:         id,
+			Text:       req.Text,
+			IsComplete: req.IsComplete,
+			DueAt:      req.DueAt,
+			List:       req.List,
+			ParentID:   req.ParentID,
+		}
+
+		created, err := h.repo.UpsertTask(task)
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to replace task: %w", err), zap.String("task_id", id))
+			return
+		}
+
+		if !created {
+			respond(w, r, http.StatusOK, h.linkTask(r, newTaskResource(task)))
+			return
+		}
+
+		w.Header().Set("Location", r.URL.Path)
+		respond(w, r, http.StatusCreated, h.linkTask(r, newTaskResource(task)))
+	}
+}
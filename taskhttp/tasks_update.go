@@ -1,74 +1,41 @@
 package taskhttp
 
 import (
+	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
 	"go.uber.org/zap"
 
 	"example.com/tasks"
 )
 
 func (h *Handler) tasksUpdate() http.HandlerFunc {
-	type request struct {
-		Text       string `json:"text"`
-		IsComplete bool   `json:"is_complete"`
-	}
-	type response struct {
-		ID         string    `json:"id"`
-		CreatedAt  time.Time `json:"created_at"`
-		UpdatedAt  time.Time `json:"updated_at"`
-		Text       string    `json:"text"`
-		IsComplete bool      `json:"is_complete"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			requestID = middleware.GetReqID(r.Context())
-			id        = chi.URLParam(r, "id")
-			req       request
-		)
-		if err := decode(r, &req); err != nil {
-			h.logger.Error("failed to decode request",
-				zap.String("request_id", requestID),
-				zap.String("task_id", id),
-				zap.Error(err),
-			)
-			internalServerError(w)
+		id := chi.URLParam(r, "id")
+
+		mt, data, err := readBody(r, patchTypes...)
+		if err != nil {
+			if tasks.KindOf(err) == tasks.KindUnsupported {
+				w.Header().Set("Accept-Patch", strings.Join(patchTypes, ", "))
+			}
+			h.respondError(w, r, err, zap.String("task_id", id))
 			return
 		}
 
-		task := &tasks.Task{
-			Text:       req.Text,
-			IsComplete: req.IsComplete,
+		patch, err := h.parsePatch(mt, data)
+		if err != nil {
+			h.respondError(w, r, err, zap.String("task_id", id))
+			return
 		}
 
-		task, err := h.repo.UpdateTask(id, task)
-		if err == tasks.ErrTaskNotFound {
-			h.logger.Warn("task not found",
-				zap.String("request_id", requestID),
-				zap.String("task_id", id),
-				zap.Error(err),
-			)
-			respondJSONError(w, http.StatusNotFound, "task not found")
-			return
-		} else if err != nil {
-			h.logger.Error("failed to update task",
-				zap.String("request_id", requestID),
-				zap.String("task_id", id),
-				zap.Error(err),
-			)
-			internalServerError(w)
+		task, err := h.repo.PatchTask(id, patch)
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to update task: %w", err), zap.String("task_id", id))
 			return
 		}
 
-		respondJSON(w, http.StatusOK, &response{
-			ID:         task.ID,
-			CreatedAt:  task.CreatedAt,
-			UpdatedAt:  task.UpdatedAt,
-			Text:       task.Text,
-			IsComplete: task.IsComplete,
-		})
+		respond(w, r, http.StatusOK, h.linkTask(r, newTaskResource(task)))
 	}
 }
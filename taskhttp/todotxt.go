@@ -0,0 +1,109 @@
+package taskhttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/todotxt"
+)
+
+const (
+	todoType = "text/plain"
+
+	// todoPageSize is the number of tasks fetched at a time by an export.
+	todoPageSize = 500
+)
+
+// todoImportParams are the query parameters of a todo.txt import. Its lines
+// have no columns to map.
+var todoImportParams = map[string]bool{
+	"dry_run":    true,
+	"batch_size": true,
+}
+
+// todoRows reads the rows of a todo.txt import, a task on each line which is
+// not blank. Rows are numbered by line.
+type todoRows struct {
+	reader *todotxt.Reader
+}
+
+func (rows *todoRows) next() (*importRow, error) {
+	t, err := rows.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == bufio.ErrTooLong {
+		return nil, tasks.NewError(tasks.KindMalformed,
+			fmt.Sprintf("line %d is too long", rows.reader.Line()+1))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read todo.txt: %w", err)
+	}
+
+	return &importRow{number: rows.reader.Line(), task: t}, nil
+}
+
+func (h *Handler) todoExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := tasks.ListOptions{Limit: todoPageSize}
+
+		// The first page is fetched before the response begins, so that a
+		// repository which fails outright is reported as a problem.
+		page, err := h.repo.ListTasks(opts)
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to list tasks: %w", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", todoType+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		tw := todotxt.NewWriter(w)
+		for {
+			for _, t := range page.Tasks {
+				if err := tw.Write(t); err != nil {
+					// The client has most likely gone away.
+					return
+				}
+			}
+			if page.Next == nil {
+				break
+			}
+
+			opts.Cursor = page.Next
+			if page, err = h.repo.ListTasks(opts); err != nil {
+				// The response has begun, so the export is cut short, which
+				// the client sees as a truncated body.
+				h.logger.Error("todo.txt export failed",
+					zap.String("request_id", middleware.GetReqID(r.Context())),
+					zap.Error(err),
+				)
+				break
+			}
+		}
+		tw.Flush()
+	}
+}
+
+func (h *Handler) todoImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, errs := parseImportOptions(r.URL.Query(), todoImportParams)
+		if len(errs) > 0 {
+			h.respondError(w, r, malformedQuery(errs))
+			return
+		}
+
+		if _, err := bodyType(r, todoType); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		h.runImport(w, r, opts, &todoRows{reader: todotxt.NewReader(r.Body)})
+	}
+}
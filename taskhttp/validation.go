@@ -0,0 +1,232 @@
+package taskhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/tasks"
+)
+
+// decode decodes the JSON body of r into v. A *tasks.Error is returned if the
+// body is not JSON or, in strict mode, has fields which v does not.
+func (h *Handler) decode(r *http.Request, v interface{}) error {
+	_, data, err := readBody(r, "application/json")
+	if err != nil {
+		return err
+	}
+
+	return h.unmarshal(data, v)
+}
+
+// readBody reads the body of r, which must have one of the given media types.
+// A missing Content-Type is taken to be the first of them. The media type of
+// the body is returned along with it.
+func readBody(r *http.Request, types ...string) (string, []byte, error) {
+	mt, err := bodyType(r, types...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read from source during decode: %w", err)
+	}
+
+	if len(data) == 0 {
+		return "", nil, tasks.NewError(tasks.KindMalformed, "request body is empty")
+	}
+
+	return mt, data, nil
+}
+
+// bodyType returns the media type of the body of r, which must be one of the
+// given types. A missing Content-Type is taken to be the first of them.
+func bodyType(r *http.Request, types ...string) (string, error) {
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return types[0], nil
+	}
+
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil || !contains(types, mt) {
+		msg := "content type must be " + types[0]
+		if len(types) > 1 {
+			msg = "content type must be one of " + strings.Join(types, ", ")
+		}
+		return "", tasks.NewError(tasks.KindUnsupported, msg)
+	}
+
+	return mt, nil
+}
+
+// unmarshal decodes JSON data into v, as decode does.
+func (h *Handler) unmarshal(data []byte, v interface{}) error {
+	if err := unmarshalJSON(data, v); err != nil {
+		return err
+	}
+
+	if h.strict {
+		if fields := unknownFields(data, reflect.TypeOf(v), ""); len(fields) > 0 {
+			return tasks.Invalid(fields...)
+		}
+	}
+
+	return nil
+}
+
+// unmarshalJSON decodes JSON data into v, describing any problem with the
+// JSON as a *tasks.Error.
+func unmarshalJSON(data []byte, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		var (
+			syntaxErr *json.SyntaxError
+			typeErr   *json.UnmarshalTypeError
+			timeErr   *time.ParseError
+		)
+		switch {
+		case errors.As(err, &syntaxErr):
+			return tasks.NewError(tasks.KindMalformed, "malformed json: "+syntaxErr.Error())
+		case errors.As(err, &typeErr) && typeErr.Field == "":
+			return tasks.NewError(tasks.KindMalformed, "malformed json: body must be "+jsonType(typeErr.Type))
+		case errors.As(err, &typeErr):
+			return tasks.Invalid(&tasks.FieldError{
+				Field:   typeErr.Field,
+				Code:    "type",
+				Message: "must be " + jsonType(typeErr.Type),
+			})
+		case errors.As(err, &timeErr):
+			// encoding/json does not say which field held the time.
+			return tasks.NewError(tasks.KindMalformed, "malformed json: times must be RFC 3339, got "+timeErr.Value)
+		}
+
+		return fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+
+	return nil
+}
+
+// validateText checks task text found at field, as tasks.ValidateText does,
+// for appending to the other problems with a request.
+func validateText(field, text string, required bool) []*tasks.FieldError {
+	if fe := tasks.ValidateText(field, text, required); fe != nil {
+		return []*tasks.FieldError{fe}
+	}
+	return nil
+}
+
+// validateParent checks the ID of the parent found at field of the task with
+// id, which is empty for a task yet to be created. An empty parent ID means
+// the task is not a subtask.
+func validateParent(field, parentID, id string) []*tasks.FieldError {
+	switch {
+	case parentID == "":
+		return nil
+	case !tasks.ValidTaskID(parentID):
+		return []*tasks.FieldError{{Field: field, Code: "format", Message: "must be a task ID"}}
+	case parentID == id:
+		return []*tasks.FieldError{{Field: field, Code: "self", Message: "must not be the task itself"}}
+	}
+
+	return nil
+}
+
+// unknownFields finds the fields of the JSON in data which do not correspond
+// to a field of t, descending into objects and arrays. The paths of the fields
+// found are prefixed by path.
+func unknownFields(data []byte, t reflect.Type, path string) []*tasks.FieldError {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var errs []*tasks.FieldError
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil
+		}
+
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		known := jsonFields(t)
+		for _, name := range names {
+			field := name
+			if path != "" {
+				field = path + "." + name
+			}
+
+			i, ok := lookupField(known, name)
+			if !ok {
+				errs = append(errs, &tasks.FieldError{Field: field, Code: "unknown", Message: "is not a known field"})
+				continue
+			}
+			errs = append(errs, unknownFields(obj[name], t.Field(i).Type, field)...)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil
+		}
+
+		for i, item := range items {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errs
+}
+
+// lookupField finds a field by its JSON name, falling back to the case
+// insensitive match encoding/json decodes into.
+func lookupField(known map[string]int, name string) (int, bool) {
+	if i, ok := known[name]; ok {
+		return i, true
+	}
+
+	for k, i := range known {
+		if strings.EqualFold(k, name) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType describes a Go type in JSON terms.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	default:
+		return "a number"
+	}
+}
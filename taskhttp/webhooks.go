@@ -0,0 +1,315 @@
+package taskhttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+)
+
+const (
+	// minSecretLength is the shortest secret accepted for signing webhook
+	// deliveries.
+	minSecretLength = 16
+
+	// defaultDeliveryLimit and maxDeliveryLimit bound the number of delivery
+	// attempts listed at once.
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 100
+)
+
+// webhookEvents are the event types webhooks may subscribe to.
+var webhookEvents = map[string]bool{
+	"created": true,
+	"updated": true,
+	"deleted": true,
+}
+
+// webhookResource is the representation of a webhook in responses. The secret
+// is only included when the webhook is created.
+type webhookResource struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Disabled  bool      `json:"disabled"`
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newWebhookResource(w *tasks.Webhook) *webhookResource {
+	res := &webhookResource{
+		ID:        w.ID,
+		URL:       w.URL,
+		Events:    w.Events,
+		Disabled:  w.Disabled,
+		Failures:  w.Failures,
+		CreatedAt: w.CreatedAt,
+		UpdatedAt: w.UpdatedAt,
+	}
+	if res.Events == nil {
+		res.Events = []string{}
+	}
+	return res
+}
+
+type deliveryResource struct {
+	ID         string    `json:"id"`
+	EventID    uint64    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type createWebhookRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+func (h *Handler) webhooksCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req createWebhookRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		errs := validateWebhook(req.URL, req.Secret, req.Events)
+		if len(errs) > 0 {
+			h.respondError(w, r, tasks.Invalid(errs...))
+			return
+		}
+
+		if req.Secret == "" {
+			secret, err := newSecret()
+			if err != nil {
+				h.respondError(w, r, fmt.Errorf("failed to create webhook: %w", err))
+				return
+			}
+			req.Secret = secret
+		}
+
+		hook := &tasks.Webhook{URL: req.URL, Secret: req.Secret, Events: req.Events}
+		if err := h.webhooks.CreateWebhook(hook); err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to create webhook: %w", err))
+			return
+		}
+
+		res := newWebhookResource(hook)
+		res.Secret = hook.Secret
+
+		w.Header().Set("Location", r.URL.Path+"/"+hook.ID)
+		respond(w, r, http.StatusCreated, res)
+	}
+}
+
+type webhookListResponse struct {
+	Length int                `json:"length"`
+	Items  []*webhookResource `json:"items"`
+}
+
+func (h *Handler) webhooksList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks, err := h.webhooks.ListWebhooks()
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to list webhooks: %w", err))
+			return
+		}
+
+		res := &webhookListResponse{Length: len(hooks), Items: make([]*webhookResource, len(hooks))}
+		for i, hook := range hooks {
+			res.Items[i] = newWebhookResource(hook)
+		}
+
+		respond(w, r, http.StatusOK, res)
+	}
+}
+
+func (h *Handler) webhooksRetrieve() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "webhookID")
+
+		hook, err := h.webhooks.RetrieveWebhook(id)
+		if err != nil {
+			h.respondError(w, r, err, zap.String("webhook_id", id))
+			return
+		}
+
+		respond(w, r, http.StatusOK, newWebhookResource(hook))
+	}
+}
+
+type updateWebhookRequest struct {
+	URL      *string   `json:"url"`
+	Secret   *string   `json:"secret"`
+	Events   *[]string `json:"events"`
+	Disabled *bool     `json:"disabled"`
+}
+
+func (h *Handler) webhooksUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "webhookID")
+
+		var req updateWebhookRequest
+		if err := h.decode(r, &req); err != nil {
+			h.respondError(w, r, err)
+			return
+		}
+
+		hook, err := h.webhooks.RetrieveWebhook(id)
+		if err != nil {
+			h.respondError(w, r, err, zap.String("webhook_id", id))
+			return
+		}
+
+		if req.URL != nil {
+			hook.URL = *req.URL
+		}
+		if req.Secret != nil {
+			hook.Secret = *req.Secret
+		}
+		if req.Events != nil {
+			hook.Events = *req.Events
+		}
+		if req.Disabled != nil {
+			hook.Disabled = *req.Disabled
+		}
+
+		errs := validateWebhook(hook.URL, hook.Secret, hook.Events)
+		if hook.Secret == "" {
+			errs = append(errs, &tasks.FieldError{Field: "secret", Code: "required", Message: "cannot be removed"})
+		}
+		if len(errs) > 0 {
+			h.respondError(w, r, tasks.Invalid(errs...))
+			return
+		}
+
+		if err := h.webhooks.UpdateWebhook(hook); err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to update webhook: %w", err), zap.String("webhook_id", id))
+			return
+		}
+
+		respond(w, r, http.StatusOK, newWebhookResource(hook))
+	}
+}
+
+func (h *Handler) webhooksDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "webhookID")
+
+		if err := h.webhooks.DeleteWebhook(id); err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to delete webhook: %w", err), zap.String("webhook_id", id))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type deliveryListResponse struct {
+	Length int                 `json:"length"`
+	Items  []*deliveryResource `json:"items"`
+}
+
+func (h *Handler) webhooksDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "webhookID")
+
+		limit := defaultDeliveryLimit
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				h.respondError(w, r, malformedQuery([]*tasks.FieldError{{
+					Field:   "limit",
+					Code:    "type",
+					Message: fmt.Sprintf("limit must be a positive integer, got %q", s),
+				}}))
+				return
+			}
+			if limit = n; limit > maxDeliveryLimit {
+				limit = maxDeliveryLimit
+			}
+		}
+
+		if _, err := h.webhooks.RetrieveWebhook(id); err != nil {
+			h.respondError(w, r, err, zap.String("webhook_id", id))
+			return
+		}
+
+		ds, err := h.webhooks.ListWebhookDeliveries(id, limit)
+		if err != nil {
+			h.respondError(w, r, fmt.Errorf("failed to list webhook deliveries: %w", err), zap.String("webhook_id", id))
+			return
+		}
+
+		res := &deliveryListResponse{Length: len(ds), Items: make([]*deliveryResource, len(ds))}
+		for i, d := range ds {
+			res.Items[i] = &deliveryResource{
+				ID:         d.ID,
+				EventID:    d.EventID,
+				EventType:  d.EventType,
+				Attempt:    d.Attempt,
+				StatusCode: d.StatusCode,
+				Error:      d.Error,
+				DurationMS: int64(d.Duration / time.Millisecond),
+				CreatedAt:  d.CreatedAt,
+			}
+		}
+
+		respond(w, r, http.StatusOK, res)
+	}
+}
+
+// validateWebhook checks the fields of a webhook. An empty secret is allowed,
+// as one is generated on creation.
+func validateWebhook(rawURL, secret string, events []string) []*tasks.FieldError {
+	var errs []*tasks.FieldError
+
+	if rawURL == "" {
+		errs = append(errs, &tasks.FieldError{Field: "url", Code: "required", Message: "is required"})
+	} else if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, &tasks.FieldError{Field: "url", Code: "format", Message: "must be an absolute http or https URL"})
+	}
+
+	if secret != "" && len(secret) < minSecretLength {
+		errs = append(errs, &tasks.FieldError{
+			Field:   "secret",
+			Code:    "min_length",
+			Message: fmt.Sprintf("must be at least %d characters", minSecretLength),
+		})
+	}
+
+	for i, e := range events {
+		if !webhookEvents[e] {
+			errs = append(errs, &tasks.FieldError{
+				Field:   fmt.Sprintf("events[%d]", i),
+				Code:    "enum",
+				Message: "must be one of created, updated or deleted",
+			})
+		}
+	}
+
+	return errs
+}
+
+// newSecret generates a random secret for signing deliveries.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
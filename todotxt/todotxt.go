@@ -0,0 +1,283 @@
+// Package todotxt reads and writes tasks in the todo.txt format described at
+// https://github.com/todotxt/todo.txt.
+//
+// Each line holds one task. Its completion mark, priority, and completion and
+// creation dates map onto the fields of tasks.Task, and the rest of the line
+// is the task's text, +project and @context tags and key:value extras
+// included. The fields the format has no place for are written as extras
+// after the text: the ID of a task as id:ID, the list it is on as list:LIST
+// with the name query escaped, the ID of its parent as parent:ID, its due date
+// as the conventional due:YYYY-MM-DD, and the priority of a completed task,
+// which the format only gives to open tasks, as pri:P. When a line is read
+// these extras are taken out of the text, the last of each key winning, so
+// that a task's own fields take precedence over extras earlier in its text.
+//
+// Lines written by Format are read back by Parse unchanged. Tasks read back
+// from the lines written for them keep every field except the time of day of
+// their dates, which the format does not hold, and UpdatedAt; a task whose
+// text holds a due: extra, or a pri: extra if it is completed, without the
+// field being set reads back with the field taken from its text.
+package todotxt
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/tasks"
+)
+
+// dateLayout is the layout of the dates in a line.
+const dateLayout = "2006-01-02"
+
+const (
+	// idKey is the key of the extra holding the ID of a task.
+	idKey = "id"
+
+	// priorityKey is the key of the extra holding the priority of a
+	// completed task.
+	priorityKey = "pri"
+
+	// dueKey is the key of the extra holding the due date of a task.
+	dueKey = "due"
+
+	// listKey is the key of the extra holding the list a task is on.
+	listKey = "list"
+
+	// parentKey is the key of the extra holding the ID of a task's parent.
+	parentKey = "parent"
+)
+
+// Parse parses a line into a task. Anything which is not a completion mark,
+// priority, date or ID where the format expects one is part of the task's
+// text, so every line can be parsed.
+func Parse(line string) *tasks.Task {
+	t := &tasks.Task{}
+	rest := strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(rest, "x ") {
+		t.IsComplete = true
+		rest = rest[2:]
+
+		// A creation date may only follow a completion date.
+		if completed, r, ok := date(rest); ok {
+			t.CompletedAt, rest = &completed, r
+			if created, r, ok := date(rest); ok {
+				t.CreatedAt, rest = created, r
+			}
+		}
+	} else {
+		if len(rest) > 3 && rest[0] == '(' && tasks.ValidPriority(rest[1:2]) && rest[2:4] == ") " {
+			t.Priority, rest = rest[1:2], rest[4:]
+		}
+		if created, r, ok := date(rest); ok {
+			t.CreatedAt, rest = created, r
+		}
+	}
+
+	words := strings.Split(rest, " ")
+	words, t.ID = takeExtra(words, idKey, func(v string) (string, bool) {
+		return v, tasks.ValidTaskID(v)
+	})
+	words, t.ParentID = takeExtra(words, parentKey, func(v string) (string, bool) {
+		return v, tasks.ValidTaskID(v)
+	})
+	words, t.List = takeExtra(words, listKey, func(v string) (string, bool) {
+		list, err := url.QueryUnescape(v)
+		return list, err == nil && list != ""
+	})
+
+	var due string
+	words, due = takeExtra(words, dueKey, func(v string) (string, bool) {
+		_, err := time.Parse(dateLayout, v)
+		return v, err == nil
+	})
+	if due != "" {
+		d, _ := time.Parse(dateLayout, due)
+		t.DueAt = &d
+	}
+
+	// Open tasks have their priority at the start of the line, so a pri:
+	// extra is only theirs when they are completed.
+	if t.IsComplete {
+		words, t.Priority = takeExtra(words, priorityKey, func(v string) (string, bool) {
+			return v, tasks.ValidPriority(v)
+		})
+	}
+
+	t.Text = strings.Join(words, " ")
+
+	return t
+}
+
+// takeExtra finds the last of words which is a key:value extra whose value
+// parse accepts, and returns words without it and the value parsed. Format
+// writes the extras it takes after the text, so the last is the one it wrote.
+func takeExtra(words []string, key string, parse func(string) (string, bool)) ([]string, string) {
+	for i := len(words) - 1; i >= 0; i-- {
+		v := strings.TrimPrefix(words[i], key+":")
+		if v == words[i] {
+			continue
+		}
+		if parsed, ok := parse(v); ok {
+			return append(words[:i:i], words[i+1:]...), parsed
+		}
+	}
+	return words, ""
+}
+
+// date parses the date at the start of s, returning it and the rest of s
+// after the space which must follow it.
+func date(s string) (time.Time, string, bool) {
+	if len(s) <= len(dateLayout) || s[len(dateLayout)] != ' ' {
+		return time.Time{}, s, false
+	}
+
+	d, err := time.Parse(dateLayout, s[:len(dateLayout)])
+	if err != nil {
+		return time.Time{}, s, false
+	}
+	return d, s[len(dateLayout)+1:], true
+}
+
+// Format formats t as a line, without a line ending. A completed task without
+// a CompletedAt is taken to have been completed when it was last updated.
+func Format(t *tasks.Task) string {
+	var b strings.Builder
+
+	if t.IsComplete {
+		b.WriteString("x ")
+
+		completed := t.UpdatedAt
+		if t.CompletedAt != nil {
+			completed = *t.CompletedAt
+		}
+		if !completed.IsZero() {
+			b.WriteString(completed.UTC().Format(dateLayout) + " ")
+			if !t.CreatedAt.IsZero() {
+				b.WriteString(t.CreatedAt.UTC().Format(dateLayout) + " ")
+			}
+		}
+	} else {
+		if t.Priority != "" {
+			b.WriteString("(" + t.Priority + ") ")
+		}
+		if !t.CreatedAt.IsZero() {
+			b.WriteString(t.CreatedAt.UTC().Format(dateLayout) + " ")
+		}
+	}
+
+	b.WriteString(t.Text)
+
+	if t.IsComplete && t.Priority != "" {
+		b.WriteString(" " + priorityKey + ":" + t.Priority)
+	}
+	if t.DueAt != nil {
+		b.WriteString(" " + dueKey + ":" + t.DueAt.UTC().Format(dateLayout))
+	}
+	if t.List != "" {
+		b.WriteString(" " + listKey + ":" + url.QueryEscape(t.List))
+	}
+	if t.ParentID != "" {
+		b.WriteString(" " + parentKey + ":" + t.ParentID)
+	}
+	if t.ID != "" {
+		b.WriteString(" " + idKey + ":" + t.ID)
+	}
+
+	return b.String()
+}
+
+// Projects returns the +project tags in text, without the +.
+func Projects(text string) []string {
+	return tags(text, '+')
+}
+
+// Contexts returns the @context tags in text, without the @.
+func Contexts(text string) []string {
+	return tags(text, '@')
+}
+
+func tags(text string, mark byte) []string {
+	var found []string
+	for _, w := range strings.Fields(text) {
+		if len(w) > 1 && w[0] == mark {
+			found = append(found, w[1:])
+		}
+	}
+	return found
+}
+
+// Extras returns the key:value extras in text. Words whose value starts with
+// //, such as URLs, are not extras. The first value of a repeated key is
+// returned.
+func Extras(text string) map[string]string {
+	extras := make(map[string]string)
+	for _, w := range strings.Fields(text) {
+		i := strings.IndexByte(w, ':')
+		if i < 1 || i == len(w)-1 || strings.HasPrefix(w[i+1:], "//") || strings.IndexByte(w[i+1:], ':') >= 0 {
+			continue
+		}
+		if _, ok := extras[w[:i]]; !ok {
+			extras[w[:i]] = w[i+1:]
+		}
+	}
+	return extras
+}
+
+// Reader reads tasks from a todo.txt file.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader which reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(r)}
+}
+
+// Read reads the task on the next line which is not blank. It returns io.EOF
+// when there are no more tasks.
+func (r *Reader) Read() (*tasks.Task, error) {
+	for r.scanner.Scan() {
+		r.line++
+		if line := r.scanner.Text(); strings.TrimSpace(line) != "" {
+			return Parse(line), nil
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Line returns the number of the line the last task was read from, counting
+// from 1.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Writer writes tasks to a todo.txt file. Writes are buffered, so Flush must
+// be called once the last task has been written.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer which writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes t on a line of its own.
+func (w *Writer) Write(t *tasks.Task) error {
+	_, err := w.w.WriteString(Format(t) + "\n")
+	return err
+}
+
+// Flush writes any buffered tasks.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
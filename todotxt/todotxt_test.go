@@ -0,0 +1,117 @@
+package todotxt
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"example.com/tasks"
+)
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+
+	task := Parse("(A) 2020-03-01 Call mom +family @phone rec:1w due:2020-03-05")
+	is.Equal(task.Priority, "A")                                                   // Priority is parsed
+	is.Equal(task.CreatedAt, time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)) // Creation date is parsed
+	is.Equal(task.Text, "Call mom +family @phone rec:1w")                          // Tags and other extras stay in the text
+	is.True(!task.IsComplete)                                                      // Task is not complete
+	is.Equal(Projects(task.Text), []string{"family"})                              // Projects are found
+	is.Equal(Contexts(task.Text), []string{"phone"})                               // Contexts are found
+	is.Equal(Extras(task.Text), map[string]string{"rec": "1w"})                    // Extras are found
+	is.Equal(*task.DueAt, time.Date(2020, time.March, 5, 0, 0, 0, 0, time.UTC))    // Due date is taken from its extra
+
+	id := tasks.NewTaskID()
+	task = Parse("x 2020-03-02 2020-03-01 Pay rent pri:B id:" + id + " see:http://example.com")
+	is.True(task.IsComplete)                                                          // Completion mark is parsed
+	is.Equal(*task.CompletedAt, time.Date(2020, time.March, 2, 0, 0, 0, 0, time.UTC)) // Completion date is parsed
+	is.Equal(task.CreatedAt, time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC))    // Creation date follows completion date
+	is.Equal(task.Priority, "B")                                                      // Completed tasks keep their priority as an extra
+	is.Equal(task.ID, id)                                                             // ID is taken from its extra
+	is.Equal(task.Text, "Pay rent see:http://example.com")                            // ID and priority are removed from the text
+	is.Equal(Extras(task.Text), map[string]string{})                                  // URLs are not extras
+
+	task = Parse("Read pri:B due:2020-02-30")
+	is.Equal(task.Priority, "")                      // Open tasks take their priority from the start of the line
+	is.Equal(task.DueAt, (*time.Time)(nil))          // Invalid due dates are not taken
+	is.Equal(task.Text, "Read pri:B due:2020-02-30") // Extras which are not taken stay in the text
+
+	task = Parse("xylophone lessons (A) 2020-01-01")
+	is.True(!task.IsComplete)                               // Completion mark must be followed by a space
+	is.Equal(task.Priority, "")                             // Priority must start the line
+	is.Equal(task.Text, "xylophone lessons (A) 2020-01-01") // Everything else is text
+}
+
+func TestRoundTrip(t *testing.T) {
+	is := is.New(t)
+
+	id := tasks.NewTaskID()
+	parent := tasks.NewTaskID()
+	lines := []string{
+		"(A) 2020-03-01 Call mom +family @phone due:2020-03-05 id:" + id,
+		"Buy milk list:Weekly+shop parent:" + parent + " id:" + id,
+		"dup of id:" + parent + " id:" + id,
+		"x 2020-03-02 2020-03-01 Pay rent pri:B",
+		"2020-03-01 (B) is part of the text",
+		"x 2020-03-02 2020-03-01 2020-01-01 is part of the text too",
+		"plain",
+	}
+	for _, line := range lines {
+		is.Equal(Format(Parse(line)), line) // Lines are read back unchanged
+	}
+	is.Equal(Parse("dup of id:"+parent+" id:"+id).ID, id) // The ID written last is the task's
+
+	is.Equal(Parse("x 2020-03-02 Pay rent pri:A pri:B").Priority, "B")          // The priority written last is the task's
+	is.Equal(Parse("x 2020-03-02 Pay rent pri:A pri:B").Text, "Pay rent pri:A") // Earlier extras stay in the text
+}
+
+func TestRoundTripTasks(t *testing.T) {
+	is := is.New(t)
+
+	created := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	completed := time.Date(2020, time.March, 2, 0, 0, 0, 0, time.UTC)
+	due := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	id := tasks.NewTaskID()
+	parent := tasks.NewTaskID()
+
+	ts := []*tasks.Task{
+		{ID: id, Text: "foo", DueAt: &due},
+		{ID: id, Text: "foo due:2026-12-01", DueAt: &due},
+		{ID: id, CreatedAt: created, Text: "Call mom +family", Priority: "A", DueAt: &due},
+		{ID: id, Text: "foo pri:A", Priority: "B"},
+		{ID: id, CreatedAt: created, Text: "Pay rent pri:A", IsComplete: true, Priority: "B", CompletedAt: &completed},
+		{ID: id, CreatedAt: created, Text: "Write report @work", IsComplete: true, DueAt: &completed, Priority: "C", CompletedAt: &completed, List: "Work: Q1", ParentID: parent},
+		{ID: id, Text: "list:Other parent:" + parent, List: "Work", ParentID: id},
+	}
+	for _, task := range ts {
+		is.Equal(Parse(Format(task)), task) // Tasks are read back unchanged
+	}
+
+	is.Equal(Format(ts[5]), "x 2020-03-02 2020-03-01 Write report @work pri:C due:2020-03-02 list:Work%3A+Q1 parent:"+parent+" id:"+id) // Completed priority, due date, list and parent are written as extras
+}
+
+func TestReaderWriter(t *testing.T) {
+	is := is.New(t)
+
+	r := NewReader(strings.NewReader("first\n\n  \r\nsecond\r\n"))
+	task, err := r.Read()
+	is.NoErr(err)                // Error from Read
+	is.Equal(task.Text, "first") // First task is read
+	task, err = r.Read()
+	is.NoErr(err)                 // Error from Read
+	is.Equal(task.Text, "second") // Blank lines are skipped
+	is.Equal(r.Line(), 4)         // Lines are counted
+	_, err = r.Read()
+	is.Equal(err, io.EOF) // Reading ends with io.EOF
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	is.NoErr(w.Write(&tasks.Task{Text: "one"})) // Error from Write
+	is.NoErr(w.Write(&tasks.Task{Text: "two"})) // Error from Write
+	is.NoErr(w.Flush())                         // Error from Flush
+	is.Equal(buf.String(), "one\ntwo\n")        // Tasks are written a line each
+}
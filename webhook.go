@@ -0,0 +1,100 @@
+package tasks
+
+import (
+	"time"
+)
+
+// ErrWebhookNotFound is returned by webhook stores when a webhook is not
+// found.
+var ErrWebhookNotFound = NewError(KindNotFound, "webhook not found")
+
+// Webhook is a subscription of another service to changes to tasks, which are
+// delivered to it by HTTP POST requests.
+type Webhook struct {
+	ID  string
+	URL string
+
+	// Secret is the key deliveries are signed with.
+	Secret string
+
+	// Events are the types of event delivered. Every event is delivered when
+	// Events is empty.
+	Events []string
+
+	// Disabled webhooks are not sent deliveries. Webhooks are disabled
+	// automatically after too many consecutive deliveries failed.
+	Disabled bool
+
+	// Failures is the number of consecutive deliveries which failed.
+	Failures int
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Wants reports whether events of type typ are delivered to the webhook.
+func (w *Webhook) Wants(typ string) bool {
+	if w.Disabled {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is the log entry of one attempt to deliver an event to a
+// webhook.
+type WebhookDelivery struct {
+	// ID identifies the delivery of an event to a webhook, and is shared by
+	// every attempt of it.
+	ID        string
+	WebhookID string
+	EventID   uint64
+	EventType string
+
+	// Attempt counts the attempts of the delivery, starting at 1.
+	Attempt int
+
+	// StatusCode is the status of the response, or zero if none was received.
+	StatusCode int
+
+	// Error describes why the attempt failed, if it did.
+	Error string
+
+	Duration  time.Duration
+	CreatedAt time.Time
+}
+
+// WebhookStore persists webhooks and the log of their deliveries.
+// Implementations must be safe for concurrent use.
+type WebhookStore interface {
+	// CreateWebhook stores a new webhook, setting its ID and timestamps.
+	CreateWebhook(w *Webhook) error
+	ListWebhooks() ([]*Webhook, error)
+	RetrieveWebhook(id string) (*Webhook, error)
+
+	// UpdateWebhook replaces the URL, Secret, Events and Disabled of the
+	// webhook with w.ID. Failures is reset when the webhook is enabled. w is
+	// set to the stored webhook.
+	UpdateWebhook(w *Webhook) error
+	DeleteWebhook(id string) error
+
+	// RecordWebhookResult counts a delivery to a webhook which succeeded or
+	// failed, disabling the webhook once maxFailures deliveries in a row
+	// have failed. The updated webhook is returned.
+	RecordWebhookResult(id string, delivered bool, maxFailures int) (*Webhook, error)
+
+	// LogWebhookDelivery adds an attempt to the log of a webhook's
+	// deliveries.
+	LogWebhookDelivery(d *WebhookDelivery) error
+
+	// ListWebhookDeliveries returns up to limit of the most recent attempts
+	// logged for a webhook, newest first.
+	ListWebhookDeliveries(webhookID string, limit int) ([]*WebhookDelivery, error)
+}
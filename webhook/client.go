@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned by the default client of a Dispatcher when
+// a webhook's URL leads to an address deliveries may not be made to.
+var ErrForbiddenAddress = errors.New("address is not public")
+
+// forbiddenNets are the ranges of addresses, besides loopback, link-local and
+// multicast ones, which are not reachable from the internet. Link-local
+// addresses include those of cloud metadata services, such as
+// 169.254.169.254.
+var forbiddenNets = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fec0::/10",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CIDR %q, this is a bug", cidr))
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// publicIP reports whether ip is reachable from the internet.
+func publicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range forbiddenNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialPublic refuses connections to addresses which are not public. It is
+// checked once names are resolved, just before each connection is made, so
+// names which resolve to private addresses, and redirects to them, are
+// refused too.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("failed to dial %s: %w", address, ErrForbiddenAddress)
+	}
+
+	return nil
+}
+
+// newClient creates the client deliveries are made with by default, which
+// only connects to public addresses so that webhooks cannot be used to reach
+// the services on the server's network.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublic,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	// A proxy would make the connections to webhooks itself, out of reach of
+	// the check.
+	transport.Proxy = nil
+
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
@@ -0,0 +1,289 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/events"
+	"example.com/tasks/taskhttp"
+)
+
+// Defaults for the options of a Dispatcher.
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoff     = 10 * time.Second
+	DefaultMaxFailures = 10
+
+	// maxBackoff caps the wait between attempts, however many there are.
+	maxBackoff = time.Hour
+)
+
+// Dispatcher delivers the events published to a broker to the webhooks in a
+// store which want them.
+//
+// Each event is delivered to each webhook in up to MaxAttempts attempts, with
+// the wait between attempts doubling each time. A delivery fails when every
+// attempt has, and webhooks are disabled after too many deliveries in a row
+// have failed. Attempts which are waiting to be retried are not persisted, so
+// they are lost if the process stops.
+type Dispatcher struct {
+	logger *zap.Logger
+	store  tasks.WebhookStore
+	broker *events.Broker
+	client *http.Client
+
+	maxAttempts int
+	backoff     time.Duration
+	maxFailures int
+
+	wg sync.WaitGroup
+}
+
+// Option configures a Dispatcher.
+type Option func(*Dispatcher)
+
+// WithClient sets the client deliveries are made with. By default they are
+// made with a client which refuses to connect to loopback, private and other
+// addresses which are not public; a client set here is trusted to make its
+// own checks.
+func WithClient(c *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// WithRetries sets the number of attempts made for each delivery, and the
+// wait before the first retry.
+func WithRetries(maxAttempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = maxAttempts
+		d.backoff = backoff
+	}
+}
+
+// WithMaxFailures sets the number of deliveries in a row which may fail
+// before a webhook is disabled.
+func WithMaxFailures(n int) Option {
+	return func(d *Dispatcher) {
+		d.maxFailures = n
+	}
+}
+
+// NewDispatcher creates a dispatcher for the webhooks in store.
+func NewDispatcher(logger *zap.Logger, store tasks.WebhookStore, broker *events.Broker, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		logger:      logger,
+		store:       store,
+		broker:      broker,
+		client:      newClient(),
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     DefaultBackoff,
+		maxFailures: DefaultMaxFailures,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Run delivers events published after it is called until ctx is done, and
+// then waits for the deliveries in progress to give up.
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer d.wg.Wait()
+
+	var lastID uint64
+	for {
+		sub := d.broker.Subscribe(d.broker.Epoch(), lastID)
+		if !sub.Resumed() {
+			d.logger.Warn("events were missed", zap.Uint64("last_event_id", lastID))
+		}
+
+		for _, e := range sub.Backlog() {
+			d.dispatch(ctx, e)
+			lastID = e.ID
+		}
+
+	receive:
+		for {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					// We fell behind, and resubscribe from where we were.
+					break receive
+				}
+				d.dispatch(ctx, e)
+				lastID = e.ID
+			}
+		}
+	}
+}
+
+// dispatch starts the delivery of e to every webhook which wants it.
+func (d *Dispatcher) dispatch(ctx context.Context, e events.Event) {
+	hooks, err := d.store.ListWebhooks()
+	if err != nil {
+		d.logger.Error("failed to dispatch event",
+			zap.Uint64("event_id", e.ID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	body, err := json.Marshal(newPayload(d.broker.Epoch(), e))
+	if err != nil {
+		panic("json marshal error on webhook payload, this is a bug")
+	}
+
+	for _, w := range hooks {
+		if !w.Wants(string(e.Type)) {
+			continue
+		}
+
+		d.wg.Add(1)
+		go func(w *tasks.Webhook) {
+			defer d.wg.Done()
+			d.deliver(ctx, w, e, body)
+		}(w)
+	}
+}
+
+// deliver makes attempts to deliver body, the payload of e, to w until one
+// succeeds or they run out.
+func (d *Dispatcher) deliver(ctx context.Context, w *tasks.Webhook, e events.Event, body []byte) {
+	delivery := tasks.NewTaskID()
+	logger := d.logger.With(
+		zap.String("webhook_id", w.ID),
+		zap.String("delivery_id", delivery),
+		zap.Uint64("event_id", e.ID),
+	)
+
+	backoff := d.backoff
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			// The webhook may have been changed while we waited.
+			var err error
+			if w, err = d.store.RetrieveWebhook(w.ID); err != nil || !w.Wants(string(e.Type)) {
+				return
+			}
+		}
+
+		log := d.attempt(ctx, w, e, body, delivery, attempt)
+		if err := d.store.LogWebhookDelivery(log); err != nil {
+			logger.Error("failed to log webhook delivery", zap.Error(err))
+		}
+
+		if log.Error == "" {
+			if _, err := d.store.RecordWebhookResult(w.ID, true, d.maxFailures); err != nil && err != tasks.ErrWebhookNotFound {
+				logger.Error("failed to record webhook result", zap.Error(err))
+			}
+			return
+		}
+
+		logger.Info("webhook delivery attempt failed",
+			zap.Int("attempt", attempt),
+			zap.String("error", log.Error),
+		)
+	}
+
+	w, err := d.store.RecordWebhookResult(w.ID, false, d.maxFailures)
+	if err != nil {
+		if err != tasks.ErrWebhookNotFound {
+			logger.Error("failed to record webhook result", zap.Error(err))
+		}
+		return
+	}
+
+	logger.Warn("webhook delivery failed", zap.Int("failures", w.Failures))
+	if w.Disabled {
+		logger.Warn("webhook disabled after too many failed deliveries")
+	}
+}
+
+// attempt makes one attempt to deliver body to w, returning its log entry.
+func (d *Dispatcher) attempt(ctx context.Context, w *tasks.Webhook, e events.Event, body []byte, delivery string, attempt int) *tasks.WebhookDelivery {
+	now := time.Now().UTC()
+	log := &tasks.WebhookDelivery{
+		ID:        delivery,
+		WebhookID: w.ID,
+		EventID:   e.ID,
+		EventType: string(e.Type),
+		Attempt:   attempt,
+		CreatedAt: now,
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Error = fmt.Sprintf("failed to create request: %v", err)
+		return log
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tasks-webhook/1")
+	req.Header.Set(EventHeader, string(e.Type))
+	req.Header.Set(DeliveryHeader, delivery)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, now, body))
+
+	res, err := d.client.Do(req.WithContext(ctx))
+	log.Duration = time.Since(now)
+	if err != nil {
+		log.Error = err.Error()
+		return log
+	}
+	defer res.Body.Close()
+
+	// Draining the body lets the connection be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	log.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		log.Error = fmt.Sprintf("unexpected status %d", res.StatusCode)
+	}
+
+	return log
+}
+
+// payload is the body of a delivery. Its ID and data are those the event
+// stream gives the event.
+type payload struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// newPayload returns the payload of e, which was published in epoch.
+func newPayload(epoch string, e events.Event) *payload {
+	return &payload{
+		ID:   taskhttp.EventID(epoch, e.ID),
+		Type: string(e.Type),
+		Time: e.Time,
+		Data: taskhttp.EventData(e),
+	}
+}
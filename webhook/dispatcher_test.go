@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"example.com/tasks"
+	"example.com/tasks/events"
+	"example.com/tasks/mock"
+	"example.com/tasks/taskhttp"
+)
+
+// receiver is a webhook receiver which fails the first failures deliveries it
+// is sent, and records the rest.
+type receiver struct {
+	t        *testing.T
+	secret   string
+	failures int
+
+	mu       sync.Mutex
+	received []*payload
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Error(err)
+	}
+
+	if !Verify(rc.secret, r.Header.Get(SignatureHeader), r.Header.Get(TimestampHeader), body, time.Now(), time.Minute) {
+		rc.t.Error("delivery has an invalid signature")
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		rc.t.Error(err)
+	}
+	rc.received = append(rc.received, &p)
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	is := is.New(t)
+
+	rc := &receiver{t: t, secret: "0123456789abcdef", failures: 2}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	store := mock.New()
+	broker := events.NewBroker(0)
+	d := NewDispatcher(zap.NewNop(), store, broker, WithRetries(3, time.Millisecond), WithMaxFailures(2), WithClient(srv.Client()))
+
+	hook := &tasks.Webhook{URL: srv.URL, Secret: rc.secret, Events: []string{"created"}}
+	is.NoErr(store.CreateWebhook(hook)) // Error from CreateWebhook
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventually(t, func() bool { return broker.Subscribers() == 1 })
+
+	task := &tasks.Task{ID: tasks.NewTaskID(), Text: "testing", List: "home"}
+	broker.Publish(events.Updated, task)
+	broker.Publish(events.Created, task)
+	eventually(t, func() bool {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return len(rc.received) > 0
+	})
+
+	rc.mu.Lock()
+	p := rc.received[0]
+	rc.mu.Unlock()
+	is.Equal(p.Type, "created")                                  // Only wanted events are delivered
+	is.Equal(p.ID, taskhttp.EventID(broker.Epoch(), 2))          // Payload has the event's ID in the event stream
+	is.Equal(p.Data.(map[string]interface{})["text"], "testing") // Payload holds the task
+	is.Equal(p.Data.(map[string]interface{})["list"], "home")    // Payload holds the task as the API represents it
+
+	var log []*tasks.WebhookDelivery
+	eventually(t, func() bool {
+		log, _ = store.ListWebhookDeliveries(hook.ID, 10)
+		return len(log) == 3
+	})
+	is.Equal(log[0].ID, log[2].ID)                             // Retries share the delivery ID
+	is.Equal(log[2].Attempt, 1)                                // First attempt is logged
+	is.Equal(log[2].StatusCode, http.StatusServiceUnavailable) // Failed attempts are logged with their status
+	is.Equal(log[0].Attempt, 3)                                // Delivery succeeded on the third attempt
+	is.Equal(log[0].Error, "")                                 // Successful attempts have no error
+
+	// Every attempt now fails, so two failed deliveries disable the hook.
+	rc.mu.Lock()
+	rc.failures = 1 << 30
+	rc.mu.Unlock()
+
+	broker.Publish(events.Created, task)
+	broker.Publish(events.Created, task)
+	eventually(t, func() bool {
+		hook, _ = store.RetrieveWebhook(hook.ID)
+		return hook.Disabled
+	})
+	is.Equal(hook.Failures, 2) // Failures are counted
+}
+
+func TestVerify(t *testing.T) {
+	is := is.New(t)
+
+	now := time.Now()
+	stamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"id":1}`)
+	sig := "sha256=" + Sign("secret", now, body)
+
+	is.True(Verify("secret", sig, stamp, body, now, time.Minute))                     // Signature is valid
+	is.True(!Verify("other", sig, stamp, body, now, time.Minute))                     // Secret must match
+	is.True(!Verify("secret", sig, stamp, []byte(`{"id":2}`), now, time.Minute))      // Body must match
+	is.True(!Verify("secret", sig, stamp, body, now.Add(2*time.Minute), time.Minute)) // Old deliveries are rejected
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	is := is.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := newClient().Post(srv.URL, "application/json", nil)
+	is.True(errors.Is(err, ErrForbiddenAddress)) // Loopback addresses are refused
+
+	for _, addr := range []string{"10.1.2.3", "172.20.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fd00:ec2::254", "fe80::1", "::ffff:127.0.0.1"} {
+		is.True(!publicIP(net.ParseIP(addr))) // Private and metadata addresses are not public
+	}
+	is.True(publicIP(net.ParseIP("93.184.216.34")))        // Public IPv4 addresses are allowed
+	is.True(publicIP(net.ParseIP("2606:2800:220:1::248"))) // Public IPv6 addresses are allowed
+}
@@ -0,0 +1,58 @@
+// Package webhook delivers the events published for changes to tasks to the
+// webhooks subscribed to them.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// The headers sent with every delivery.
+const (
+	// EventHeader holds the type of the event.
+	EventHeader = "X-Tasks-Event"
+
+	// DeliveryHeader holds the ID of the delivery, which is the same for
+	// every attempt, so that receivers can ignore repeats.
+	DeliveryHeader = "X-Tasks-Delivery"
+
+	// TimestampHeader holds the time the attempt was signed, in seconds
+	// since the Unix epoch.
+	TimestampHeader = "X-Tasks-Timestamp"
+
+	// SignatureHeader holds "sha256=" followed by the hex encoded signature
+	// made by Sign.
+	SignatureHeader = "X-Tasks-Signature"
+)
+
+// Sign returns the HMAC-SHA256, keyed by secret, of the timestamp and the body
+// of a delivery joined by ".". Signing the timestamp lets receivers reject
+// replays of old deliveries.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature and timestamp are the values of the
+// SignatureHeader and TimestampHeader of a delivery of body signed with
+// secret no more than tolerance before now.
+func Verify(secret, signature, timestamp string, body []byte, now time.Time, tolerance time.Duration) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	t := time.Unix(sec, 0)
+	if d := now.Sub(t); d > tolerance || d < -tolerance {
+		return false
+	}
+
+	expected := "sha256=" + Sign(secret, t, body)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}